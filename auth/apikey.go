@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+const (
+	// APIKeyPrefix is the prefix of every API key
+	// (so secret scanners can recognize leaked keys)
+	APIKeyPrefix = "jwtauth_pat_"
+	// APIKeyHeader is the header used to send API keys
+	// (they are accepted as Bearer tokens too)
+	APIKeyHeader = "X-API-Key"
+	// TokenDataAPIKeyID is the ID of the API key used to
+	// authenticate (API keys have no JWT claims, so the token
+	// data is built from the key)
+	TokenDataAPIKeyID = "api_key_id"
+
+	apiKeyPrefixLength = len(APIKeyPrefix) + 6
+	// last usage is updated at most once per interval
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	errInvalidAPIKey    = errors.New("auth.apikey.invalid")
+	errAPIKeyNameEmpty  = errors.New("auth.apikey.name.empty")
+	errAPIKeyNotAllowed = errors.New("auth.apikey.not.allowed")
+)
+
+/*
+NewAPIKey is a VO to pass the new API key parameters
+*/
+type NewAPIKey struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+/*
+CreateAPIKey creates a new API key for the user, the
+returned plaintext key is the only place it's available
+(just its hash is stored). Scopes the user is not allowed
+to are ignored.
+*/
+func (s *Service) CreateAPIKey(u *user.CredentialInfo, nk *NewAPIKey) (*user.APIKey, string, error) {
+	if strings.TrimSpace(nk.Name) == "" {
+		return nil, "", errAPIKeyNameEmpty
+	}
+	code, err := randomCode()
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := APIKeyPrefix + code
+	k := &user.APIKey{
+		UserID:    u.ID,
+		Name:      nk.Name,
+		Prefix:    plaintext[:apiKeyPrefixLength],
+		Hash:      hashCode(plaintext),
+		Scopes:    strings.Join(intersectScopes(nk.Scopes, s.AllowedScopes(u)), " "),
+		ExpiresAt: nk.ExpiresAt,
+	}
+	if err := s.repo.SaveAPIKey(k); err != nil {
+		return nil, "", err
+	}
+	return k, plaintext, nil
+}
+
+/*
+authenticateAPIKey validates the API key returning the key
+owner and the token data (user, name, scope and key ID)
+*/
+func (s *Service) authenticateAPIKey(key string, remoteAddr string) (*user.CredentialInfo, map[string]string, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, nil, errInvalidAPIKey
+	}
+	k := s.repo.FindAPIKey(hashCode(key))
+	now := s.now()
+	if k == nil || !k.IsValid(now) {
+		return nil, nil, errInvalidAPIKey
+	}
+	u := s.repo.FindUserByID(k.UserID)
+	if u == nil || u.ID == 0 || !u.Active {
+		return nil, nil, fmt.Errorf(userNotFound)
+	}
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > apiKeyTouchInterval {
+		if err := s.repo.TouchAPIKey(k, now, remoteIP(remoteAddr)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return u, map[string]string{
+		TokenDataUser:        u.User,
+		TokenDataName:        u.Name,
+		TokenDataSubjectType: SubjectTypeUser,
+		TokenDataScope:       k.Scopes,
+		TokenDataAPIKeyID:    strconv.Itoa(k.ID),
+	}, nil
+}
+
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/user"
+	"github.com/spf13/viper"
+)
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	viper.Set("auth.jwt.scopes.default", []string{"deploy"})
+	defer viper.Set("auth.jwt.scopes.default", []string{})
+
+	svc := newTestService(t)
+	setupUser(t, "ci.user", "pass", svc)
+	u := svc.GetRepository().FindUser("ci.user")
+
+	k, plaintext, err := svc.CreateAPIKey(u, &NewAPIKey{Name: "CI", Scopes: []string{"deploy", "admin"}})
+	if err != nil {
+		t.Errorf("Failed to create API key: %s", err.Error())
+		t.FailNow()
+	}
+	if !strings.HasPrefix(plaintext, APIKeyPrefix) || !strings.HasPrefix(plaintext, k.Prefix) || k.Hash == plaintext {
+		t.Errorf("Invalid API key '%s' '%v'", plaintext, k)
+	}
+
+	var tokenData map[string]string
+	s := httptest.NewServer(svc.AuthInterceptor(func(rw http.ResponseWriter, r *http.Request) {
+		_, tokenData, _ = svc.authenticateToken(r)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	for _, header := range []string{"Authorization", APIKeyHeader} {
+		req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+		if header == "Authorization" {
+			req.Header.Add(header, fmt.Sprintf("Bearer %s", plaintext))
+		} else {
+			req.Header.Add(header, plaintext)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Should accept the API key in '%s', but was '%s'", header, res.Status)
+		}
+		if tokenData[TokenDataUser] != "ci.user" || tokenData[TokenDataScope] != "deploy" {
+			t.Errorf("Invalid token data '%v'", tokenData)
+		}
+	}
+
+	keys := svc.GetRepository().ListAPIKeys(u.ID)
+	if len(keys) != 1 || keys[0].LastUsedAt == nil || keys[0].LastUsedIP != "127.0.0.1" {
+		t.Errorf("Should track the key last usage '%v'", keys)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+	req.Header.Add(APIKeyHeader, plaintext+"x")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should reject invalid API keys, but was '%s'", res.Status)
+	}
+}
+
+func TestAPIKeyExpired(t *testing.T) {
+	svc := newTestService(t)
+	setupUser(t, "ci.user", "pass", svc)
+	expired := time.Now().Add(-time.Minute)
+	_, plaintext, _ := svc.CreateAPIKey(svc.GetRepository().FindUser("ci.user"), &NewAPIKey{Name: "old", ExpiresAt: &expired})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add(APIKeyHeader, plaintext)
+	if _, err := svc.authenticate(req); err == nil {
+		t.Error("Should reject expired API keys")
+	}
+}
+
+func TestHandleAPIKeys(t *testing.T) {
+	svc := newTestService(t)
+	h := NewHandlerCustom(svc)
+	setupUser(t, "ci.user", "pass", svc)
+	token, _ := svc.ToJWT(*svc.GetRepository().FindUser("ci.user"))
+
+	s := httptest.NewServer(h.HandleAPIKeys())
+	defer s.Close()
+
+	request := func(method string, url string, auth string, body []byte) *http.Response {
+		req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", auth))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		return res
+	}
+
+	res := request(http.MethodPost, s.URL, token, []byte(`{"name": "cron", "scopes": ["read"]}`))
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("Should return 201 (Created), but was '%s'", res.Status)
+		t.FailNow()
+	}
+	var created struct {
+		APIKey user.APIKey `json:"apiKey"`
+		Key    string      `json:"key"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&created)
+
+	// API keys can't create new keys
+	if res := request(http.MethodPost, s.URL, created.Key, []byte(`{"name": "other"}`)); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should return 403 (Forbidden), but was '%s'", res.Status)
+	}
+
+	res = request(http.MethodGet, s.URL, token, nil)
+	var keys []map[string]interface{}
+	_ = json.NewDecoder(res.Body).Decode(&keys)
+	if len(keys) != 1 || keys[0]["name"] != "cron" || keys[0]["hash"] != nil {
+		t.Errorf("Invalid API keys list '%v'", keys)
+	}
+
+	if res := request(http.MethodDelete, fmt.Sprintf("%s?id=%d", s.URL, created.APIKey.ID), token, nil); res.StatusCode != http.StatusNoContent {
+		t.Errorf("Should return 204 (No Content), but was '%s'", res.Status)
+	}
+	if res := request(http.MethodGet, s.URL, created.Key, nil); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Deleted API key should be rejected, but was '%s'", res.Status)
+	}
+}
+
+func TestAPIKeyDeletedUser(t *testing.T) {
+	svc := newTestService(t)
+	setupUser(t, "alice", "pass", svc)
+	alice := svc.GetRepository().FindUser("alice")
+	_, plaintext, err := svc.CreateAPIKey(alice, &NewAPIKey{Name: "CI"})
+	if err != nil {
+		t.Errorf("Failed to create API key: %s", err.Error())
+		t.FailNow()
+	}
+
+	if err := svc.GetRepository().DeleteUser(alice); err != nil {
+		t.Errorf("Failed to delete user: %s", err.Error())
+		t.FailNow()
+	}
+	setupUser(t, "mallory", "pass", svc)
+	if mallory := svc.GetRepository().FindUser("mallory"); mallory.ID == alice.ID {
+		t.Errorf("Deleted users ID must not be reused, but was '%d'", mallory.ID)
+	}
+	if keys := svc.GetRepository().ListAPIKeys(alice.ID); len(keys) != 0 {
+		t.Errorf("Deleted users API keys must be deleted, but was '%v'", keys)
+	}
+
+	if _, err := svc.AuthenticateContext(context.Background(), plaintext, "", nil); err == nil {
+		t.Error("Deleted users API keys must be rejected")
+	}
+	if _, err := svc.CreateNewUser(&NewUser{User: "alice", Pass: "pass"}); err == nil {
+		t.Error("Deleted users username must not be reused")
+	}
+}
@@ -2,19 +2,18 @@ package auth
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
-	"encoding/json"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
-	"github.com/eldius/jwt-auth-go/config"
 	"github.com/eldius/jwt-auth-go/hashtools"
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/logger"
 	"github.com/eldius/jwt-auth-go/repository"
 	"github.com/eldius/jwt-auth-go/user"
 )
@@ -23,6 +22,21 @@ const (
 	invalidJwtFormat = "auth.jwt.validation.format.invalid"
 	invalidJwtSign   = "auth.jwt.validation.sign.invalid"
 	expiredToken     = "auth.jwt.validation.token.expired"
+	revokedToken     = "auth.jwt.validation.token.revoked"
+	invalidBinding   = "auth.jwt.validation.cnf.invalid"
+	invalidIssuer    = "auth.jwt.validation.issuer.invalid"
+	invalidAudience  = "auth.jwt.validation.audience.invalid"
+	invalidSubject   = "auth.jwt.validation.subject.invalid"
+	userNotFound     = "auth.user.not.found"
+)
+
+var (
+	// errMissingToken is returned when the request has no token
+	// (the interceptor lets them through when auth is optional)
+	errMissingToken = errors.New("auth.jwt.validation.token.missing")
+	// errInactiveUser is returned when a disabled user tries to
+	// login or to use a token issued before it was disabled
+	errInactiveUser = errors.New("auth.user.inactive")
 )
 
 // Token data field names
@@ -30,6 +44,29 @@ const (
 	TokenDataUser    = "user"
 	TokenDataName    = "name"
 	TokenDataExpires = "expires"
+	TokenDataIssued  = "issued"
+	// TokenDataID is the token unique ID (used to revoke it)
+	TokenDataID = "jti"
+	// TokenDataSubjectType identifies who the token was
+	// issued to (users if empty)
+	TokenDataSubjectType = "sub_type"
+	TokenDataClientID    = "client_id"
+	TokenDataScope       = "scope"
+	TokenDataAudience    = "aud"
+	TokenDataIssuer      = "iss"
+	// TokenDataConfirmation is the confirmation claim (RFC 7800),
+	// nested claims are flattened with dots in the token data
+	TokenDataConfirmation = "cnf"
+	// TokenDataCertThumbprint binds the token to the client
+	// certificate (mTLS, RFC 8705)
+	TokenDataCertThumbprint = TokenDataConfirmation + ".x5t#S256"
+)
+
+// Token subject types
+const (
+	SubjectTypeUser    = "user"
+	SubjectTypeClient  = "client"
+	SubjectTypeService = "service"
 )
 
 /*
@@ -41,40 +78,128 @@ type NewUser struct {
 	Name   string
 	Active bool
 	Admin  bool
+	Roles  []string
+	Email  string
 }
 
 /*
 Service is the service used to interact with API
 */
 type Service struct {
-	repo *repository.AuthRepository
+	repo      *repository.AuthRepository
+	cfgMu     sync.RWMutex
+	cfg       *Config
+	devSecret []byte
+	notifier  Notifier
+	keyMu     sync.Mutex
+	hmacKeys  keyRing
+	rsaKeys   keyRing
+
+	providersMu sync.Mutex
+	providers   map[string]*FederationProvider
+	httpClient  *http.Client
+
+	clock     Clock
+	enrichers []ClaimsEnricher
 }
 
 /*
-NewService creates a new service instance creating a default repository
+NewService creates a new service instance creating a default
+repository, the configuration is loaded from the config keys
+(see LoadService)
 */
 func NewService() *Service {
-	return &Service{
-		repo: repository.NewRepository(),
-	}
+	return NewServiceCustom(repository.NewRepository())
 }
 
 /*
-NewServiceCustom creates a new service instance passing your own repository
+NewServiceCustom creates a new service instance passing your own
+repository, the configuration is loaded from the config keys
+(an invalid configuration is only logged, the tokens can't be
+signed until it's fixed using SetConfig, see LoadService)
 */
 func NewServiceCustom(repo *repository.AuthRepository) *Service {
-	return &Service{
+	cfg := LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		logger.Logger().WithError(err).Warn("Invalid auth configuration")
+	}
+	s := &Service{
+		repo: repo,
+		cfg:  cfg,
+	}
+	s.init(cfg)
+	return s
+}
+
+/*
+LoadService creates a new service instance loading the
+configuration from the config keys, it returns an error if
+the configuration is invalid (if the repository is nil it's
+created using the database configuration)
+*/
+func LoadService(repo *repository.AuthRepository) (*Service, error) {
+	return NewServiceWithConfig(LoadConfig(), repo)
+}
+
+/*
+NewServiceWithConfig creates a new service instance with its own
+configuration (the config keys are not used), if the repository
+is nil it's created using the database configuration
+*/
+func NewServiceWithConfig(cfg *Config, repo *repository.AuthRepository) (*Service, error) {
+	if cfg == nil {
+		return nil, errMissingConfig
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if repo == nil {
+		var err error
+		if repo, err = repository.NewRepositoryWithConfig(cfg.Database); err != nil {
+			return nil, err
+		}
+	}
+	s := &Service{
 		repo: repo,
+		cfg:  cfg,
+	}
+	s.init(cfg)
+	return s, nil
+}
+
+/*
+init generates the development secret (if needed) and
+registers the configured federation providers
+*/
+func (s *Service) init(cfg *Config) {
+	s.initDevSecret(cfg)
+	for _, p := range cfg.FederationProviders {
+		s.AddFederationProvider(p)
 	}
 }
 
-// ValidatePass validates user credentials
+func (s *Service) initDevSecret(cfg *Config) {
+	if !cfg.DevMode || cfg.Secret != "" || s.devSecret != nil {
+		return
+	}
+	logger.Logger().Warn("No JWT secret configured, using a random development secret")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	s.devSecret = secret
+}
+
+/*
+ValidatePass validates user credentials (the username
+parameter accepts the username or the user e-mail)
+*/
 func (s *Service) ValidatePass(username string, pass string) (u *user.CredentialInfo, err error) {
-	var usr = s.repo.FindUser(username)
+	var usr = s.findUserByLogin(username)
 	if usr == nil {
 		return nil, fmt.Errorf("User not found")
 	}
-	if usr.Hash == nil {
+	if usr.Hash == nil || usr.ServiceAccount {
 		err = fmt.Errorf("Failed to authenticate user")
 		return
 	}
@@ -85,125 +210,226 @@ func (s *Service) ValidatePass(username string, pass string) (u *user.Credential
 		return
 	}
 
-	if string(ph) == string(usr.Hash) {
-		u = usr
-	} else {
+	if string(ph) != string(usr.Hash) {
 		err = fmt.Errorf("Failed to authenticate user")
+		return
 	}
+	if !usr.Active {
+		err = errInactiveUser
+		return
+	}
+	if s.config().EmailVerificationRequired && usr.Email != nil && usr.EmailVerifiedAt == nil {
+		err = fmt.Errorf(emailNotVerified)
+		return
+	}
+	u = usr
 
 	return
 }
 
 /*
 ToJWT generates the JWT token from an object of user.CredentialInfo
+(with all the scopes the user is allowed to)
 */
 func (s *Service) ToJWT(u user.CredentialInfo) (jwt string, err error) {
-	// Create a new HMAC by defining the hash type and the key (as byte array)
-	header, err := generateHeader()
-	if err != nil {
-		return
-	}
+	return s.ToJWTWithScopes(u, "")
+}
 
-	payload, err := generatePayload(u)
+/*
+ToJWTWithClaims generates the JWT token from an object of
+user.CredentialInfo adding the claims to the payload (the
+user, name, iss, issued, jti and expires claims can't be
+overridden, aud defaults to `auth.jwt.audience`)
+*/
+func (s *Service) ToJWTWithClaims(u user.CredentialInfo, claims map[string]string) (jwt string, err error) {
+	c, err := toClaims(claims)
 	if err != nil {
 		return
 	}
+	return s.ToJWTWithTypedClaims(u, c)
+}
 
-	jwtWOSign := fmt.Sprintf("%s.%s", header, payload)
-	sign, err := signContent(jwtWOSign)
+/*
+FromJWT parses JWT token to an object user.CredentialInfo
+(nested claims are flattened, eg: `cnf.x5t#S256`, use
+FromJWTClaims to get the typed claims)
+*/
+func (s *Service) FromJWT(jwt string) (d map[string]string, err error) {
+	claims, err := s.parseJWT(jwt)
 	if err != nil {
 		return
 	}
-	jwt = fmt.Sprintf("%s.%s", jwtWOSign, sign)
+	d = make(map[string]string)
+	flattenClaims("", claims, d)
+
 	return
 }
 
 /*
-FromJWT parses JWT token to an object user.CredentialInfo
+parseJWT verifies the token signature returning its claims
 */
-func (s *Service) FromJWT(jwt string) (d map[string]string, err error) {
-	parts := strings.Split(jwt, ".")
-	if len(parts) != 3 {
+func (s *Service) parseJWT(jwt string) (claims map[string]interface{}, err error) {
+	t, err := jose.Parse(jwt)
+	if err != nil {
 		err = fmt.Errorf(invalidJwtFormat)
 		return
 	}
-	sign, err := signContent(fmt.Sprintf("%s.%s", parts[0], parts[1]))
+	keys, err := s.verificationKeys(t.Header)
 	if err != nil {
-		return
-	}
-	if sign != parts[2] {
 		err = fmt.Errorf(invalidJwtSign)
 		return
 	}
-
-	b, err := base64.StdEncoding.DecodeString(parts[1])
-	if err != nil {
-		return
+	for _, key := range keys {
+		if err = t.Verify(key); err == nil {
+			break
+		}
 	}
-
-	err = json.Unmarshal([]byte(b), &d)
 	if err != nil {
+		err = fmt.Errorf(invalidJwtSign)
 		return
 	}
 
+	if err = t.Claims(&claims); err != nil {
+		err = fmt.Errorf(invalidJwtFormat)
+	}
 	return
 }
 
-func validateTokenData(tokenData map[string]string) error {
-	if val, ok := tokenData["expires"]; ok {
-		//do something here
-		expires, err := time.Parse(time.RFC3339, val)
-		if err != nil {
-			return err
-		}
-		if expires.Before(time.Now()) {
-			return fmt.Errorf(expiredToken)
+func flattenClaims(prefix string, claims map[string]interface{}, d map[string]string) {
+	for k, v := range claims {
+		switch value := v.(type) {
+		case string:
+			d[prefix+k] = value
+		case float64:
+			d[prefix+k] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			d[prefix+k] = strconv.FormatBool(value)
+		case []interface{}:
+			values := make([]string, 0, len(value))
+			for _, i := range value {
+				values = append(values, fmt.Sprint(i))
+			}
+			d[prefix+k] = strings.Join(values, " ")
+		case map[string]interface{}:
+			flattenClaims(prefix+k+".", value, d)
 		}
 	}
-	return nil
+}
+
+/*
+validateToken parses the token and checks it's
+not expired nor revoked
+*/
+func (s *Service) validateToken(jwt string) (map[string]string, error) {
+	tokenData, err := s.FromJWT(jwt)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.config().validateTokenData(tokenData, s.now()); err != nil {
+		return nil, err
+	}
+	if jti := tokenData[TokenDataID]; jti != "" && s.repo.IsTokenRevoked(jti) {
+		return nil, fmt.Errorf(revokedToken)
+	}
+	return tokenData, nil
 }
 
 /*
 AuthInterceptor is an interceptor to validate user is logged and its login data is valid
+(options restrict the accepted issuers and audiences). Rejected requests receive a 403
+with the error code in the body (eg: `{"error": "auth.jwt.validation.audience.invalid"}`),
+unless the WithBearerChallenge or WithErrorHandler options are used.
 */
-func (s *Service) AuthInterceptor(f http.HandlerFunc) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		// TODO remove this before release
-		if strings.HasPrefix(authHeader, "Bearer ") {
-			jwt := strings.Replace(authHeader, "Bearer ", "", 1)
-			tokenData, err := s.FromJWT(jwt)
-			if err != nil {
-				log.Println(err.Error())
-				w.WriteHeader(403)
-				return
-			}
-			if err := validateTokenData(tokenData); err != nil {
-				log.Println(err.Error())
-				w.WriteHeader(403)
-				return
-			}
-			u := s.repo.FindUser(tokenData["user"])
-			if u == nil {
-				w.WriteHeader(403)
-				return
-			}
-			ctx := r.Context()
-			ctx = context.WithValue(ctx, CurrentUserKey, u)
-			r = r.WithContext(ctx)
-			f.ServeHTTP(w, r)
-		} else {
-			w.WriteHeader(403)
+func (s *Service) AuthInterceptor(f http.HandlerFunc, opts ...InterceptorOption) http.Handler {
+	return s.Middleware(opts...)(f)
+}
+
+/*
+authenticate validates the request token (the options
+are validated like the AuthInterceptor does)
+*/
+func (s *Service) authenticate(r *http.Request, opts ...InterceptorOption) (*user.CredentialInfo, error) {
+	u, tokenData, err := s.authenticateToken(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := newInterceptorOptions(opts).validate(tokenData); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+/*
+authenticateToken validates the request token (JWT or API
+key) returning the user and the token data
+*/
+func (s *Service) authenticateToken(r *http.Request) (*user.CredentialInfo, map[string]string, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return s.authenticateRawToken(key, r.RemoteAddr, peerCertificate(r))
+	}
+	jwt, err := s.tokenFromRequest(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.authenticateRawToken(jwt, r.RemoteAddr, peerCertificate(r))
+}
+
+/*
+AuthenticateContext validates the token (JWT or API key) like the
+AuthInterceptor does, returning a copy of the context with the user,
+the claims and the token. It's used to authenticate requests of other
+protocols (eg: gRPC), the remote address and the client certificate
+(required by certificate bound tokens) are optional.
+*/
+func (s *Service) AuthenticateContext(ctx context.Context, token string, remoteAddr string, cert *x509.Certificate, opts ...InterceptorOption) (context.Context, error) {
+	o := newInterceptorOptions(opts)
+	if token == "" {
+		if o.optional {
+			return ctx, nil
 		}
-	})
+		return ctx, errMissingToken
+	}
+	u, tokenData, err := s.authenticateRawToken(token, remoteAddr, cert)
+	if err == nil {
+		err = o.validate(tokenData)
+	}
+	if err != nil {
+		return ctx, err
+	}
+	return authContext(ctx, u, tokenData, token), nil
+}
+
+func (s *Service) authenticateRawToken(jwt string, remoteAddr string, cert *x509.Certificate) (*user.CredentialInfo, map[string]string, error) {
+	if strings.HasPrefix(jwt, APIKeyPrefix) {
+		return s.authenticateAPIKey(jwt, remoteAddr)
+	}
+	tokenData, err := s.validateToken(jwt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st := tokenData[TokenDataSubjectType]; st != "" && st != SubjectTypeUser && st != SubjectTypeService {
+		return nil, nil, fmt.Errorf(invalidSubject)
+	}
+	if err := verifyCertificateBinding(cert, tokenData); err != nil {
+		return nil, nil, err
+	}
+	u := s.repo.FindUser(tokenData[TokenDataUser])
+	if u == nil {
+		return nil, nil, fmt.Errorf(userNotFound)
+	}
+	if !u.Active {
+		return nil, nil, errInactiveUser
+	}
+	return u, tokenData, nil
 }
 
 /*
-GetCurrentUser returns the current user by validating the `Authorization` header
+GetCurrentUser returns the current user added to the request context
+by the AuthInterceptor (nil if the request was not authenticated)
 */
 func (s *Service) GetCurrentUser(r *http.Request) *user.CredentialInfo {
-	ctx := r.Context()
-	return ctx.Value(CurrentUserKey).(*user.CredentialInfo)
+	u, _ := UserFromContext(r.Context())
+	return u
 }
 
 /*
@@ -217,14 +443,23 @@ func (s *Service) GetRepository() *repository.AuthRepository {
 CreateNewUser returns a new user
 */
 func (s *Service) CreateNewUser(user *NewUser) (*user.CredentialInfo, error) {
-	_c := s.repo.FindUser(user.User)
-	if _c != nil {
+	if s.repo.UserExists(user.User) {
 		return nil, fmt.Errorf("user alread exists")
 	}
+	if user.Email != "" && s.repo.FindUserByEmail(user.Email) != nil {
+		return nil, fmt.Errorf(emailInUse)
+	}
 	c, err := toCredentials(user)
 	if err != nil {
 		return nil, err
 	}
+	for _, role := range user.Roles {
+		p := s.repo.FindProfile(role)
+		if p == nil {
+			return nil, fmt.Errorf(profileNotFound)
+		}
+		c.Profiles = append(c.Profiles, *p)
+	}
 	err = s.repo.SaveUser(c)
 	return c, err
 }
@@ -237,50 +472,8 @@ func toCredentials(u *NewUser) (*user.CredentialInfo, error) {
 	c.Name = u.Name
 	c.Admin = u.Admin
 	c.Active = u.Active
-	return &c, nil
-}
-
-func generateHeader() (headerStr string, err error) {
-	header := map[string]string{
-		"alg": "HS256",
-		"typ": "JWT",
-	}
-	headerByte, err := json.Marshal(header)
-	if err != nil {
-		return
-	}
-
-	headerStr = base64.StdEncoding.EncodeToString([]byte(headerByte))
-	return
-}
-
-func generatePayload(u user.CredentialInfo) (payloadStr string, err error) {
-	payload := map[string]string{
-		TokenDataUser: u.User,
-		TokenDataName: u.Name,
-	}
-	ttl := config.GetDefaultJwtTTL()
-	if ttl.Milliseconds() >= 1 {
-		payload[TokenDataExpires] = time.Now().Add(ttl).Format(time.RFC3339)
-	}
-	payloadByte, err := json.Marshal(payload)
-	if err != nil {
-		return
-	}
-
-	payloadStr = base64.StdEncoding.EncodeToString([]byte(payloadByte))
-	return
-}
-
-func signContent(content string) (sign string, err error) {
-	h := hmac.New(sha256.New, []byte(config.GetJWTSecret()))
-
-	// Write Data to it
-	_, err = h.Write([]byte(content))
-	if err != nil {
-		return
+	if err := c.SetEmail(u.Email); err != nil {
+		return nil, err
 	}
-	sign = hex.EncodeToString(h.Sum(nil))
-
-	return
+	return &c, nil
 }
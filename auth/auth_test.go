@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -112,11 +113,17 @@ func TestValidatePassUserNotFound(t *testing.T) {
 
 }
 
-func TestValidateTokenDataSuccessWithoutExpireTime(t *testing.T) {
+func TestValidateTokenDataFailsWithoutExpireTime(t *testing.T) {
 	tokenData := map[string]string{}
-	err := validateTokenData(tokenData)
-	if err != nil {
-		t.Errorf("Must not return error: '%s'", err.Error())
+	for _, cfg := range []*Config{LoadConfig(), {}} {
+		err := cfg.validateTokenData(tokenData, time.Now())
+		if err == nil || !strings.HasPrefix(err.Error(), missingClaim) {
+			t.Errorf("Must reject tokens without expiration, but was '%v'", err)
+		}
+	}
+	cfg := &Config{RequiredClaims: []string{}}
+	if err := cfg.validateTokenData(tokenData, time.Now()); err != nil {
+		t.Errorf("Must not return error when no claim is required: '%s'", err.Error())
 	}
 }
 
@@ -124,7 +131,7 @@ func TestValidateTokenDataSuccessWithExpireTime(t *testing.T) {
 	tokenData := map[string]string{
 		TokenDataExpires: time.Now().Add(60 * time.Second).Format(time.RFC3339),
 	}
-	err := validateTokenData(tokenData)
+	err := LoadConfig().validateTokenData(tokenData, time.Now())
 	if err != nil {
 		t.Errorf("Must not return error: '%s'", err.Error())
 	}
@@ -134,7 +141,7 @@ func TestValidateTokenDataTokeExpired(t *testing.T) {
 	tokenData := map[string]string{
 		TokenDataExpires: time.Now().Add(-60 * time.Second).Format(time.RFC3339),
 	}
-	err := validateTokenData(tokenData)
+	err := LoadConfig().validateTokenData(tokenData, time.Now())
 	if err == nil {
 		t.Errorf("Must return an error")
 	}
@@ -158,3 +165,26 @@ func TestToJWT(t *testing.T) {
 		t.Errorf("Should return 3 parts separated by dot (.), but returned %d", len(parts))
 	}
 }
+
+func disableUser(t *testing.T, svc *Service, username string) {
+	u := svc.GetRepository().FindUser(username)
+	u.Active = false
+	if err := svc.GetRepository().SaveUser(u); err != nil {
+		t.Errorf("Failed to disable user: %s", err.Error())
+		t.FailNow()
+	}
+}
+
+func TestInactiveUser(t *testing.T) {
+	svc := newTestService(t)
+	setupUser(t, "inactive.user", "pass", svc)
+	token := issueTestToken(t, svc, "inactive.user")
+	disableUser(t, svc, "inactive.user")
+
+	if _, err := svc.ValidatePass("inactive.user", "pass"); err != errInactiveUser {
+		t.Errorf("Inactive users must not login, but was '%v'", err)
+	}
+	if _, err := svc.AuthenticateContext(context.Background(), token, "", nil); err != errInactiveUser {
+		t.Errorf("Inactive users tokens must be rejected, but was '%v'", err)
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/user"
+	"github.com/eldius/jwt-auth-go/verifier"
+	"github.com/google/uuid"
+)
+
+/*
+Claims are the typed token claims, custom claims (any JSON
+value: numbers, arrays, objects...) are kept in Extra
+*/
+type Claims = verifier.Claims
+
+/*
+ClaimsEnricher adds custom claims to the tokens when they
+are issued (eg: the user tenant), returning an error
+aborts the token issuance
+*/
+type ClaimsEnricher func(u *user.CredentialInfo, c *Claims) error
+
+/*
+AddClaimsEnricher registers a claims enricher, they are
+called in the registration order for every token issued
+(enrichers must be registered before the service is used)
+*/
+func (s *Service) AddClaimsEnricher(e ClaimsEnricher) {
+	s.enrichers = append(s.enrichers, e)
+}
+
+/*
+ToJWTWithTypedClaims generates the JWT token from an object of
+user.CredentialInfo with the typed claims (after the enrichers
+are applied). The subject, name, issuer, issued, ID and expiration
+can't be overridden, the audience defaults to `auth.jwt.audience`.
+*/
+func (s *Service) ToJWTWithTypedClaims(u user.CredentialInfo, c *Claims) (string, error) {
+	key, err := s.signingKey()
+	if err != nil {
+		return "", err
+	}
+	if c == nil {
+		c = &Claims{}
+	}
+	for _, e := range s.enrichers {
+		if err := e(&u, c); err != nil {
+			return "", err
+		}
+	}
+	cfg := s.config()
+	now := s.now()
+	c.Subject = u.User
+	c.Name = u.Name
+	c.Issuer = cfg.Issuer
+	if len(c.Audience) == 0 && cfg.Audience != "" {
+		c.Audience = []string{cfg.Audience}
+	}
+	c.IssuedAt = now
+	c.ID = uuid.New().String()
+	c.ExpiresAt = now.Add(cfg.tokenTTL())
+	return jose.Sign(c.Map(), key)
+}
+
+/*
+FromJWTClaims parses the JWT token to the typed claims (the
+token signature is verified but its times are not validated)
+*/
+func (s *Service) FromJWTClaims(jwt string) (*Claims, error) {
+	raw, err := s.parseJWT(jwt)
+	if err != nil {
+		return nil, err
+	}
+	return verifier.ParseClaims(raw)
+}
+
+/*
+toClaims converts the token data (`cnf.*` keys are
+nested in the confirmation claim) to typed claims
+*/
+func toClaims(claims map[string]string) (*Claims, error) {
+	raw := make(map[string]interface{}, len(claims))
+	cnf := map[string]interface{}{}
+	for k, v := range claims {
+		if strings.HasPrefix(k, TokenDataConfirmation+".") {
+			cnf[strings.TrimPrefix(k, TokenDataConfirmation+".")] = v
+			continue
+		}
+		raw[k] = v
+	}
+	if len(cnf) > 0 {
+		raw[TokenDataConfirmation] = cnf
+	}
+	c, err := verifier.ParseClaims(raw)
+	if err != nil {
+		return nil, fmt.Errorf(invalidTime)
+	}
+	return c, nil
+}
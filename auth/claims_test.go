@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+func TestClaimsEnricher(t *testing.T) {
+	svc := newTestService(t)
+	svc.AddClaimsEnricher(func(u *user.CredentialInfo, c *Claims) error {
+		c.Set("tenant", "acme")
+		c.Set("level", 3)
+		c.Set("groups", []string{"dev", "ops"})
+		// reserved claims can't be overridden
+		c.Subject = "someone.else"
+		return nil
+	})
+
+	jwt, err := svc.ToJWTWithClaims(user.CredentialInfo{User: "enriched.user", Name: "Enriched"}, map[string]string{TokenDataScope: "a b"})
+	if err != nil {
+		t.Errorf("Failed to generate token: %s", err.Error())
+		t.FailNow()
+	}
+	c, err := svc.FromJWTClaims(jwt)
+	if err != nil {
+		t.Errorf("Failed to parse token: %s", err.Error())
+		t.FailNow()
+	}
+	if c.Subject != "enriched.user" || c.ID == "" || c.IssuedAt.IsZero() {
+		t.Errorf("Must keep the reserved claims, but was '%v'", c)
+	}
+	if !c.HasScopes("a", "b") {
+		t.Errorf("Must keep the requested claims, but was '%v'", c.Scopes)
+	}
+	if c.GetString("tenant") != "acme" {
+		t.Errorf("tenant should be 'acme', but was '%s'", c.GetString("tenant"))
+	}
+	if level, ok := c.GetInt64("level"); !ok || level != 3 {
+		t.Errorf("level should be 3, but was '%d'", level)
+	}
+	if groups := c.GetStrings("groups"); len(groups) != 2 || groups[1] != "ops" {
+		t.Errorf("groups should be [dev ops], but was '%v'", groups)
+	}
+
+	d, err := svc.FromJWT(jwt)
+	if err != nil || d["level"] != "3" || d["groups"] != "dev ops" {
+		t.Errorf("Must flatten the custom claims, but was '%v'", d)
+	}
+}
+
+func TestClaimsEnricherError(t *testing.T) {
+	svc := newTestService(t)
+	svc.AddClaimsEnricher(func(u *user.CredentialInfo, c *Claims) error {
+		return errors.New("tenant.not.found")
+	})
+	if _, err := svc.ToJWT(user.CredentialInfo{User: "enriched.user"}); err == nil || err.Error() != "tenant.not.found" {
+		t.Errorf("Must return the enricher error, but was '%v'", err)
+	}
+}
@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/config"
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/logger"
+	"github.com/eldius/jwt-auth-go/repository"
+)
+
+var (
+	errMissingConfig       = errors.New("auth.config.missing")
+	errMissingSecret       = errors.New("auth.config.secret.missing")
+	errMissingKeyFile      = errors.New("auth.config.key.file.missing")
+	errInvalidAlgorithm    = errors.New("auth.config.algorithm.invalid")
+	errInvalidRegistration = errors.New("auth.config.registration.mode.invalid")
+	errInvalidDuration     = errors.New("auth.config.duration.invalid")
+)
+
+/*
+defaultTTL is the access tokens TTL when it's not configured
+*/
+const defaultTTL = time.Hour
+
+/*
+defaultRequiredClaims are the claims required when
+they're not configured (tokens must expire)
+*/
+var defaultRequiredClaims = []string{TokenDataExpires}
+
+/*
+defaultOIDCEndpoints are the OpenID Connect endpoints
+paths when they're not configured
+*/
+var defaultOIDCEndpoints = map[string]string{
+	"authorize":  "/authorize",
+	"token":      "/token",
+	"userinfo":   "/userinfo",
+	"jwks":       "/.well-known/jwks.json",
+	"introspect": "/introspect",
+	"revoke":     "/revoke",
+}
+
+/*
+Config is the service configuration, each service instance
+has its own (so services with different secrets, issuers...
+can live in the same process). The zero value of the optional
+fields disables them or falls back to the library defaults.
+*/
+type Config struct {
+	// Secret is the HS256 signing secret (required unless
+	// DevMode is enabled), it can be a secret reference
+	// (`file://`, `env://` or `base64:`, see config.ResolveSecret)
+	Secret string
+	// Algorithm is the tokens signing algorithm (HS256 or RS256)
+	Algorithm string
+	// KeyFile is the PEM encoded RSA private key used to sign
+	// RS256 tokens and OpenID Connect ID tokens
+	KeyFile string
+	// KeyID is the RSA key ID (the JWT `kid` header)
+	KeyID string
+	// RotationGrace is for how long the rotated secrets and
+	// keys are still accepted to verify the tokens
+	RotationGrace time.Duration
+	Issuer        string
+	Audience      string
+	Audiences     []string
+	// TTL is the access tokens TTL (tokens always expire,
+	// zero means the default TTL, 1h)
+	TTL    time.Duration
+	Leeway time.Duration
+	MaxAge time.Duration
+	// RequiredClaims are the claims every token must have
+	// (`auth.jwt.claims.required`), nil means the default
+	// (expires) and an empty slice requires no claims
+	RequiredClaims []string
+	DefaultScopes  []string
+
+	UserDefaultActive         bool
+	RegistrationMode          string
+	InvitationTTL             time.Duration
+	BootstrapAdminUser        string
+	BootstrapAdminPass        string
+	EmailVerificationRequired bool
+	EmailVerificationTTL      time.Duration
+	EmailVerificationURL      string
+
+	MagicLinkEnabled     bool
+	MagicLinkTTL         time.Duration
+	MagicLinkURL         string
+	MagicLinkBindBrowser bool
+
+	CookieEnabled  bool
+	CookieName     string
+	CSRFCookieName string
+	CookieDomain   string
+	CookiePath     string
+	CookieSecure   bool
+	CookieSameSite string
+
+	OAuthCodeTTL    time.Duration
+	OAuthRefreshTTL time.Duration
+	OAuthLoginURL   string
+	// OIDCEndpoints are the OpenID Connect endpoints by name
+	// (authorize, token, userinfo, jwks, introspect and revoke)
+	OIDCEndpoints map[string]string
+
+	FederationProviders []*FederationProvider
+
+	// ServerPrefix is the path prefix of the auth endpoints and
+	// ServerMaxBodySize the request bodies limit (zero means no
+	// limit), they're used to mount the endpoints (see server)
+	ServerPrefix      string
+	ServerMaxBodySize int64
+
+	// DevMode allows running without a secret (a random one
+	// is generated for each service instance, so tokens become
+	// invalid when the process restarts)
+	DevMode bool
+
+	// Database is used only when the service creates its repository
+	Database repository.Config
+}
+
+/*
+LoadConfig loads the configuration from the
+config keys (`auth.*`)
+*/
+func LoadConfig() *Config {
+	return &Config{
+		Secret:         config.GetJWTSecret(),
+		Algorithm:      config.GetJWTAlgorithm(),
+		KeyFile:        config.GetJWTKeyFile(),
+		KeyID:          config.GetJWTKeyID(),
+		RotationGrace:  config.GetJWTRotationGrace(),
+		Issuer:         config.GetJWTIssuer(),
+		Audience:       config.GetJWTAudience(),
+		Audiences:      config.GetJWTAudiences(),
+		TTL:            config.GetDefaultJwtTTL(),
+		Leeway:         config.GetJWTLeeway(),
+		MaxAge:         config.GetJWTMaxAge(),
+		RequiredClaims: config.GetJWTRequiredClaims(),
+		DefaultScopes:  config.GetDefaultScopes(),
+
+		UserDefaultActive:         config.GetUserDefaultActive(),
+		RegistrationMode:          config.GetRegistrationMode(),
+		InvitationTTL:             config.GetInvitationTTL(),
+		BootstrapAdminUser:        config.GetBootstrapAdminUser(),
+		BootstrapAdminPass:        config.GetBootstrapAdminPass(),
+		EmailVerificationRequired: config.GetEmailVerificationRequired(),
+		EmailVerificationTTL:      config.GetEmailVerificationTTL(),
+		EmailVerificationURL:      config.GetEmailVerificationURL(),
+
+		MagicLinkEnabled:     config.GetMagicLinkEnabled(),
+		MagicLinkTTL:         config.GetMagicLinkTTL(),
+		MagicLinkURL:         config.GetMagicLinkURL(),
+		MagicLinkBindBrowser: config.GetMagicLinkBindBrowser(),
+
+		CookieEnabled:  config.GetCookieEnabled(),
+		CookieName:     config.GetCookieName(),
+		CSRFCookieName: config.GetCSRFCookieName(),
+		CookieDomain:   config.GetCookieDomain(),
+		CookiePath:     config.GetCookiePath(),
+		CookieSecure:   config.GetCookieSecure(),
+		CookieSameSite: config.GetCookieSameSite(),
+
+		OAuthCodeTTL:    config.GetOAuthCodeTTL(),
+		OAuthRefreshTTL: config.GetOAuthRefreshTTL(),
+		OAuthLoginURL:   config.GetOAuthLoginURL(),
+		OIDCEndpoints: map[string]string{
+			"authorize":  config.GetOIDCEndpoint("authorize"),
+			"token":      config.GetOIDCEndpoint("token"),
+			"userinfo":   config.GetOIDCEndpoint("userinfo"),
+			"jwks":       config.GetOIDCEndpoint("jwks"),
+			"introspect": config.GetOIDCEndpoint("introspect"),
+			"revoke":     config.GetOIDCEndpoint("revoke"),
+		},
+
+		FederationProviders: loadFederationProviders(),
+
+		ServerPrefix:      config.GetServerPrefix(),
+		ServerMaxBodySize: config.GetServerMaxBodySize(),
+
+		DevMode: config.GetDevMode(),
+
+		Database: repository.LoadConfig(),
+	}
+}
+
+/*
+Validate validates the configuration, a secret is required
+unless DevMode is enabled (whatever the algorithm, it signs
+the CSRF, one time and federation state tokens) and RS256
+also requires the RSA key file
+*/
+func (c *Config) Validate() error {
+	if c.Secret == "" && !c.DevMode {
+		return errMissingSecret
+	}
+	if c.Secret != "" {
+		secret, err := config.ResolveSecret(c.Secret)
+		if err != nil {
+			return err
+		}
+		if len(secret) == 0 {
+			return errMissingSecret
+		}
+	}
+	switch c.Algorithm {
+	case "", jose.HS256:
+	case jose.RS256:
+		if c.KeyFile == "" && !c.DevMode {
+			return errMissingKeyFile
+		}
+	default:
+		return fmt.Errorf("%w: %s", errInvalidAlgorithm, c.Algorithm)
+	}
+
+	switch c.RegistrationMode {
+	case "", RegistrationOpen, RegistrationInviteOnly, RegistrationAdminOnly, RegistrationDisabled:
+	default:
+		return fmt.Errorf("%w: %s", errInvalidRegistration, c.RegistrationMode)
+	}
+
+	durations := map[string]time.Duration{
+		"ttl":                    c.TTL,
+		"rotation.grace":         c.RotationGrace,
+		"leeway":                 c.Leeway,
+		"max.age":                c.MaxAge,
+		"invitation.ttl":         c.InvitationTTL,
+		"email.verification.ttl": c.EmailVerificationTTL,
+		"magiclink.ttl":          c.MagicLinkTTL,
+		"oauth.code.ttl":         c.OAuthCodeTTL,
+		"oauth.refresh.ttl":      c.OAuthRefreshTTL,
+	}
+	for name, d := range durations {
+		if d < 0 {
+			return fmt.Errorf("%w: %s", errInvalidDuration, name)
+		}
+	}
+	return nil
+}
+
+/*
+config returns the service configuration (it's loaded when the
+service is created, use SetConfig or WatchConfig to reload it)
+*/
+func (s *Service) config() *Config {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.cfg
+}
+
+/*
+Config returns the service configuration (it must not be
+modified, use SetConfig to replace it)
+*/
+func (s *Service) Config() *Config {
+	return s.config()
+}
+
+/*
+SetConfig replaces the service configuration (eg: when the
+config file changes), the secret and the RSA key are
+reloaded if their references changed
+*/
+func (s *Service) SetConfig(cfg *Config) error {
+	if cfg == nil {
+		return errMissingConfig
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.keyMu.Lock()
+	s.initDevSecret(cfg)
+	s.keyMu.Unlock()
+
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	s.cfg = cfg
+	return nil
+}
+
+/*
+WatchConfig watches the config file, the configuration is
+loaded again from the config keys when it changes (invalid
+changes are logged and ignored)
+*/
+func (s *Service) WatchConfig() {
+	config.WatchConfig(func() {
+		if err := s.SetConfig(LoadConfig()); err != nil {
+			logger.Logger().WithError(err).Warn("Failed to reload the config")
+		}
+	})
+}
+
+/*
+tokenTTL returns the access tokens TTL
+*/
+func (c *Config) tokenTTL() time.Duration {
+	return durationOr(c.TTL, defaultTTL)
+}
+
+/*
+requiredClaims returns the claims every token must have
+*/
+func (c *Config) requiredClaims() []string {
+	if c.RequiredClaims == nil {
+		return defaultRequiredClaims
+	}
+	return c.RequiredClaims
+}
+
+func (c *Config) cookieName() string {
+	if c.CookieName != "" {
+		return c.CookieName
+	}
+	return DefaultCookieName
+}
+
+func (c *Config) csrfCookieName() string {
+	if c.CSRFCookieName != "" {
+		return c.CSRFCookieName
+	}
+	return DefaultCSRFCookieName
+}
+
+/*
+OIDCEndpoint returns the path (relative to the issuer) or
+the URL of an OpenID Connect endpoint (see OIDCEndpoints)
+*/
+func (c *Config) OIDCEndpoint(name string) string {
+	if e := c.OIDCEndpoints[name]; e != "" {
+		return e
+	}
+	return defaultOIDCEndpoints[name]
+}
+
+func (c *Config) oidcEndpointURL(issuer string, name string) string {
+	e := c.OIDCEndpoint(name)
+	if strings.HasPrefix(e, "http://") || strings.HasPrefix(e, "https://") {
+		return e
+	}
+	return strings.TrimSuffix(issuer, "/") + e
+}
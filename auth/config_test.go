@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/config"
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/repository"
+	"github.com/eldius/jwt-auth-go/user"
+	"github.com/spf13/viper"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newConfigTestService(t *testing.T, cfg *Config) *Service {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Errorf("Failed to open test database: %s", err.Error())
+		t.FailNow()
+	}
+	svc, err := NewServiceWithConfig(cfg, repository.NewRepositoryCustom(db))
+	if err != nil {
+		t.Errorf("Failed to create service: %s", err.Error())
+		t.FailNow()
+	}
+	return svc
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Setenv("JWT_AUTH_TEST_EMPTY", "")
+	for _, test := range []struct {
+		name string
+		cfg  Config
+		err  error
+	}{
+		{"secret", Config{Secret: "s"}, nil},
+		{"missing secret", Config{}, errMissingSecret},
+		{"dev mode", Config{DevMode: true}, nil},
+		{"empty secret", Config{Secret: config.SecretEnvPrefix + "JWT_AUTH_TEST_EMPTY"}, errMissingSecret},
+		{"missing key file", Config{Secret: "s", Algorithm: jose.RS256}, errMissingKeyFile},
+		{"RS256 missing secret", Config{Algorithm: jose.RS256, KeyFile: "key.pem"}, errMissingSecret},
+		{"invalid algorithm", Config{Secret: "s", Algorithm: "none"}, errInvalidAlgorithm},
+		{"invalid registration mode", Config{Secret: "s", RegistrationMode: "closed"}, errInvalidRegistration},
+		{"negative duration", Config{Secret: "s", TTL: -time.Second}, errInvalidDuration},
+	} {
+		if err := test.cfg.Validate(); !errors.Is(err, test.err) {
+			t.Errorf("%s: must return '%v', but was '%v'", test.name, test.err, err)
+		}
+	}
+}
+
+func TestNewServiceWithConfig(t *testing.T) {
+	if _, err := NewServiceWithConfig(&Config{}, nil); !errors.Is(err, errMissingSecret) {
+		t.Errorf("Must reject configurations without secret, but was '%v'", err)
+	}
+
+	svc1 := newConfigTestService(t, &Config{Secret: "secret-1", Issuer: "issuer-1"})
+	svc2 := newConfigTestService(t, &Config{Secret: "secret-2", Issuer: "issuer-2"})
+	setupUser(t, "cfg.user", "pass", svc1)
+	token, err := svc1.ToJWT(*svc1.GetRepository().FindUser("cfg.user"))
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+	if c, err := svc1.FromJWTClaims(token); err != nil || c.Issuer != "issuer-1" {
+		t.Errorf("Should issue tokens with the service config, but was '%v' (%v)", c, err)
+	}
+	if _, err := svc2.FromJWT(token); err == nil {
+		t.Errorf("Services with different secrets must reject each other tokens")
+	}
+}
+
+func TestNewServiceDevMode(t *testing.T) {
+	svc1 := newConfigTestService(t, &Config{DevMode: true})
+	svc2 := newConfigTestService(t, &Config{DevMode: true})
+	setupUser(t, "dev.user", "pass", svc1)
+	token, err := svc1.ToJWT(*svc1.GetRepository().FindUser("dev.user"))
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc1.FromJWT(token); err != nil {
+		t.Errorf("Should validate its own tokens, but was '%v'", err)
+	}
+	if _, err := svc2.FromJWT(token); err == nil {
+		t.Errorf("Each service must generate its own development secret")
+	}
+}
+
+func TestNewServiceWithoutSecret(t *testing.T) {
+	secret := viper.GetString("auth.jwt.secret")
+	viper.Set("auth.jwt.secret", "")
+	defer viper.Set("auth.jwt.secret", secret)
+
+	if _, err := LoadService(nil); err != errMissingSecret {
+		t.Errorf("Must return an error without secret outside dev mode, but was '%v'", err)
+	}
+	svc := newTestService(t)
+	if _, err := svc.ToJWT(user.CredentialInfo{User: "no.secret"}); err != errMissingSecret {
+		t.Errorf("Must not sign tokens without secret outside dev mode, but was '%v'", err)
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+/*
+contextKey is the type of the keys used to add the
+authentication data into requests context (it's
+unexported so other packages can't collide with them)
+*/
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	claimsKey
+	tokenKey
+)
+
+/*
+ContextKey is the type of the legacy context keys, they are
+still set by the interceptors and read by the accessors
+
+Deprecated: use the context accessors (UserFromContext,
+ScopesFromContext, ContextWithUser...) instead of the keys
+*/
+type ContextKey string
+
+const (
+	// CurrentUserKey is the legacy key of the authenticated user
+	//
+	// Deprecated: use UserFromContext and ContextWithUser
+	CurrentUserKey ContextKey = "currentUser"
+	// ScopesKey is the legacy key of the granted scopes
+	//
+	// Deprecated: use ScopesFromContext and ContextWithClaims
+	ScopesKey ContextKey = "scopes"
+)
+
+/*
+UserFromContext returns the authenticated user (added
+to the context by the AuthInterceptor)
+*/
+func UserFromContext(ctx context.Context) (*user.CredentialInfo, bool) {
+	u, ok := ctx.Value(userKey).(*user.CredentialInfo)
+	if !ok {
+		// added with the legacy key
+		u, ok = ctx.Value(CurrentUserKey).(*user.CredentialInfo)
+	}
+	return u, ok && u != nil
+}
+
+/*
+ClaimsFromContext returns the claims of the request token
+(API keys claims are built from the key)
+*/
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(*Claims)
+	return c, ok && c != nil
+}
+
+/*
+TokenFromContext returns the raw request token
+(the JWT or the API key)
+*/
+func TokenFromContext(ctx context.Context) (string, bool) {
+	t, ok := ctx.Value(tokenKey).(string)
+	return t, ok && t != ""
+}
+
+/*
+ContextWithUser returns a copy of the context with the user
+and the granted scopes, it's useful to test handlers
+without issuing tokens. If the context already has claims
+the scopes are added to them.
+*/
+func ContextWithUser(ctx context.Context, u *user.CredentialInfo, scopes ...string) context.Context {
+	ctx = context.WithValue(ctx, userKey, u)
+	ctx = context.WithValue(ctx, CurrentUserKey, u)
+	if c, ok := ClaimsFromContext(ctx); ok {
+		if len(scopes) == 0 {
+			return ctx
+		}
+		merged := *c
+		merged.Scopes = mergeScopes(append([]string(nil), c.Scopes...), scopes)
+		return ContextWithClaims(ctx, &merged)
+	}
+	if u == nil {
+		return ctx
+	}
+	return ContextWithClaims(ctx, &Claims{
+		Subject:     u.User,
+		Name:        u.Name,
+		SubjectType: SubjectTypeUser,
+		Scopes:      scopes,
+	})
+}
+
+/*
+ContextWithClaims returns a copy of the context with the claims
+*/
+func ContextWithClaims(ctx context.Context, c *Claims) context.Context {
+	if c != nil {
+		ctx = context.WithValue(ctx, ScopesKey, c.Scopes)
+	}
+	return context.WithValue(ctx, claimsKey, c)
+}
+
+/*
+ContextWithToken returns a copy of the context with the raw token
+*/
+func ContextWithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+/*
+authContext adds the authentication data into the context
+*/
+func authContext(ctx context.Context, u *user.CredentialInfo, tokenData map[string]string, token string) context.Context {
+	ctx = ContextWithToken(ctx, token)
+	ctx = ContextWithClaims(ctx, tokenDataClaims(tokenData))
+	return ContextWithUser(ctx, u)
+}
+
+/*
+tokenDataClaims converts the (flattened) token data to typed
+claims, custom claims are kept as strings
+*/
+func tokenDataClaims(tokenData map[string]string) *Claims {
+	c, err := toClaims(tokenData)
+	if err != nil {
+		// times were validated already
+		c = &Claims{}
+	}
+	return c
+}
+
+/*
+requestToken returns the raw token (API key or JWT) of the request
+*/
+func (s *Service) requestToken(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if token, err := s.tokenFromRequest(r); err == nil {
+		return token
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+func TestGetCurrentUserWithoutInterceptor(t *testing.T) {
+	svc := newTestService(t)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if u := svc.GetCurrentUser(req); u != nil {
+		t.Errorf("Should not return a user, but was '%v'", u)
+	}
+	if _, ok := ClaimsFromContext(req.Context()); ok {
+		t.Errorf("Should not return claims")
+	}
+}
+
+func TestAuthInterceptorContext(t *testing.T) {
+	svc := newTestService(t)
+	setupUser(t, "ctx.user", "pass", svc)
+	jwt, err := svc.ToJWTWithClaims(*svc.GetRepository().FindUser("ctx.user"), map[string]string{TokenDataScope: "a b"})
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+
+	var u *user.CredentialInfo
+	var c *Claims
+	var token string
+	s := httptest.NewServer(svc.AuthInterceptor(func(rw http.ResponseWriter, r *http.Request) {
+		u, _ = UserFromContext(r.Context())
+		c, _ = ClaimsFromContext(r.Context())
+		token, _ = TokenFromContext(r.Context())
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+	req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if u == nil || u.User != "ctx.user" {
+		t.Errorf("Should add the user to the context, but was '%v'", u)
+	}
+	if c == nil || c.Subject != "ctx.user" || !c.HasScopes("a", "b") {
+		t.Errorf("Should add the claims to the context, but was '%v'", c)
+	}
+	if token != jwt {
+		t.Errorf("Should add the token to the context, but was '%s'", token)
+	}
+}
+
+func TestContextWithUser(t *testing.T) {
+	ctx := ContextWithUser(context.Background(), &user.CredentialInfo{User: "test.user", Admin: true}, "invoices:read")
+	if u, ok := UserFromContext(ctx); !ok || u.User != "test.user" {
+		t.Errorf("Should return the injected user, but was '%v'", u)
+	}
+	if !HasScopes(ctx, "invoices:read") || HasScopes(ctx, "invoices:write") {
+		t.Errorf("Should grant only the injected scopes, but was '%v'", ScopesFromContext(ctx))
+	}
+
+	svc := newTestService(t)
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	if u := svc.GetCurrentUser(req); u == nil || !u.Admin {
+		t.Errorf("Should return the injected user, but was '%v'", u)
+	}
+}
+
+func TestContextWithUserMergesScopes(t *testing.T) {
+	ctx := ContextWithClaims(context.Background(), &Claims{Subject: "test.user", Scopes: []string{"invoices:read"}})
+	ctx = ContextWithUser(ctx, &user.CredentialInfo{User: "test.user"}, "invoices:write")
+	if !HasScopes(ctx, "invoices:read", "invoices:write") {
+		t.Errorf("Should merge the scopes into the existing claims, but was '%v'", ScopesFromContext(ctx))
+	}
+	if c, _ := ClaimsFromContext(ctx); c.Subject != "test.user" {
+		t.Errorf("Should keep the existing claims, but was '%v'", c)
+	}
+}
+
+func TestLegacyContextKeys(t *testing.T) {
+	ctx := ContextWithUser(context.Background(), &user.CredentialInfo{User: "test.user"}, "invoices:read")
+	if u, ok := ctx.Value(CurrentUserKey).(*user.CredentialInfo); !ok || u.User != "test.user" {
+		t.Errorf("Should set the legacy user key, but was '%v'", ctx.Value(CurrentUserKey))
+	}
+	if scopes, ok := ctx.Value(ScopesKey).([]string); !ok || len(scopes) != 1 || scopes[0] != "invoices:read" {
+		t.Errorf("Should set the legacy scopes key, but was '%v'", ctx.Value(ScopesKey))
+	}
+
+	ctx = context.WithValue(context.Background(), CurrentUserKey, &user.CredentialInfo{User: "legacy.user"})
+	ctx = context.WithValue(ctx, ScopesKey, []string{"invoices:write"})
+	if u, ok := UserFromContext(ctx); !ok || u.User != "legacy.user" {
+		t.Errorf("Should read the user added with the legacy key, but was '%v'", u)
+	}
+	if !HasScopes(ctx, "invoices:write") {
+		t.Errorf("Should read the scopes added with the legacy key, but was '%v'", ScopesFromContext(ctx))
+	}
+}
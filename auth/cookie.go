@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Session cookie defaults (used when the config keys are empty)
+const (
+	DefaultCookieName     = "jwt-auth-token"
+	DefaultCSRFCookieName = "jwt-auth-csrf"
+)
+
+/*
+CSRFHeader is the header where clients must send the CSRF
+token for unsafe requests authenticated by cookie
+*/
+const CSRFHeader = "X-CSRF-Token"
+
+/*
+CSRFFormField is the form field used to send the CSRF
+token when it's not possible to set the header (HTML forms)
+*/
+const CSRFFormField = "csrf_token"
+
+var errInvalidCSRFToken = errors.New("auth.csrf.token.invalid")
+
+/*
+SetSessionCookies sets the token in an `HttpOnly` cookie and
+issues a new CSRF token (returned and set in a cookie readable
+by the browser application)
+*/
+func (s *Service) SetSessionCookies(rw http.ResponseWriter, token string) (string, error) {
+	csrf, err := s.newCSRFToken(token)
+	if err != nil {
+		return "", err
+	}
+	cfg := s.config()
+	maxAge := int(cfg.tokenTTL().Seconds())
+	http.SetCookie(rw, cfg.sessionCookie(cfg.cookieName(), token, maxAge, true))
+	http.SetCookie(rw, cfg.sessionCookie(cfg.csrfCookieName(), csrf, maxAge, false))
+	return csrf, nil
+}
+
+/*
+ClearSessionCookies removes the session cookies
+*/
+func (s *Service) ClearSessionCookies(rw http.ResponseWriter) {
+	cfg := s.config()
+	http.SetCookie(rw, cfg.sessionCookie(cfg.cookieName(), "", -1, true))
+	http.SetCookie(rw, cfg.sessionCookie(cfg.csrfCookieName(), "", -1, false))
+}
+
+/*
+IssueCSRFToken issues a new CSRF token for the session
+cookie of the request
+*/
+func (s *Service) IssueCSRFToken(rw http.ResponseWriter, r *http.Request) (string, error) {
+	cfg := s.config()
+	c, err := r.Cookie(cfg.cookieName())
+	if err != nil {
+		return "", errMissingToken
+	}
+	csrf, err := s.newCSRFToken(c.Value)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(rw, cfg.sessionCookie(cfg.csrfCookieName(), csrf, int(cfg.tokenTTL().Seconds()), false))
+	return csrf, nil
+}
+
+/*
+tokenFromRequest returns the token from the `Authorization`
+header or, if cookie mode is enabled, from the session cookie
+(in this case unsafe methods must carry a valid CSRF token)
+*/
+func (s *Service) tokenFromRequest(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.Replace(authHeader, "Bearer ", "", 1), nil
+	}
+	cfg := s.config()
+	if !cfg.CookieEnabled {
+		return "", errMissingToken
+	}
+	c, err := r.Cookie(cfg.cookieName())
+	if err != nil || c.Value == "" {
+		return "", errMissingToken
+	}
+	if !isSafeMethod(r.Method) {
+		if err := s.validateCSRF(r, c.Value); err != nil {
+			return "", err
+		}
+	}
+	return c.Value, nil
+}
+
+/*
+validateCSRF validates the double submitted CSRF token (the
+header must match the cookie and the token must be bound
+to the session token)
+*/
+func (s *Service) validateCSRF(r *http.Request, token string) error {
+	header := r.Header.Get(CSRFHeader)
+	if header == "" {
+		header = r.PostFormValue(CSRFFormField)
+	}
+	c, err := r.Cookie(s.config().csrfCookieName())
+	if err != nil || header == "" || !hmac.Equal([]byte(header), []byte(c.Value)) {
+		return errInvalidCSRFToken
+	}
+	parts := strings.Split(header, ".")
+	if len(parts) != 2 || !s.hmacVerify(csrfContent(parts[0], token), parts[1]) {
+		return errInvalidCSRFToken
+	}
+	return nil
+}
+
+func (s *Service) newCSRFToken(token string) (string, error) {
+	nonce, err := randomCode()
+	if err != nil {
+		return "", err
+	}
+	sig, err := s.hmacSign(csrfContent(nonce, token))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s", nonce, sig), nil
+}
+
+func csrfContent(nonce string, token string) string {
+	return fmt.Sprintf("%s.%s", nonce, hashCode(token))
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (c *Config) sessionCookie(name string, value string, maxAge int, httpOnly bool) *http.Cookie {
+	path := c.CookiePath
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   c.CookieDomain,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   c.CookieSecure,
+		SameSite: c.cookieSameSite(),
+	}
+}
+
+func (c *Config) cookieSameSite() http.SameSite {
+	switch strings.ToLower(c.CookieSameSite) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCookieSession(t *testing.T) {
+	viper.Set("auth.cookie.enabled", true)
+	viper.Set("auth.cookie.secure", false)
+	defer viper.Set("auth.cookie.enabled", false)
+
+	svc := newTestService(t)
+	h := NewHandlerCustom(svc)
+	setupUser(t, "cookie.user", "pass", svc)
+
+	mux := http.NewServeMux()
+	mux.Handle("/login", h.HandleLogin())
+	mux.Handle("/logout", h.HandleLogout())
+	mux.Handle("/protected", h.AuthInterceptor(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+
+	res, err := browser.Post(s.URL+"/login", "application/json", bytes.NewBufferString(`{"user":"cookie.user","pass":"pass"}`))
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	var body map[string]string
+	_ = json.NewDecoder(res.Body).Decode(&body)
+	if body["token"] != "" {
+		t.Error("Should not return the token in the body in cookie mode")
+	}
+	csrf := body["csrfToken"]
+	if csrf == "" {
+		t.Error("Should return the CSRF token")
+	}
+
+	do := func(method string, csrf string) int {
+		req, _ := http.NewRequest(method, s.URL+"/protected", nil)
+		if csrf != "" {
+			req.Header.Add(CSRFHeader, csrf)
+		}
+		res, err := browser.Do(req)
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		return res.StatusCode
+	}
+
+	if status := do(http.MethodGet, ""); status != http.StatusNoContent {
+		t.Errorf("Should accept safe request using cookie, but was '%d'", status)
+	}
+	if status := do(http.MethodPost, ""); status != http.StatusForbidden {
+		t.Errorf("Should reject unsafe request without CSRF token, but was '%d'", status)
+	}
+	if status := do(http.MethodPost, "abc.def"); status != http.StatusForbidden {
+		t.Errorf("Should reject unsafe request with invalid CSRF token, but was '%d'", status)
+	}
+	if status := do(http.MethodPost, csrf); status != http.StatusNoContent {
+		t.Errorf("Should accept unsafe request with CSRF token, but was '%d'", status)
+	}
+
+	if _, err := browser.Post(s.URL+"/logout", "application/json", nil); err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if status := do(http.MethodGet, ""); status != http.StatusForbidden {
+		t.Errorf("Should reject requests after logout, but was '%d'", status)
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+const (
+	emailNotVerified = "auth.user.email.not.verified"
+	emailInUse       = "auth.user.email.in.use"
+	emailNotDefined  = "auth.user.email.not.defined"
+)
+
+var errEmailRequired = errors.New("auth.user.email.required")
+
+/*
+SendEmailVerification sends the e-mail verification
+link to the user using the service notifier
+*/
+func (s *Service) SendEmailVerification(u *user.CredentialInfo) error {
+	if u.Email == nil {
+		return fmt.Errorf(emailNotDefined)
+	}
+	token, t, err := s.issueOneTimeToken(
+		NotificationEmailVerification,
+		u.ID,
+		s.config().EmailVerificationTTL,
+		u.GetEmail(),
+	)
+	if err != nil {
+		return err
+	}
+	return s.notify(Notification{
+		Kind:      NotificationEmailVerification,
+		To:        u.GetEmail(),
+		User:      u.View(),
+		Link:      tokenLink(s.config().EmailVerificationURL, token),
+		Token:     token,
+		ExpiresAt: t.ExpiresAt,
+	})
+}
+
+/*
+VerifyEmail marks the user e-mail as verified using
+the token sent by SendEmailVerification
+*/
+func (s *Service) VerifyEmail(token string) (*user.CredentialInfo, error) {
+	t, err := s.useOneTimeToken(NotificationEmailVerification, token)
+	if err != nil {
+		return nil, err
+	}
+	u := s.repo.FindUserByID(t.UserID)
+	// the token is bound to the address it was sent to
+	if u == nil || u.ID == 0 || u.GetEmail() != t.Data {
+		return nil, errInvalidOneTimeToken
+	}
+	now := s.now()
+	u.EmailVerifiedAt = &now
+	if err := s.repo.SaveUser(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+/*
+findUserByLogin finds the user by username or e-mail
+*/
+func (s *Service) findUserByLogin(login string) *user.CredentialInfo {
+	if u := s.repo.FindUser(login); u != nil {
+		return u
+	}
+	return s.repo.FindUserByEmail(login)
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestEmailVerificationFlow(t *testing.T) {
+	viper.Set("auth.user.email.verification.required", true)
+	viper.Set("auth.user.email.verification.ttl", "1h")
+	viper.Set("auth.user.email.verification.url", "https://auth.example.com/verify")
+	defer viper.Set("auth.user.email.verification.required", false)
+
+	svc := newTestService(t)
+	var sent []Notification
+	svc.SetNotifier(NotifierFunc(func(n Notification) error {
+		sent = append(sent, n)
+		return nil
+	}))
+
+	if _, err := svc.RegisterUser(&NewUser{User: "mail.user.000", Pass: "pass"}, nil, ""); err != errEmailRequired {
+		t.Errorf("Should require an e-mail, but returned '%v'", err)
+	}
+
+	if _, err := svc.RegisterUser(&NewUser{
+		User:   "mail.user.001",
+		Pass:   "pass",
+		Email:  "mail.user.001@example.com",
+		Active: true,
+	}, nil, ""); err != nil {
+		t.Errorf("Failed to register user: %s", err.Error())
+		t.FailNow()
+	}
+	if len(sent) != 1 || sent[0].Kind != NotificationEmailVerification || sent[0].To != "mail.user.001@example.com" {
+		t.Errorf("Should send the verification link, but sent '%v'", sent)
+		t.FailNow()
+	}
+	link, err := url.Parse(sent[0].Link)
+	if err != nil || link.Query().Get("token") != sent[0].Token {
+		t.Errorf("Should build the verification link, but was '%s'", sent[0].Link)
+	}
+
+	if _, err := svc.ValidatePass("mail.user.001@example.com", "pass"); err == nil || err.Error() != emailNotVerified {
+		t.Errorf("Should not login with unverified e-mail, but returned '%v'", err)
+	}
+
+	h := NewHandlerCustom(svc)
+	s := httptest.NewServer(h.HandleEmailVerification())
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "?token=" + url.QueryEscape(sent[0].Token))
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%s'", res.Status)
+	}
+
+	res, err = http.Get(s.URL + "?token=" + url.QueryEscape(sent[0].Token))
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should not accept the same token twice, but was '%s'", res.Status)
+	}
+
+	u, err := svc.ValidatePass("mail.user.001@example.com", "pass")
+	if err != nil {
+		t.Errorf("Should login by e-mail, but returned '%v'", err)
+		t.FailNow()
+	}
+	if u.User != "mail.user.001" {
+		t.Errorf("Should return user 'mail.user.001', but was '%s'", u.User)
+	}
+}
+
+func TestVerifyEmailForgedToken(t *testing.T) {
+	svc := newTestService(t)
+
+	if _, err := svc.VerifyEmail("abc.def"); err != errInvalidOneTimeToken {
+		t.Errorf("Should reject forged tokens, but returned '%v'", err)
+	}
+}
+
+func TestCreateNewUserDuplicatedEmail(t *testing.T) {
+	svc := newTestService(t)
+
+	if _, err := svc.CreateNewUser(&NewUser{User: "mail.user.002", Pass: "pass", Email: "dup@example.com"}); err != nil {
+		t.Errorf("Failed to create user: %s", err.Error())
+	}
+	if _, err := svc.CreateNewUser(&NewUser{User: "mail.user.003", Pass: "pass", Email: "dup@example.com"}); err == nil {
+		t.Error("Should not create two users with the same e-mail")
+	}
+	if _, err := svc.CreateNewUser(&NewUser{User: "mail.user.004", Pass: "pass"}); err != nil {
+		t.Errorf("Should create users without e-mail: %s", err.Error())
+	}
+	if _, err := svc.CreateNewUser(&NewUser{User: "mail.user.005", Pass: "pass"}); err != nil {
+		t.Errorf("Should create many users without e-mail: %s", err.Error())
+	}
+}
@@ -0,0 +1,472 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/config"
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/logger"
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+/*
+FederationCookie is the cookie holding the federated
+login state (state, nonce and PKCE verifier)
+*/
+const FederationCookie = "jwt-auth-federation"
+
+const (
+	federationStateTTL = 10 * time.Minute
+	federationLeeway   = time.Minute
+)
+
+var (
+	errUnknownProvider     = errors.New("auth.federation.provider.unknown")
+	errInvalidFederation   = errors.New("auth.federation.state.invalid")
+	errInvalidIDToken      = errors.New("auth.federation.id_token.invalid")
+	errFederationNotLinked = errors.New("auth.federation.identity.not.linked")
+
+	invalidUsernameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+)
+
+/*
+FederationProvider is an external OpenID Connect identity
+provider users can login with
+*/
+type FederationProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the URL of the HandleFederatedCallback handler
+	RedirectURL string
+	Scopes      []string
+	// Provisioning enables the just in time creation of
+	// local users for unknown external identities
+	Provisioning bool
+
+	mu       sync.Mutex
+	metadata *providerMetadata
+	keys     *jose.RemoteKeySet
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type federationState struct {
+	Provider string `json:"p"`
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	LinkTo   int    `json:"l,omitempty"`
+	Expires  int64  `json:"e"`
+}
+
+type idTokenClaims struct {
+	Issuer            string      `json:"iss"`
+	Subject           string      `json:"sub"`
+	Audience          interface{} `json:"aud"`
+	AuthorizedParty   string      `json:"azp"`
+	Expires           int64       `json:"exp"`
+	IssuedAt          int64       `json:"iat"`
+	Nonce             string      `json:"nonce"`
+	Email             string      `json:"email"`
+	EmailVerified     bool        `json:"email_verified"`
+	Name              string      `json:"name"`
+	PreferredUsername string      `json:"preferred_username"`
+}
+
+/*
+AddFederationProvider registers an external identity provider
+*/
+func (s *Service) AddFederationProvider(p *FederationProvider) {
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+	if s.providers == nil {
+		s.providers = make(map[string]*FederationProvider)
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+	}
+	s.providers[p.Name] = p
+}
+
+/*
+LoadFederationProviders registers the external identity
+providers configured in `auth.federation.providers.<name>`
+(issuer, client.id, client.secret, redirect.url, scopes
+and provisioning keys)
+*/
+func (s *Service) LoadFederationProviders() {
+	for _, p := range loadFederationProviders() {
+		s.AddFederationProvider(p)
+	}
+}
+
+func loadFederationProviders() []*FederationProvider {
+	var providers []*FederationProvider
+	for _, name := range config.GetFederationProviders() {
+		providers = append(providers, &FederationProvider{
+			Name:         name,
+			Issuer:       config.GetFederationProviderString(name, "issuer"),
+			ClientID:     config.GetFederationProviderString(name, "client.id"),
+			ClientSecret: config.GetFederationProviderString(name, "client.secret"),
+			RedirectURL:  config.GetFederationProviderString(name, "redirect.url"),
+			Scopes:       config.GetFederationProviderStrings(name, "scopes"),
+			Provisioning: config.GetFederationProviderBool(name, "provisioning"),
+		})
+	}
+	return providers
+}
+
+/*
+SetHTTPClient defines the client used to call external
+services (identity providers)
+*/
+func (s *Service) SetHTTPClient(c *http.Client) {
+	s.httpClient = c
+}
+
+/*
+FederatedLoginURL returns the provider authorization URL and
+the state cookie value. If linkTo is not nil the external
+identity will be linked to this user.
+*/
+func (s *Service) FederatedLoginURL(ctx context.Context, provider string, linkTo *user.CredentialInfo) (string, string, error) {
+	p := s.provider(provider)
+	if p == nil {
+		return "", "", errUnknownProvider
+	}
+	md, err := s.providerMetadata(ctx, p)
+	if err != nil {
+		return "", "", err
+	}
+	fs := federationState{
+		Provider: p.Name,
+		Expires:  s.now().Add(federationStateTTL).Unix(),
+	}
+	for _, v := range []*string{&fs.State, &fs.Nonce, &fs.Verifier} {
+		if *v, err = randomCode(); err != nil {
+			return "", "", err
+		}
+	}
+	if linkTo != nil {
+		fs.LinkTo = linkTo.ID
+	}
+	cookie, err := s.encodeFederationState(&fs)
+	if err != nil {
+		return "", "", err
+	}
+
+	u, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", fs.State)
+	q.Set("nonce", fs.Nonce)
+	q.Set("code_challenge", pkceChallenge(fs.Verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), cookie, nil
+}
+
+/*
+FederatedCallback finishes the federated login, exchanging the
+code for the provider ID token, validating it and returning the
+local user linked to the external identity (it's created if the
+provider has provisioning enabled)
+*/
+func (s *Service) FederatedCallback(ctx context.Context, cookie string, state string, code string) (*user.CredentialInfo, error) {
+	fs, err := s.decodeFederationState(cookie)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(fs.State), []byte(state)) != 1 {
+		return nil, errInvalidFederation
+	}
+	p := s.provider(fs.Provider)
+	if p == nil {
+		return nil, errUnknownProvider
+	}
+	raw, err := s.exchangeFederatedCode(ctx, p, code, fs.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := s.validateIDToken(ctx, p, raw, fs.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if i := s.repo.FindExternalIdentity(p.Name, claims.Subject); i != nil {
+		u := s.repo.FindUserByID(i.UserID)
+		if u == nil || u.ID == 0 {
+			return nil, errFederationNotLinked
+		}
+		if !u.Active {
+			return nil, errInactiveUser
+		}
+		return u, nil
+	}
+
+	var u *user.CredentialInfo
+	switch {
+	case fs.LinkTo != 0:
+		if u = s.repo.FindUserByID(fs.LinkTo); u == nil || u.ID == 0 {
+			return nil, errFederationNotLinked
+		}
+		if !u.Active {
+			return nil, errInactiveUser
+		}
+	case p.Provisioning:
+		if u, err = s.provisionFederatedUser(p, claims); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errFederationNotLinked
+	}
+	err = s.repo.SaveExternalIdentity(&user.ExternalIdentity{
+		Provider: p.Name,
+		Subject:  claims.Subject,
+		UserID:   u.ID,
+		Email:    claims.Email,
+	})
+	return u, err
+}
+
+func (s *Service) provider(name string) *FederationProvider {
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+	return s.providers[name]
+}
+
+func (s *Service) client() *http.Client {
+	if s.httpClient != nil {
+		return s.httpClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (s *Service) providerMetadata(ctx context.Context, p *FederationProvider) (*providerMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth.federation.discovery.failed: %s", res.Status)
+	}
+	var md providerMetadata
+	if err := json.NewDecoder(res.Body).Decode(&md); err != nil {
+		return nil, err
+	}
+	if md.Issuer != p.Issuer {
+		return nil, fmt.Errorf("auth.federation.discovery.issuer.mismatch: %s", md.Issuer)
+	}
+	p.metadata = &md
+	p.keys = jose.NewRemoteKeySet(md.JWKSURI, s.client())
+	return p.metadata, nil
+}
+
+func (s *Service) exchangeFederatedCode(ctx context.Context, p *FederationProvider, code string, verifier string) (string, error) {
+	md, err := s.providerMetadata(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {user.GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	res, err := s.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var tr TokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK || tr.IDToken == "" {
+		return "", fmt.Errorf("auth.federation.token.exchange.failed: %s", res.Status)
+	}
+	return tr.IDToken, nil
+}
+
+func (s *Service) validateIDToken(ctx context.Context, p *FederationProvider, raw string, nonce string) (*idTokenClaims, error) {
+	t, err := jose.Parse(raw)
+	if err != nil {
+		return nil, errInvalidIDToken
+	}
+	key, err := p.keys.Key(ctx, t.Header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Verify(key); err != nil {
+		return nil, errInvalidIDToken
+	}
+	var c idTokenClaims
+	if err := t.Claims(&c); err != nil {
+		return nil, errInvalidIDToken
+	}
+	now := s.now()
+	switch {
+	case c.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%s: issuer", errInvalidIDToken.Error())
+	case !audienceContains(c.Audience, p.ClientID):
+		return nil, fmt.Errorf("%s: audience", errInvalidIDToken.Error())
+	case c.AuthorizedParty != "" && c.AuthorizedParty != p.ClientID:
+		return nil, fmt.Errorf("%s: azp", errInvalidIDToken.Error())
+	case time.Unix(c.Expires, 0).Add(federationLeeway).Before(now):
+		return nil, fmt.Errorf("%s: expired", errInvalidIDToken.Error())
+	case time.Unix(c.IssuedAt, 0).Add(-federationLeeway).After(now):
+		return nil, fmt.Errorf("%s: issued in the future", errInvalidIDToken.Error())
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%s: nonce", errInvalidIDToken.Error())
+	case c.Subject == "":
+		return nil, fmt.Errorf("%s: subject", errInvalidIDToken.Error())
+	}
+	return &c, nil
+}
+
+func (s *Service) provisionFederatedUser(p *FederationProvider, c *idTokenClaims) (*user.CredentialInfo, error) {
+	candidates := []string{c.PreferredUsername}
+	if i := strings.Index(c.Email, "@"); i > 0 {
+		candidates = append(candidates, c.Email[:i])
+	}
+	candidates = append(candidates, fmt.Sprintf("%s-%s", p.Name, c.Subject))
+
+	username := ""
+	for _, candidate := range candidates {
+		candidate = strings.Trim(invalidUsernameChars.ReplaceAllString(candidate, "-"), "-")
+		if candidate == "" {
+			continue
+		}
+		for i := 0; i < 10 && username == ""; i++ {
+			name := candidate
+			if i > 0 {
+				name = fmt.Sprintf("%s-%d", candidate, i)
+			}
+			if !s.repo.UserExists(name) {
+				username = name
+			}
+		}
+		if username != "" {
+			break
+		}
+	}
+	if username == "" {
+		return nil, fmt.Errorf("auth.federation.provisioning.username.unavailable")
+	}
+
+	// federated users don't login with password, so it's random
+	pass, err := randomCode()
+	if err != nil {
+		return nil, err
+	}
+	email := c.Email
+	if email != "" && s.repo.FindUserByEmail(email) != nil {
+		logger.Logger().WithField("provider", p.Name).Warn("E-mail already in use, provisioning user without e-mail")
+		email = ""
+	}
+	name := c.Name
+	if name == "" {
+		name = username
+	}
+	u, err := s.CreateNewUser(&NewUser{
+		User:   username,
+		Pass:   pass,
+		Name:   name,
+		Email:  email,
+		Active: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if u.Email != nil && c.EmailVerified {
+		now := s.now()
+		u.EmailVerifiedAt = &now
+		err = s.repo.SaveUser(u)
+	}
+	return u, err
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if v == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Service) encodeFederationState(fs *federationState) (string, error) {
+	b, err := json.Marshal(fs)
+	if err != nil {
+		return "", err
+	}
+	payload := jose.Encode(b)
+	sig, err := s.hmacSign("federation." + payload)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s", payload, sig), nil
+}
+
+func (s *Service) decodeFederationState(cookie string) (*federationState, error) {
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 2 || !s.hmacVerify("federation."+parts[0], parts[1]) {
+		return nil, errInvalidFederation
+	}
+	b, err := jose.Decode(parts[0])
+	if err != nil {
+		return nil, errInvalidFederation
+	}
+	var fs federationState
+	if err := json.Unmarshal(b, &fs); err != nil {
+		return nil, errInvalidFederation
+	}
+	if time.Unix(fs.Expires, 0).Before(s.now()) {
+		return nil, errInvalidFederation
+	}
+	return &fs, nil
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/spf13/viper"
+)
+
+type fakeProvider struct {
+	server *httptest.Server
+	key    *jose.Key
+	// claims returned in the ID token issued for each code
+	codes map[string]map[string]interface{}
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Errorf("Failed to generate key: %s", err.Error())
+		t.FailNow()
+	}
+	p := &fakeProvider{
+		key:   jose.NewRSAKey("idp-key", pk, &pk.PublicKey),
+		codes: make(map[string]map[string]interface{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(rw).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, r *http.Request) {
+		jwk, _ := p.key.PublicJWK()
+		_ = json.NewEncoder(rw).Encode(jose.JWKS{Keys: []jose.JWK{jwk}})
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, r *http.Request) {
+		claims, ok := p.codes[r.FormValue("code")]
+		if id, secret, _ := r.BasicAuth(); !ok || id != "jwt-auth" || secret != "idp-secret" || r.FormValue("code_verifier") == "" {
+			rw.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(rw).Encode(oauthError(oauthInvalidGrant, ""))
+			return
+		}
+		idToken, _ := jose.Sign(claims, p.key)
+		_ = json.NewEncoder(rw).Encode(TokenResponse{AccessToken: "idp-access-token", TokenType: "Bearer", IDToken: idToken})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+/*
+login starts the federated login and simulates the provider
+redirecting back to the callback with a code for the claims
+*/
+func (p *fakeProvider) login(t *testing.T, h *Handler, sub string, nonce string) *http.Response {
+	location, state, err := h.svc.FederatedLoginURL(context.Background(), "idp", nil)
+	if err != nil {
+		t.Errorf("Failed to get federated login URL: %s", err.Error())
+		t.FailNow()
+	}
+	u, _ := url.Parse(location)
+	if nonce == "" {
+		nonce = u.Query().Get("nonce")
+	}
+	code := sub + "-code"
+	p.codes[code] = map[string]interface{}{
+		"iss":                p.server.URL,
+		"aud":                "jwt-auth",
+		"sub":                sub,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"email":              "federated@example.com",
+		"email_verified":     true,
+		"preferred_username": "federated",
+	}
+
+	s := httptest.NewServer(h.HandleFederatedCallback())
+	defer s.Close()
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"?"+url.Values{"state": {u.Query().Get("state")}, "code": {code}}.Encode(), nil)
+	req.AddCookie(&http.Cookie{Name: FederationCookie, Value: state})
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	return res
+}
+
+func setupFederation(t *testing.T) (*Handler, *fakeProvider) {
+	p := newFakeProvider(t)
+	svc := newTestService(t)
+	svc.AddFederationProvider(&FederationProvider{
+		Name:         "idp",
+		Issuer:       p.server.URL,
+		ClientID:     "jwt-auth",
+		ClientSecret: "idp-secret",
+		RedirectURL:  "https://app.example.com/federation/callback",
+		Provisioning: true,
+	})
+	return NewHandlerCustom(svc), p
+}
+
+func TestFederatedLoginProvisioning(t *testing.T) {
+	h, p := setupFederation(t)
+
+	res := p.login(t, h, "external-123", "")
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%s'", res.Status)
+		t.FailNow()
+	}
+	var body map[string]string
+	_ = json.NewDecoder(res.Body).Decode(&body)
+	claims, err := h.svc.FromJWT(body["token"])
+	if err != nil || claims[TokenDataUser] != "federated" {
+		t.Errorf("Invalid token claims '%v' (%v)", claims, err)
+	}
+	u := h.svc.GetRepository().FindUser("federated")
+	if u == nil || u.GetEmail() != "federated@example.com" || !u.IsEmailVerified() {
+		t.Errorf("Should provision the user with a verified e-mail '%v'", u)
+		t.FailNow()
+	}
+
+	// logging in again maps to the same user
+	res = p.login(t, h, "external-123", "")
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%s'", res.Status)
+	}
+	i := h.svc.GetRepository().FindExternalIdentity("idp", "external-123")
+	if i == nil || i.UserID != u.ID {
+		t.Errorf("External identity should be linked to the user '%v'", i)
+	}
+	if h.svc.GetRepository().FindUser("federated-1") != nil {
+		t.Error("Should not provision the user twice")
+	}
+}
+
+func TestFederatedLoginInactiveUser(t *testing.T) {
+	h, p := setupFederation(t)
+	if res := p.login(t, h, "external-123", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%s'", res.Status)
+		t.FailNow()
+	}
+	disableUser(t, h.svc, "federated")
+
+	if res := p.login(t, h, "external-123", ""); res.StatusCode == http.StatusOK {
+		t.Error("Inactive users must not login with linked identities")
+	}
+}
+
+func TestFederatedLoginInvalidNonce(t *testing.T) {
+	h, p := setupFederation(t)
+
+	res := p.login(t, h, "external-123", "replayed-nonce")
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should return 403 (Forbidden), but was '%s'", res.Status)
+	}
+	if h.svc.GetRepository().FindUser("federated") != nil {
+		t.Error("Should not provision the user")
+	}
+}
+
+func TestFederatedLoginUnknownProvider(t *testing.T) {
+	h := NewHandlerCustom(newTestService(t))
+	s := httptest.NewServer(h.HandleFederatedLogin())
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "?provider=unknown")
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Should return 404 (Not Found), but was '%s'", res.Status)
+	}
+}
+
+func TestFederationStateForgedWithEmptyKey(t *testing.T) {
+	viper.Set("auth.jwt.algorithm", jose.RS256)
+	secret := viper.GetString("auth.jwt.secret")
+	viper.Set("auth.jwt.secret", "")
+	defer viper.Set("auth.jwt.algorithm", jose.HS256)
+	defer viper.Set("auth.jwt.secret", secret)
+
+	if _, err := LoadService(nil); err != errMissingSecret {
+		t.Errorf("RS256 must also require a secret outside dev mode, but was '%v'", err)
+	}
+	svc := newTestService(t)
+	b, _ := json.Marshal(&federationState{Provider: "idp", State: "state", LinkTo: 1, Expires: time.Now().Add(time.Hour).Unix()})
+	payload := jose.Encode(b)
+	forged := payload + "." + hmacHex(nil, "federation."+payload)
+	if _, err := svc.decodeFederationState(forged); err != errInvalidFederation {
+		t.Errorf("Must reject states signed with an empty key, but was '%v'", err)
+	}
+	if _, err := svc.encodeFederationState(&federationState{Provider: "idp"}); err == nil {
+		t.Error("Must not sign states with an empty key")
+	}
+}
+
+func TestFederationStateExpires(t *testing.T) {
+	h, _ := setupFederation(t)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	h.svc.SetClock(func() time.Time { return now })
+
+	_, state, err := h.svc.FederatedLoginURL(context.Background(), "idp", nil)
+	if err != nil {
+		t.Errorf("Failed to create the login URL: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := h.svc.decodeFederationState(state); err != nil {
+		t.Errorf("Must accept federation states before they expire, but was '%v'", err)
+	}
+	now = now.Add(federationStateTTL + time.Minute)
+	if _, err := h.svc.decodeFederationState(state); err != errInvalidFederation {
+		t.Errorf("Must reject federation states after they expire, but was '%v'", err)
+	}
+}
+
+func TestFederatedLinkInactiveUser(t *testing.T) {
+	h, p := setupFederation(t)
+	setupUser(t, "link.user", "pass", h.svc)
+	u := h.svc.GetRepository().FindUser("link.user")
+	location, state, err := h.svc.FederatedLoginURL(context.Background(), "idp", u)
+	if err != nil {
+		t.Errorf("Failed to get federated login URL: %s", err.Error())
+		t.FailNow()
+	}
+	disableUser(t, h.svc, "link.user")
+
+	query, _ := url.Parse(location)
+	p.codes["link-code"] = map[string]interface{}{
+		"iss":   p.server.URL,
+		"aud":   "jwt-auth",
+		"sub":   "external-link",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": query.Query().Get("nonce"),
+	}
+	if _, err := h.svc.FederatedCallback(context.Background(), state, query.Query().Get("state"), "link-code"); err != errInactiveUser {
+		t.Errorf("Must not link identities to inactive users, but was '%v'", err)
+	}
+	if i := h.svc.GetRepository().FindExternalIdentity("idp", "external-link"); i != nil {
+		t.Errorf("Must not save the external identity, but was '%v'", i)
+	}
+}
+
+func TestFederatedLoginIgnoresAPIKeys(t *testing.T) {
+	h, _ := setupFederation(t)
+	setupUser(t, "link.user", "pass", h.svc)
+	u := h.svc.GetRepository().FindUser("link.user")
+	_, key, err := h.svc.CreateAPIKey(u, &NewAPIKey{Name: "narrow"})
+	if err != nil {
+		t.Errorf("Failed to create API key: %s", err.Error())
+		t.FailNow()
+	}
+	for _, test := range []struct {
+		header string
+		token  string
+		linkTo int
+	}{
+		{APIKeyHeader, key, 0},
+		{"Authorization", "Bearer " + issueTestToken(t, h.svc, "link.user"), u.ID},
+	} {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/?provider=idp", nil)
+		req.Header.Set(test.header, test.token)
+		h.HandleFederatedLogin().ServeHTTP(rw, req)
+		cookies := rw.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Errorf("%s: should set the state cookie, but was '%v'", test.header, cookies)
+			continue
+		}
+		fs, err := h.svc.decodeFederationState(cookies[0].Value)
+		if err != nil || fs.LinkTo != test.linkTo {
+			t.Errorf("%s: should link to '%d', but was '%v' (%v)", test.header, test.linkTo, fs, err)
+		}
+	}
+}
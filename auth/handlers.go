@@ -2,37 +2,43 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 
-	"github.com/eldius/jwt-auth-go/config"
 	"github.com/eldius/jwt-auth-go/logger"
 )
 
 /*
 LoginRequest is the model to decode login payload
+(user accepts the username or the user e-mail)
 */
 type LoginRequest struct {
 	User string `json:"user"`
 	Pass string `json:"pass"`
+	// Scope is optional (space separated), by
+	// default all the allowed scopes are granted
+	Scope string `json:"scope"`
+	// Audience is optional, it must be one of
+	// the allowed audiences (`auth.jwt.audiences`)
+	Audience string `json:"audience"`
 }
 
 /*
 NewUserRequest is the model to decode new user request
 */
 type NewUserRequest struct {
-	User   string `json:"user"`
-	Pass   string `json:"pass"`
-	Name   string `json:"name"`
-	Active bool   `json:"active"`
-	Admin  bool   `json:"admin"`
+	User       string   `json:"user"`
+	Pass       string   `json:"pass"`
+	Name       string   `json:"name"`
+	Active     bool     `json:"active"`
+	Admin      bool     `json:"admin"`
+	Roles      []string `json:"roles"`
+	Invitation string   `json:"invitation"`
+	Email      string   `json:"email"`
 }
 
-/*
-ContextKey is the key used to add user data into requests context
-*/
-type ContextKey string
-
 /*
 Handler is the object who will take care of authorization validation
 */
@@ -40,11 +46,6 @@ type Handler struct {
 	svc *Service
 }
 
-const (
-	// CurrentUserKey constant for the name used when add user data into request context
-	CurrentUserKey ContextKey = "currentUser"
-)
-
 /*
 NewHandler creates a new handler creating a default service instance
 */
@@ -98,15 +99,17 @@ func (h *Handler) HandleLogin() http.HandlerFunc {
 				return
 			}
 
-			token, err := h.svc.ToJWT(*cred)
+			token, err := h.svc.ToJWTWithAudience(*cred, u.Scope, u.Audience)
 			if err != nil {
 				log.Println(err.Error())
-				rw.WriteHeader(500)
+				if err.Error() == invalidAudience {
+					rw.WriteHeader(http.StatusBadRequest)
+				} else {
+					rw.WriteHeader(500)
+				}
+				return
 			}
-			rw.WriteHeader(200)
-			_ = json.NewEncoder(rw).Encode(&map[string]string{
-				"token": token,
-			})
+			h.writeToken(rw, token)
 		} else {
 			rw.WriteHeader(http.StatusMethodNotAllowed)
 		}
@@ -129,6 +132,382 @@ func (h *Handler) HandleUser() http.HandlerFunc {
 	}
 }
 
+/*
+HandleMe returns the current user's profile and roles
+(the request must carry a valid token)
+*/
+func (h *Handler) HandleMe() http.HandlerFunc {
+	me := h.AuthInterceptor(func(rw http.ResponseWriter, r *http.Request) {
+		u := h.svc.GetCurrentUser(r)
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(u.View())
+	})
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		me.ServeHTTP(rw, r)
+	}
+}
+
+/*
+HandleInvitation creates invitation codes used to
+register users when registration is invite only
+(only admins are allowed)
+*/
+func (h *Handler) HandleInvitation() http.HandlerFunc {
+	invite := h.AuthInterceptor(func(rw http.ResponseWriter, r *http.Request) {
+		code, inv, err := h.svc.CreateInvitation(h.svc.GetCurrentUser(r))
+		if err != nil {
+			log.Println(err.Error())
+			if errors.Is(err, errRegistrationForbidden) {
+				rw.WriteHeader(http.StatusForbidden)
+			} else {
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(rw).Encode(&map[string]interface{}{
+			"code":      code,
+			"expiresAt": inv.ExpiresAt,
+		})
+	})
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		invite.ServeHTTP(rw, r)
+	}
+}
+
+/*
+HandleEmailVerification handles e-mail verification, GET
+requests verify the e-mail using the `token` query param
+(the link sent to the user) and POST requests resend the
+verification link to the current user
+*/
+func (h *Handler) HandleEmailVerification() http.HandlerFunc {
+	resend := h.AuthInterceptor(func(rw http.ResponseWriter, r *http.Request) {
+		if err := h.svc.SendEmailVerification(h.svc.GetCurrentUser(r)); err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	})
+	return func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			u, err := h.svc.VerifyEmail(r.URL.Query().Get("token"))
+			if err != nil {
+				log.Println(err.Error())
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+			rw.Header().Add("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(rw).Encode(u.View())
+		case http.MethodPost:
+			resend.ServeHTTP(rw, r)
+		default:
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+/*
+MagicLinkRequest is the model to decode magic link requests
+(user accepts the username or the user e-mail)
+*/
+type MagicLinkRequest struct {
+	User string `json:"user"`
+}
+
+/*
+HandleMagicLinkRequest sends a login link to the user
+*/
+func (h *Handler) HandleMagicLinkRequest() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var m MagicLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil || m.User == "" {
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		var nonce string
+		if h.svc.config().MagicLinkBindBrowser {
+			var err error
+			if nonce, err = randomCode(); err != nil {
+				log.Println(err.Error())
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := h.svc.SendMagicLink(m.User, nonce); err != nil {
+			log.Println(err.Error())
+			if errors.Is(err, errMagicLinkDisabled) {
+				rw.WriteHeader(http.StatusForbidden)
+			} else {
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		if nonce != "" {
+			http.SetCookie(rw, &http.Cookie{
+				Name:     MagicLinkCookie,
+				Value:    nonce,
+				Path:     "/",
+				MaxAge:   int(h.svc.config().MagicLinkTTL.Seconds()),
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	}
+}
+
+/*
+HandleMagicLinkConsume exchanges the magic link
+token (`token` query param) for an access token
+*/
+func (h *Handler) HandleMagicLinkConsume() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var nonce string
+		if c, err := r.Cookie(MagicLinkCookie); err == nil {
+			nonce = c.Value
+		}
+		token, err := h.svc.ConsumeMagicLink(r.URL.Query().Get("token"), nonce)
+		if err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.SetCookie(rw, &http.Cookie{
+			Name:   MagicLinkCookie,
+			Path:   "/",
+			MaxAge: -1,
+		})
+		rw.Header().Add("Content-Type", "application/json")
+		h.writeToken(rw, token)
+	}
+}
+
+/*
+HandleFederatedLogin redirects the user to the external
+identity provider (`provider` query param), if the user
+is already logged in the external identity is linked
+*/
+func (h *Handler) HandleFederatedLogin() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		linkTo, _ := h.svc.authenticate(r, WithoutAPIKeys())
+		location, state, err := h.svc.FederatedLoginURL(r.Context(), r.URL.Query().Get("provider"), linkTo)
+		if err != nil {
+			log.Println(err.Error())
+			if errors.Is(err, errUnknownProvider) {
+				rw.WriteHeader(http.StatusNotFound)
+			} else {
+				rw.WriteHeader(http.StatusBadGateway)
+			}
+			return
+		}
+		http.SetCookie(rw, &http.Cookie{
+			Name:     FederationCookie,
+			Value:    state,
+			Path:     "/",
+			MaxAge:   int(federationStateTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(rw, r, location, http.StatusFound)
+	}
+}
+
+/*
+HandleFederatedCallback finishes the federated login
+(provider redirect URL), exchanging the code for an
+access token
+*/
+func (h *Handler) HandleFederatedCallback() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		c, err := r.Cookie(FederationCookie)
+		if err != nil {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.SetCookie(rw, &http.Cookie{
+			Name:   FederationCookie,
+			Path:   "/",
+			MaxAge: -1,
+		})
+		q := r.URL.Query()
+		if q.Get("error") != "" {
+			log.Println("Federated login failed:", q.Get("error"))
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		u, err := h.svc.FederatedCallback(r.Context(), c.Value, q.Get("state"), q.Get("code"))
+		if err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		token, err := h.svc.ToJWT(*u)
+		if err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		h.writeToken(rw, token)
+	}
+}
+
+/*
+HandleAPIKeys handles the current user API keys, GET requests
+list the keys, POST requests create a new key (the plaintext key
+is returned only here) and DELETE requests delete the key (`id`
+query param). API keys can't be used to create new keys.
+*/
+func (h *Handler) HandleAPIKeys() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		u, tokenData, err := h.svc.authenticateToken(r)
+		if err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			rw.Header().Add("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(rw).Encode(h.svc.GetRepository().ListAPIKeys(u.ID))
+		case http.MethodPost:
+			if tokenData[TokenDataAPIKeyID] != "" {
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+			var nk NewAPIKey
+			if err := json.NewDecoder(r.Body).Decode(&nk); err != nil {
+				log.Println(err.Error())
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			k, plaintext, err := h.svc.CreateAPIKey(u, &nk)
+			if err != nil {
+				log.Println(err.Error())
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			rw.Header().Add("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(rw).Encode(&map[string]interface{}{
+				"apiKey": k,
+				"key":    plaintext,
+			})
+		case http.MethodDelete:
+			id, err := strconv.Atoi(r.URL.Query().Get("id"))
+			if err != nil || !h.svc.GetRepository().DeleteAPIKey(u.ID, id) {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			rw.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+/*
+HandleLogout clears the session cookies
+*/
+func (h *Handler) HandleLogout() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		h.svc.ClearSessionCookies(rw)
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+/*
+HandleCSRFToken issues a new CSRF token for the
+current session (cookie mode)
+*/
+func (h *Handler) HandleCSRFToken() http.HandlerFunc {
+	issue := h.AuthInterceptor(func(rw http.ResponseWriter, r *http.Request) {
+		csrf, err := h.svc.IssueCSRFToken(rw, r)
+		if err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(&map[string]string{
+			"csrfToken": csrf,
+		})
+	})
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		issue.ServeHTTP(rw, r)
+	}
+}
+
+/*
+writeToken writes the token to the response body or, if
+cookie mode is enabled, to the session cookie (returning
+just the CSRF token in the body)
+*/
+func (h *Handler) writeToken(rw http.ResponseWriter, token string) {
+	if h.svc.config().CookieEnabled {
+		csrf, err := h.svc.SetSessionCookies(rw, token)
+		if err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(&map[string]string{
+			"csrfToken": csrf,
+		})
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(&map[string]string{
+		"token": token,
+	})
+}
+
 /*
 GetService returns the service used by this handler
 */
@@ -140,8 +519,23 @@ func (h *Handler) GetService() *Service {
 AuthInterceptor is the interceptor used to validate users
 is logged and its login data is valid
 */
-func (h *Handler) AuthInterceptor(f http.HandlerFunc) http.Handler {
-	return h.svc.AuthInterceptor(f)
+func (h *Handler) AuthInterceptor(f http.HandlerFunc, opts ...InterceptorOption) http.Handler {
+	return h.svc.AuthInterceptor(f, opts...)
+}
+
+/*
+Middleware returns the AuthInterceptor as a standard middleware
+*/
+func (h *Handler) Middleware(opts ...InterceptorOption) func(http.Handler) http.Handler {
+	return h.svc.Middleware(opts...)
+}
+
+/*
+RequireScopes is an interceptor to validate user is logged and
+its token was granted all the scopes
+*/
+func (h *Handler) RequireScopes(f http.HandlerFunc, scopes ...string) http.Handler {
+	return h.svc.RequireScopes(f, scopes...)
 }
 
 func (h *Handler) createNewUser(rw http.ResponseWriter, r *http.Request) {
@@ -154,15 +548,27 @@ func (h *Handler) createNewUser(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := h.svc.CreateNewUser(&NewUser{
+	// the requester is optional, it's only used to allow admins
+	// to create other admins (or when registration is restricted)
+	requester, _ := h.svc.authenticate(r)
+
+	if _, err := h.svc.RegisterUser(&NewUser{
 		User:   u.User,
 		Pass:   u.Pass,
 		Name:   u.Name,
-		Active: config.GetUserDefaultActive(),
+		Active: h.svc.config().UserDefaultActive,
 		Admin:  u.Admin,
-	}); err != nil {
+		Roles:  u.Roles,
+		Email:  u.Email,
+	}, requester, u.Invitation); err != nil {
 		log.Println(err.Error())
-		rw.WriteHeader(http.StatusUnprocessableEntity)
+		if errors.Is(err, errRegistrationDisabled) ||
+			errors.Is(err, errRegistrationForbidden) ||
+			errors.Is(err, errInvalidInvitation) {
+			rw.WriteHeader(http.StatusForbidden)
+		} else {
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+		}
 		_, _ = rw.Write([]byte(err.Error()))
 		return
 	}
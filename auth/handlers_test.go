@@ -26,26 +26,22 @@ const (
 		"user":"valid.user",
 		"pass":"pass",
 		"name":"name",
-		"active":true,
-		"admin":true
+		"active":true
 	}`
 	userlessUserPayload = `{
 		"pass":"pass",
 		"name":"name",
-		"active":true,
-		"admin":true
+		"active":true
 	}`
 	passlessUserPayload = `{
 		"user":"valid.user1",
 		"name":"name",
-		"active":true,
-		"admin":true
+		"active":true
 	}`
 	invalidActiveUserPayload = `{
 		"user":"valid.user2",
 		"name":"name",
-		"active":active,
-		"admin":true
+		"active":active
 	}`
 )
 
@@ -290,6 +286,76 @@ func TestAuthInterceptorWithoutJWTToken(t *testing.T) {
 	}
 }
 
+func TestHandleMe(t *testing.T) {
+	h := NewHandler()
+
+	usrN := "test.user.003"
+	usrP := "test-strong-pass-003"
+
+	setupUser(t, usrN, usrP, h.svc)
+
+	svc := h.GetService()
+	c := svc.GetRepository().FindUser(usrN)
+
+	jwt, err := svc.ToJWT(*c)
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+
+	s := httptest.NewServer(h.HandleMe())
+	defer s.Close()
+
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		t.Errorf("Failed to create request: %s", err.Error())
+		t.FailNow()
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%s'", res.Status)
+		t.FailNow()
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Errorf("Failed to decode response: %s", err.Error())
+		t.FailNow()
+	}
+	if body["user"] != usrN {
+		t.Errorf("Should return user '%s', but was '%v'", usrN, body["user"])
+	}
+	for _, k := range []string{"Hash", "Salt", "hash", "salt"} {
+		if _, ok := body[k]; ok {
+			t.Errorf("Response must not carry '%s'", k)
+		}
+	}
+}
+
+func TestHandleMeWithoutJWTToken(t *testing.T) {
+	h := NewHandler()
+
+	s := httptest.NewServer(h.HandleMe())
+	defer s.Close()
+
+	res, err := http.Get(s.URL)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should return 403 (Forbidden), but was '%s'", res.Status)
+	}
+}
+
 func setupUser(t *testing.T, usrN string, usrP string, svc *Service) {
 	_, err := svc.CreateNewUser(&NewUser{
 		User:   usrN,
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/*
+InterceptorOption customizes the token validation
+of an AuthInterceptor instance
+*/
+type InterceptorOption func(*interceptorOptions)
+
+type interceptorOptions struct {
+	issuers      []string
+	audiences    []string
+	optional     bool
+	noAPIKeys    bool
+	errorHandler ErrorHandler
+}
+
+/*
+ErrorHandler writes the response of the requests
+rejected by the interceptor
+*/
+type ErrorHandler func(rw http.ResponseWriter, r *http.Request, err error)
+
+/*
+WithIssuers makes the interceptor accept only tokens
+issued by one of the issuers (`iss` claim)
+*/
+func WithIssuers(issuers ...string) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.issuers = append(o.issuers, issuers...)
+	}
+}
+
+/*
+WithAudiences makes the interceptor accept only tokens
+issued to one of the audiences (`aud` claim)
+*/
+func WithAudiences(audiences ...string) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.audiences = append(o.audiences, audiences...)
+	}
+}
+
+/*
+WithOptionalAuth makes the interceptor let anonymous requests
+through (the user is added to the context only if the request
+has a token, invalid tokens are still rejected)
+*/
+func WithOptionalAuth() InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.optional = true
+	}
+}
+
+/*
+WithoutAPIKeys makes the interceptor reject API keys (they're
+limited to their scopes, so they must not be accepted by the
+endpoints that grant scopes or manage credentials)
+*/
+func WithoutAPIKeys() InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.noAPIKeys = true
+	}
+}
+
+/*
+WithErrorHandler replaces the response of the rejected
+requests (a 403 with the error code by default)
+*/
+func WithErrorHandler(h ErrorHandler) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.errorHandler = h
+	}
+}
+
+/*
+WithBearerChallenge makes the interceptor answer rejected requests
+with a 401 and the `WWW-Authenticate` challenge (RFC 6750), the
+error code is sent in the `error_description`
+*/
+func WithBearerChallenge(realm string) InterceptorOption {
+	return WithErrorHandler(func(rw http.ResponseWriter, r *http.Request, err error) {
+		challenge := "Bearer"
+		params := make([]string, 0, 3)
+		if realm != "" {
+			params = append(params, fmt.Sprintf(`realm="%s"`, realm))
+		}
+		// requests without token must not receive an error code
+		if !isMissingToken(err) {
+			params = append(params, `error="invalid_token"`, fmt.Sprintf(`error_description="%s"`, err.Error()))
+		}
+		if len(params) > 0 {
+			challenge += " " + strings.Join(params, ", ")
+		}
+		rw.Header().Set("WWW-Authenticate", challenge)
+		rw.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+/*
+forbidden is the default error handler
+*/
+func forbidden(rw http.ResponseWriter, r *http.Request, err error) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(rw).Encode(&map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func isMissingToken(err error) bool {
+	return errors.Is(err, errMissingToken)
+}
+
+func newInterceptorOptions(opts []InterceptorOption) *interceptorOptions {
+	o := &interceptorOptions{
+		errorHandler: forbidden,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+/*
+validate checks the token data against the expected
+issuers and audiences (and rejects API keys if disabled)
+*/
+func (o *interceptorOptions) validate(tokenData map[string]string) error {
+	if o.noAPIKeys && tokenData[TokenDataAPIKeyID] != "" {
+		return errAPIKeyNotAllowed
+	}
+	if len(o.issuers) > 0 && !containsScope(o.issuers, tokenData[TokenDataIssuer]) {
+		return fmt.Errorf(invalidIssuer)
+	}
+	if len(o.audiences) > 0 && !containsAny(o.audiences, strings.Fields(tokenData[TokenDataAudience])) {
+		return fmt.Errorf(invalidAudience)
+	}
+	return nil
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, c := range candidates {
+		if containsScope(values, c) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Middleware returns the AuthInterceptor as a standard middleware
+(`func(http.Handler) http.Handler`), so it can be used with any
+router (net/http, chi, gorilla...)
+*/
+func (s *Service) Middleware(opts ...InterceptorOption) func(http.Handler) http.Handler {
+	o := newInterceptorOptions(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			u, tokenData, err := s.authenticateToken(r)
+			if err != nil && o.optional && isMissingToken(err) {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			if err == nil {
+				err = o.validate(tokenData)
+			}
+			if err != nil {
+				log.Println(err.Error())
+				o.errorHandler(rw, r, err)
+				return
+			}
+			next.ServeHTTP(rw, r.WithContext(authContext(r.Context(), u, tokenData, s.requestToken(r))))
+		})
+	}
+}
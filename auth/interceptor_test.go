@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestAuthInterceptorIssuerAndAudience(t *testing.T) {
+	viper.Set("auth.jwt.issuer", testIssuer)
+	viper.Set("auth.jwt.audiences", []string{"billing", "admin"})
+	defer viper.Set("auth.jwt.issuer", "")
+	defer viper.Set("auth.jwt.audiences", []string{})
+
+	svc := newTestService(t)
+	setupUser(t, "aud.user", "pass", svc)
+	u := *svc.GetRepository().FindUser("aud.user")
+	billing, err := svc.ToJWTWithAudience(u, "", "billing")
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc.ToJWTWithAudience(u, "", "unknown"); err == nil || err.Error() != invalidAudience {
+		t.Errorf("Should not issue tokens to unknown audiences, but was '%v'", err)
+	}
+
+	ok := func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	}
+	for _, test := range []struct {
+		name   string
+		opts   []InterceptorOption
+		status int
+		code   string
+	}{
+		{"no options", nil, http.StatusNoContent, ""},
+		{"same audience", []InterceptorOption{WithIssuers(testIssuer), WithAudiences("billing")}, http.StatusNoContent, ""},
+		{"other audience", []InterceptorOption{WithAudiences("admin")}, http.StatusForbidden, invalidAudience},
+		{"other issuer", []InterceptorOption{WithIssuers("https://other.example.com")}, http.StatusForbidden, invalidIssuer},
+	} {
+		s := httptest.NewServer(svc.AuthInterceptor(ok, test.opts...))
+		req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", billing))
+		res, err := http.DefaultClient.Do(req)
+		s.Close()
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		var body map[string]string
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		if res.StatusCode != test.status || body["error"] != test.code {
+			t.Errorf("%s: should return '%d' '%s', but was '%s' '%v'", test.name, test.status, test.code, res.Status, body)
+		}
+	}
+}
+
+func TestMiddlewareOptionalAuth(t *testing.T) {
+	svc := newTestService(t)
+	setupUser(t, "optional.user", "pass", svc)
+	jwt, err := svc.ToJWT(*svc.GetRepository().FindUser("optional.user"))
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		if u, ok := UserFromContext(r.Context()); ok {
+			_, _ = rw.Write([]byte(u.User))
+		}
+	})
+	s := httptest.NewServer(svc.Middleware(WithOptionalAuth())(mux))
+	defer s.Close()
+
+	for _, test := range []struct {
+		name   string
+		token  string
+		status int
+		body   string
+	}{
+		{"anonymous", "", http.StatusOK, ""},
+		{"authenticated", jwt, http.StatusOK, "optional.user"},
+		{"invalid token", "invalid", http.StatusForbidden, ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+		if test.token != "" {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", test.token))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		body, _ := io.ReadAll(res.Body)
+		if res.StatusCode != test.status || (test.status == http.StatusOK && string(body) != test.body) {
+			t.Errorf("%s: should return '%d' '%s', but was '%s' '%s'", test.name, test.status, test.body, res.Status, body)
+		}
+	}
+}
+
+func TestMiddlewareBearerChallenge(t *testing.T) {
+	svc := newTestService(t)
+	h := svc.Middleware(WithBearerChallenge("api"))(http.NotFoundHandler())
+
+	for _, test := range []struct {
+		name      string
+		token     string
+		challenge string
+	}{
+		{"missing token", "", `Bearer realm="api"`},
+		{"invalid token", "invalid", fmt.Sprintf(`Bearer realm="api", error="invalid_token", error_description="%s"`, invalidJwtFormat)},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.token != "" {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", test.token))
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != http.StatusUnauthorized || rw.Header().Get("WWW-Authenticate") != test.challenge {
+			t.Errorf("%s: should return 401 '%s', but was '%d' '%s'", test.name, test.challenge, rw.Code, rw.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestIsMissingToken(t *testing.T) {
+	if !isMissingToken(fmt.Errorf("cookie session: %w", errMissingToken)) {
+		t.Error("Wrapped missing token errors must be detected")
+	}
+	if isMissingToken(errors.New(errMissingToken.Error())) {
+		t.Error("Errors with the same message must not be detected")
+	}
+}
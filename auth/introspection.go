@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+// Token type hints (RFC 7009)
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+/*
+Introspection is the token introspection response (RFC 7662),
+inactive tokens have just the `active` field
+*/
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	SubType   string `json:"sub_type,omitempty"`
+	// Cnf is the certificate binding of mTLS bound tokens
+	Cnf map[string]string `json:"cnf,omitempty"`
+}
+
+/*
+IntrospectToken returns the access token state, the token
+is active only if it's valid, not revoked and its subject
+(user or client) still exists and is active
+*/
+func (s *Service) IntrospectToken(token string) *Introspection {
+	inactive := &Introspection{Active: false}
+	tokenData, err := s.validateToken(token)
+	if err != nil {
+		return inactive
+	}
+	switch tokenData[TokenDataSubjectType] {
+	case "", SubjectTypeUser, SubjectTypeService:
+		u := s.repo.FindUser(tokenData[TokenDataUser])
+		if u == nil || !u.Active {
+			return inactive
+		}
+	case SubjectTypeClient:
+		c := s.repo.FindClient(tokenData[TokenDataClientID])
+		if c == nil || !c.Active {
+			return inactive
+		}
+	default:
+		return inactive
+	}
+	var cnf map[string]string
+	if thumbprint := tokenData[TokenDataCertThumbprint]; thumbprint != "" {
+		cnf = map[string]string{"x5t#S256": thumbprint}
+	}
+	return &Introspection{
+		Active:    true,
+		Scope:     tokenData[TokenDataScope],
+		ClientID:  tokenData[TokenDataClientID],
+		Username:  tokenData[TokenDataUser],
+		TokenType: "Bearer",
+		Exp:       unixTime(tokenData[TokenDataExpires]),
+		Iat:       unixTime(tokenData[TokenDataIssued]),
+		Sub:       tokenData[TokenDataUser],
+		Aud:       tokenData[TokenDataAudience],
+		Jti:       tokenData[TokenDataID],
+		SubType:   tokenData[TokenDataSubjectType],
+		Cnf:       cnf,
+	}
+}
+
+/*
+RevokeToken revokes the access or refresh token issued to the
+client (tokens issued by the login endpoint can be revoked only
+by first party clients). As RFC 7009 requires, invalid tokens or
+tokens issued to other clients are ignored.
+*/
+func (s *Service) RevokeToken(c *user.OAuthClient, token string, hint string) error {
+	if hint == TokenTypeHintRefreshToken {
+		if ok, err := s.revokeRefreshToken(c, token); ok || err != nil {
+			return err
+		}
+		return s.revokeAccessToken(c, token)
+	}
+	if err := s.revokeAccessToken(c, token); err != nil {
+		return err
+	}
+	_, err := s.revokeRefreshToken(c, token)
+	return err
+}
+
+func (s *Service) revokeAccessToken(c *user.OAuthClient, token string) error {
+	tokenData, err := s.FromJWT(token)
+	if err != nil || s.config().validateTokenData(tokenData, s.now()) != nil {
+		return nil
+	}
+	jti := tokenData[TokenDataID]
+	if jti == "" || !canRevoke(c, tokenData[TokenDataClientID]) {
+		return nil
+	}
+	rt := &user.RevokedToken{JTI: jti}
+	if exp := unixTime(tokenData[TokenDataExpires]); exp > 0 {
+		expiresAt := time.Unix(exp, 0)
+		rt.ExpiresAt = &expiresAt
+	}
+	return s.repo.SaveRevokedToken(rt)
+}
+
+func (s *Service) revokeRefreshToken(c *user.OAuthClient, token string) (bool, error) {
+	t, err := s.findOneTimeToken(purposeOAuthRefresh, token)
+	if err != nil {
+		return false, nil
+	}
+	var g oauthGrant
+	if err := json.Unmarshal([]byte(t.Data), &g); err != nil {
+		return false, err
+	}
+	if !canRevoke(c, g.ClientID) {
+		return false, nil
+	}
+	s.repo.UseOneTimeToken(t, s.now())
+	return true, nil
+}
+
+func canRevoke(c *user.OAuthClient, clientID string) bool {
+	if clientID == "" {
+		return c.FirstParty
+	}
+	return clientID == c.ClientID
+}
+
+func unixTime(value string) int64 {
+	t, err := parseTokenTime(value)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
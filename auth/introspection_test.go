@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/eldius/jwt-auth-go/user"
+	"github.com/spf13/viper"
+)
+
+func TestIntrospectAndRevokeAccessToken(t *testing.T) {
+	viper.Set("auth.jwt.ttl", "1h")
+	defer viper.Set("auth.jwt.ttl", "")
+
+	o := setupOAuth(t, &NewClient{
+		Name:       "API gateway",
+		GrantTypes: []string{user.GrantClientCredentials},
+		FirstParty: true,
+	})
+
+	status, body := o.clientRequest(t, "/introspect", url.Values{"token": {o.token}})
+	if status != http.StatusOK || body["active"] != true || body["username"] != "oauth.user" {
+		t.Errorf("Token should be active, but was '%d' '%v'", status, body)
+	}
+	if body["exp"] == nil || body["iat"] == nil || body["jti"] == nil {
+		t.Errorf("Introspection should return the token times and ID '%v'", body)
+	}
+
+	status, _ = o.clientRequest(t, "/revoke", url.Values{"token": {o.token}, "token_type_hint": {TokenTypeHintAccessToken}})
+	if status != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%d'", status)
+	}
+
+	_, body = o.clientRequest(t, "/introspect", url.Values{"token": {o.token}})
+	if body["active"] != false || len(body) != 1 {
+		t.Errorf("Revoked token should be inactive, but was '%v'", body)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, o.server.URL+"/protected", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", o.token))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Revoked token should be rejected, but was '%s'", res.Status)
+	}
+
+	// invalid tokens are ignored
+	status, _ = o.clientRequest(t, "/revoke", url.Values{"token": {"invalid"}})
+	if status != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%d'", status)
+	}
+}
+
+func TestIntrospectRequiresClientAuthentication(t *testing.T) {
+	o := setupOAuth(t, &NewClient{
+		Name:       "API gateway",
+		GrantTypes: []string{user.GrantClientCredentials},
+	})
+	o.secret = "wrong-secret"
+
+	status, body := o.clientRequest(t, "/introspect", url.Values{"token": {o.token}})
+	if status != http.StatusUnauthorized || body["error"] != oauthInvalidClient {
+		t.Errorf("Should return 401 (Unauthorized), but was '%d' '%v'", status, body)
+	}
+	status, _ = o.clientRequest(t, "/revoke", url.Values{"token": {o.token}})
+	if status != http.StatusUnauthorized {
+		t.Errorf("Should return 401 (Unauthorized), but was '%d'", status)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	o := setupOAuth(t, &NewClient{
+		Name:         "Third party app",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{"read"},
+		FirstParty:   true,
+	})
+	_, body := o.tokenRequest(t, url.Values{
+		"grant_type":   {user.GrantAuthorizationCode},
+		"code":         {oidcCode(t, o, "read", "")},
+		"redirect_uri": {testRedirectURI},
+	})
+	refresh, _ := body["refresh_token"].(string)
+	if refresh == "" {
+		t.Errorf("Should issue the refresh token '%v'", body)
+		t.FailNow()
+	}
+
+	// a third party token isn't revoked by the first party client
+	other, secret, _ := o.svc.RegisterClient(&NewClient{Name: "Other app", RedirectURIs: []string{testRedirectURI}})
+	thirdParty := &oauthTestSetup{svc: o.svc, server: o.server, client: other, secret: secret}
+	if status, _ := thirdParty.clientRequest(t, "/revoke", url.Values{"token": {refresh}}); status != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%d'", status)
+	}
+	if _, err := o.svc.findOneTimeToken(purposeOAuthRefresh, refresh); err != nil {
+		t.Error("Refresh token should be revoked only by its client")
+	}
+
+	status, _ := o.clientRequest(t, "/revoke", url.Values{"token": {refresh}, "token_type_hint": {TokenTypeHintRefreshToken}})
+	if status != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%d'", status)
+	}
+	status, body = o.tokenRequest(t, url.Values{
+		"grant_type":    {user.GrantRefreshToken},
+		"refresh_token": {refresh},
+	})
+	if status != http.StatusBadRequest || body["error"] != oauthInvalidGrant {
+		t.Errorf("Revoked refresh token should be rejected, but was '%d' '%v'", status, body)
+	}
+}
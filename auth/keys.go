@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/config"
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	ephemeralKeyOnce sync.Once
+	ephemeralKey     *rsa.PrivateKey
+	ephemeralKeyErr  error
+)
+
+/*
+retiredKey is a rotated key, it's still accepted to
+verify tokens until the grace period ends
+*/
+type retiredKey struct {
+	key   *jose.Key
+	until time.Time
+}
+
+/*
+keyRing holds the current key of a key source (the secret
+reference or the RSA key file) and the retired ones
+*/
+type keyRing struct {
+	source  string
+	digest  [sha256.Size]byte
+	current *jose.Key
+	retired []retiredKey
+	// stale forces the source to be loaded again
+	// (its content changed)
+	stale bool
+}
+
+/*
+loaded returns if the current key was loaded from the source
+*/
+func (k *keyRing) loaded(source string) bool {
+	return k.current != nil && k.source == source && !k.stale
+}
+
+/*
+rotate replaces the current key, if its content changed the
+previous key is retired until the end of the grace period
+*/
+func (k *keyRing) rotate(key *jose.Key, source string, digest [sha256.Size]byte, now time.Time, grace time.Duration) {
+	if k.current != nil && k.digest == digest {
+		k.source = source
+		k.stale = false
+		return
+	}
+	if k.current != nil && grace > 0 {
+		k.retired = append(k.retired, retiredKey{key: k.current, until: now.Add(grace)})
+	}
+	k.current = key
+	k.source = source
+	k.digest = digest
+	k.stale = false
+}
+
+/*
+keys returns the current key followed by the retired
+keys still in the grace period
+*/
+func (k *keyRing) keys(now time.Time) []*jose.Key {
+	retired := k.retired[:0]
+	for _, r := range k.retired {
+		if now.Before(r.until) {
+			retired = append(retired, r)
+		}
+	}
+	k.retired = retired
+
+	keys := []*jose.Key{k.current}
+	for _, r := range k.retired {
+		keys = append(keys, r.key)
+	}
+	return keys
+}
+
+/*
+signingKey returns the key used to sign the access
+tokens (`auth.jwt.algorithm` config key)
+*/
+func (s *Service) signingKey() (*jose.Key, error) {
+	if s.config().Algorithm == jose.RS256 {
+		return s.rsaKey()
+	}
+	secrets, err := s.hmacSecrets()
+	if err != nil {
+		return nil, err
+	}
+	return jose.NewHMACKey("", secrets[0]), nil
+}
+
+/*
+verificationKeys returns the keys accepted to verify the access
+tokens (only the configured algorithm is accepted), the current
+key comes first and then the keys retired in the grace period
+*/
+func (s *Service) verificationKeys(h jose.Header) ([]*jose.Key, error) {
+	cfg := s.config()
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	ring := &s.hmacKeys
+	if cfg.Algorithm == jose.RS256 {
+		ring = &s.rsaKeys
+		if err := s.loadRSAKey(cfg); err != nil {
+			return nil, err
+		}
+	} else if err := s.loadHMACKey(cfg); err != nil {
+		return nil, err
+	}
+
+	var keys []*jose.Key
+	for _, k := range ring.keys(s.now()) {
+		if h.Alg == k.Algorithm && (h.Kid == "" || h.Kid == k.ID) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, jose.ErrKeyNotFound
+	}
+	return keys, nil
+}
+
+/*
+hmacSecrets returns the current HMAC secret followed by the
+secrets retired in the grace period (they are used to sign
+and verify the access, CSRF and one time tokens)
+*/
+func (s *Service) hmacSecrets() ([][]byte, error) {
+	cfg := s.config()
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	if err := s.loadHMACKey(cfg); err != nil {
+		return nil, err
+	}
+	var secrets [][]byte
+	for _, k := range s.hmacKeys.keys(s.now()) {
+		secrets = append(secrets, k.Secret)
+	}
+	return secrets, nil
+}
+
+/*
+loadHMACKey resolves the secret reference (if it changed
+or its file was modified), keyMu must be held
+*/
+func (s *Service) loadHMACKey(cfg *Config) error {
+	if s.hmacKeys.loaded(cfg.Secret) {
+		return nil
+	}
+	secret := s.devSecret
+	if cfg.Secret != "" {
+		var err error
+		if secret, err = config.ResolveSecret(cfg.Secret); err != nil {
+			return err
+		}
+	}
+	if len(secret) == 0 {
+		return errMissingSecret
+	}
+	s.hmacKeys.rotate(jose.NewHMACKey("", secret), cfg.Secret, sha256.Sum256(secret), s.now(), cfg.RotationGrace)
+	return nil
+}
+
+/*
+rsaKey returns the RSA key used to sign RS256 access tokens
+and OpenID Connect ID tokens. If there is no key file
+configured an ephemeral key is generated (tokens signed by
+it become invalid when the process restarts).
+*/
+func (s *Service) rsaKey() (*jose.Key, error) {
+	cfg := s.config()
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	if err := s.loadRSAKey(cfg); err != nil {
+		return nil, err
+	}
+	return s.rsaKeys.current, nil
+}
+
+/*
+loadRSAKey loads the RSA key file (if it changed or
+was modified), keyMu must be held
+*/
+func (s *Service) loadRSAKey(cfg *Config) error {
+	file := cfg.KeyFile
+	if s.rsaKeys.loaded(file) {
+		return nil
+	}
+
+	var pk *rsa.PrivateKey
+	if file == "" {
+		ephemeralKeyOnce.Do(func() {
+			logger.Logger().Warn("No RSA key file configured, using an ephemeral key")
+			ephemeralKey, ephemeralKeyErr = rsa.GenerateKey(rand.Reader, 2048)
+		})
+		if ephemeralKeyErr != nil {
+			return ephemeralKeyErr
+		}
+		pk = ephemeralKey
+	} else {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if pk, err = jose.ParseRSAPrivateKeyPEM(b); err != nil {
+			return err
+		}
+	}
+
+	digest := sha256.Sum256(pk.PublicKey.N.Bytes())
+	kid := cfg.KeyID
+	if kid == "" {
+		kid = hex.EncodeToString(digest[:8])
+	}
+	s.rsaKeys.rotate(jose.NewRSAKey(kid, pk, nil), file, digest, s.now(), cfg.RotationGrace)
+	return nil
+}
+
+/*
+PublicKeys returns the public keys used to verify the
+tokens signed by the service (JWKS), the keys retired
+in the grace period are also published
+*/
+func (s *Service) PublicKeys() (jose.JWKS, error) {
+	cfg := s.config()
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	if err := s.loadRSAKey(cfg); err != nil {
+		return jose.JWKS{}, err
+	}
+	jwks := jose.JWKS{Keys: []jose.JWK{}}
+	for _, k := range s.rsaKeys.keys(s.now()) {
+		if jwk, ok := k.PublicJWK(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks, nil
+}
+
+/*
+ReloadKeys loads again the secret and the RSA key file
+(rotated keys are retired until the end of the grace period)
+*/
+func (s *Service) ReloadKeys() error {
+	cfg := s.config()
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	s.hmacKeys.stale = true
+	s.rsaKeys.stale = true
+	if err := s.loadHMACKey(cfg); err != nil {
+		return err
+	}
+	if cfg.Algorithm == jose.RS256 || s.rsaKeys.current != nil {
+		return s.loadRSAKey(cfg)
+	}
+	return nil
+}
+
+/*
+hmacSign signs the content with the current HMAC secret
+(hex encoded HMAC-SHA256)
+*/
+func (s *Service) hmacSign(content string) (string, error) {
+	secrets, err := s.hmacSecrets()
+	if err != nil {
+		return "", err
+	}
+	if len(secrets[0]) == 0 {
+		return "", errMissingSecret
+	}
+	return hmacHex(secrets[0], content), nil
+}
+
+/*
+hmacVerify checks the content signature using the current
+secret and the secrets retired in the grace period
+*/
+func (s *Service) hmacVerify(content string, signature string) bool {
+	secrets, err := s.hmacSecrets()
+	if err != nil {
+		return false
+	}
+	for _, secret := range secrets {
+		if len(secret) == 0 {
+			continue
+		}
+		if hmac.Equal([]byte(signature), []byte(hmacHex(secret, content))) {
+			return true
+		}
+	}
+	return false
+}
+
+func hmacHex(secret []byte, content string) string {
+	h := hmac.New(sha256.New, secret)
+	_, _ = h.Write([]byte(content))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+/*
+WatchKeys watches the secret file (`file://` secret reference)
+and the RSA key file configured when it's called, they are
+reloaded when changed until the context is done. The files
+directories are watched, so files replaced by renames or
+symlinks swaps (eg: Kubernetes secrets) are also detected.
+*/
+func (s *Service) WatchKeys(ctx context.Context) error {
+	cfg := s.config()
+	dirs := map[string]bool{}
+	for _, file := range []string{config.SecretFile(cfg.Secret), cfg.KeyFile} {
+		if file != "" {
+			dirs[filepath.Dir(file)] = true
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+
+	log := logger.Logger()
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				if err := s.ReloadKeys(); err != nil {
+					log.WithError(err).Warn("Failed to reload the keys")
+				} else {
+					log.WithField("file", e.Name).Info("Keys reloaded")
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Warn("Failed to watch the key files")
+			}
+		}
+	}()
+	return nil
+}
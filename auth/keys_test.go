@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/config"
+	"github.com/eldius/jwt-auth-go/jose"
+)
+
+func writeFile(t *testing.T, file string, content string) {
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Errorf("Failed to write file: %s", err.Error())
+		t.FailNow()
+	}
+}
+
+func issueTestToken(t *testing.T, svc *Service, username string) string {
+	if svc.GetRepository().FindUser(username) == nil {
+		setupUser(t, username, "pass", svc)
+	}
+	token, err := svc.ToJWT(*svc.GetRepository().FindUser(username))
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+	return token
+}
+
+func TestSecretReferences(t *testing.T) {
+	dir := t.TempDir()
+	binary := []byte{0, 1, 2, 254, 255}
+	encoded := config.SecretBase64Prefix + base64.StdEncoding.EncodeToString(binary)
+	writeFile(t, filepath.Join(dir, "secret"), "file-secret\n")
+	writeFile(t, filepath.Join(dir, "binary"), encoded)
+	t.Setenv("JWT_AUTH_TEST_SECRET", "env-secret")
+
+	for _, test := range []struct {
+		ref    string
+		secret string
+	}{
+		{config.SecretFilePrefix + filepath.Join(dir, "secret"), "file-secret"},
+		{config.SecretEnvPrefix + "JWT_AUTH_TEST_SECRET", "env-secret"},
+		{encoded, string(binary)},
+		{config.SecretFilePrefix + filepath.Join(dir, "binary"), string(binary)},
+		{"plain-secret", "plain-secret"},
+	} {
+		svc := newConfigTestService(t, &Config{Secret: test.ref})
+		token := issueTestToken(t, svc, "ref.user")
+		parsed, err := jose.Parse(token)
+		if err != nil {
+			t.Errorf("%s: failed to parse token: %v", test.ref, err)
+			continue
+		}
+		if err := parsed.Verify(jose.NewHMACKey("", []byte(test.secret))); err != nil {
+			t.Errorf("%s: must sign with the resolved secret, but was '%v'", test.ref, err)
+		}
+	}
+
+	for _, ref := range []string{
+		config.SecretFilePrefix + filepath.Join(dir, "missing"),
+		config.SecretEnvPrefix + "JWT_AUTH_TEST_MISSING",
+		config.SecretBase64Prefix + "not base64!",
+	} {
+		if err := (&Config{Secret: ref}).Validate(); !errors.Is(err, config.ErrInvalidSecret) {
+			t.Errorf("%s: must be rejected, but was '%v'", ref, err)
+		}
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	writeFile(t, file, "secret-1")
+	svc := newConfigTestService(t, &Config{Secret: config.SecretFilePrefix + file, RotationGrace: time.Hour})
+	now := time.Now()
+	svc.SetClock(func() time.Time { return now })
+
+	old := issueTestToken(t, svc, "rotation.user")
+	writeFile(t, file, "secret-2")
+	if err := svc.ReloadKeys(); err != nil {
+		t.Errorf("Failed to reload keys: %s", err.Error())
+		t.FailNow()
+	}
+	token := issueTestToken(t, svc, "rotation.user")
+	parsed, _ := jose.Parse(token)
+	if err := parsed.Verify(jose.NewHMACKey("", []byte("secret-2"))); err != nil {
+		t.Errorf("Must sign with the new secret, but was '%v'", err)
+	}
+	if _, err := svc.FromJWT(token); err != nil {
+		t.Errorf("Must accept tokens signed with the new secret, but was '%v'", err)
+	}
+	if _, err := svc.FromJWT(old); err != nil {
+		t.Errorf("Must accept tokens signed with the old secret in the grace period, but was '%v'", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := svc.FromJWT(old); err == nil {
+		t.Errorf("Must reject tokens signed with the old secret after the grace period")
+	}
+}
+
+func TestRSAKeyRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key.pem")
+	writeKey := func() {
+		pk, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			t.Errorf("Failed to generate key: %s", err.Error())
+			t.FailNow()
+		}
+		writeFile(t, file, string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pk)})))
+	}
+	writeKey()
+	svc := newConfigTestService(t, &Config{Secret: "rsa-secret", Algorithm: jose.RS256, KeyFile: file, RotationGrace: time.Hour})
+
+	old := issueTestToken(t, svc, "rsa.user")
+	writeKey()
+	if err := svc.ReloadKeys(); err != nil {
+		t.Errorf("Failed to reload keys: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc.FromJWT(old); err != nil {
+		t.Errorf("Must accept tokens signed with the old key in the grace period, but was '%v'", err)
+	}
+	jwks, err := svc.PublicKeys()
+	if err != nil || len(jwks.Keys) != 2 {
+		t.Errorf("Must publish the current and the old keys, but was '%v' (%v)", jwks, err)
+	}
+}
+
+func TestWatchKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	writeFile(t, file, "secret-1")
+	svc := newConfigTestService(t, &Config{Secret: config.SecretFilePrefix + file, RotationGrace: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := svc.WatchKeys(ctx); err != nil {
+		t.Errorf("Failed to watch keys: %s", err.Error())
+		t.FailNow()
+	}
+
+	old := issueTestToken(t, svc, "watch.user")
+	writeFile(t, file, "secret-2")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		parsed, _ := jose.Parse(issueTestToken(t, svc, "watch.user"))
+		if parsed.Verify(jose.NewHMACKey("", []byte("secret-2"))) == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("Must reload the secret when the file changes")
+			t.FailNow()
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := svc.FromJWT(old); err != nil {
+		t.Errorf("Must accept tokens signed with the old secret in the grace period, but was '%v'", err)
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"github.com/eldius/jwt-auth-go/logger"
+)
+
+/*
+MagicLinkCookie is the cookie used to bind magic links
+to the browser who requested it
+*/
+const MagicLinkCookie = "jwt-auth-magic-link"
+
+var errMagicLinkDisabled = errors.New("auth.magiclink.disabled")
+
+/*
+SendMagicLink sends a login link to the user identified by
+login (username or e-mail). The nonce binds the link to the
+requester (an empty nonce means the link is not bound).
+Unknown users are silently ignored to avoid exposing which
+users exist.
+*/
+func (s *Service) SendMagicLink(login string, nonce string) error {
+	cfg := s.config()
+	if !cfg.MagicLinkEnabled {
+		return errMagicLinkDisabled
+	}
+	u := s.findUserByLogin(login)
+	if u == nil || u.Email == nil {
+		logger.Logger().WithField("login", login).Info("Magic link requested for unknown user")
+		return nil
+	}
+	if cfg.EmailVerificationRequired && u.EmailVerifiedAt == nil {
+		logger.Logger().WithField("login", login).Info("Magic link requested for unverified e-mail")
+		return nil
+	}
+	var data string
+	if nonce != "" {
+		data = hashCode(nonce)
+	}
+	token, t, err := s.issueOneTimeToken(NotificationMagicLink, u.ID, cfg.MagicLinkTTL, data)
+	if err != nil {
+		return err
+	}
+	return s.notify(Notification{
+		Kind:      NotificationMagicLink,
+		To:        u.GetEmail(),
+		User:      u.View(),
+		Link:      tokenLink(cfg.MagicLinkURL, token),
+		Token:     token,
+		ExpiresAt: t.ExpiresAt,
+	})
+}
+
+/*
+ConsumeMagicLink exchanges the magic link token for
+an access token (each link is accepted only once). The
+browser binding is checked before the link is marked as
+used, so requests from other browsers (or link prefetchers)
+don't burn it.
+*/
+func (s *Service) ConsumeMagicLink(token string, nonce string) (string, error) {
+	if !s.config().MagicLinkEnabled {
+		return "", errMagicLinkDisabled
+	}
+	t, err := s.findOneTimeToken(NotificationMagicLink, token)
+	if err != nil {
+		return "", err
+	}
+	if t.Data != "" && subtle.ConstantTimeCompare([]byte(t.Data), []byte(hashCode(nonce))) != 1 {
+		return "", errInvalidOneTimeToken
+	}
+	if !s.repo.UseOneTimeToken(t, s.now()) {
+		return "", errInvalidOneTimeToken
+	}
+	u := s.repo.FindUserByID(t.UserID)
+	if u == nil || u.ID == 0 || !u.Active {
+		return "", errInvalidOneTimeToken
+	}
+	return s.ToJWT(*u)
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func setupMagicLink(t *testing.T, bind bool) (*Handler, *[]Notification) {
+	viper.Set("auth.magiclink.enabled", true)
+	viper.Set("auth.magiclink.ttl", "10m")
+	viper.Set("auth.magiclink.bind.browser", bind)
+	t.Cleanup(func() {
+		viper.Set("auth.magiclink.enabled", false)
+		viper.Set("auth.magiclink.bind.browser", false)
+	})
+
+	svc := newTestService(t)
+	sent := []Notification{}
+	svc.SetNotifier(NotifierFunc(func(n Notification) error {
+		sent = append(sent, n)
+		return nil
+	}))
+	if _, err := svc.CreateNewUser(&NewUser{
+		User:   "magic.user",
+		Pass:   "pass",
+		Email:  "magic.user@example.com",
+		Active: true,
+	}); err != nil {
+		t.Errorf("Failed to create test user: %v", err)
+		t.FailNow()
+	}
+	return NewHandlerCustom(svc), &sent
+}
+
+func TestMagicLinkFlow(t *testing.T) {
+	h, sent := setupMagicLink(t, false)
+
+	mux := http.NewServeMux()
+	mux.Handle("/request", h.HandleMagicLinkRequest())
+	mux.Handle("/consume", h.HandleMagicLinkConsume())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	res, err := http.Post(s.URL+"/request", "application/json", bytes.NewBufferString(`{"user":"magic.user@example.com"}`))
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("Should return 202 (Accepted), but was '%s'", res.Status)
+	}
+	if len(*sent) != 1 || (*sent)[0].Kind != NotificationMagicLink {
+		t.Errorf("Should send the magic link, but sent '%v'", *sent)
+		t.FailNow()
+	}
+
+	consume := s.URL + "/consume?token=" + url.QueryEscape((*sent)[0].Token)
+	res, err = http.Get(consume)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%s'", res.Status)
+	}
+
+	res, err = http.Get(consume)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should not accept the same link twice, but was '%s'", res.Status)
+	}
+}
+
+func TestMagicLinkUnknownUser(t *testing.T) {
+	h, sent := setupMagicLink(t, false)
+
+	s := httptest.NewServer(h.HandleMagicLinkRequest())
+	defer s.Close()
+
+	res, err := http.Post(s.URL, "application/json", bytes.NewBufferString(`{"user":"nobody"}`))
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("Should return 202 (Accepted), but was '%s'", res.Status)
+	}
+	if len(*sent) != 0 {
+		t.Errorf("Should not send anything, but sent '%v'", *sent)
+	}
+}
+
+func TestMagicLinkBoundToBrowser(t *testing.T) {
+	h, sent := setupMagicLink(t, true)
+
+	mux := http.NewServeMux()
+	mux.Handle("/request", h.HandleMagicLinkRequest())
+	mux.Handle("/consume", h.HandleMagicLinkConsume())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+
+	if _, err := browser.Post(s.URL+"/request", "application/json", bytes.NewBufferString(`{"user":"magic.user"}`)); err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if len(*sent) != 1 {
+		t.Errorf("Should send one magic link, but sent '%v'", *sent)
+		t.FailNow()
+	}
+
+	// another browser (without the cookie)
+	res, err := http.Get(s.URL + "/consume?token=" + url.QueryEscape((*sent)[0].Token))
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should not accept link from another browser, but was '%s'", res.Status)
+	}
+
+	// the rejected request must not burn the link
+	res, err = browser.Get(s.URL + "/consume?token=" + url.QueryEscape((*sent)[0].Token))
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Should return 200 (OK), but was '%s'", res.Status)
+	}
+
+	res, err = browser.Get(s.URL + "/consume?token=" + url.QueryEscape((*sent)[0].Token))
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should accept the link only once, but was '%s'", res.Status)
+	}
+}
+
+func TestMagicLinkInactiveUser(t *testing.T) {
+	h, sent := setupMagicLink(t, false)
+	if err := h.svc.SendMagicLink("magic.user", ""); err != nil || len(*sent) != 1 {
+		t.Errorf("Should send the magic link, but was '%v' (%v)", *sent, err)
+		t.FailNow()
+	}
+	disableUser(t, h.svc, "magic.user")
+
+	if _, err := h.svc.ConsumeMagicLink((*sent)[0].Token, ""); err == nil {
+		t.Error("Inactive users must not login with magic links")
+	}
+}
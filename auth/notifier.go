@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/eldius/jwt-auth-go/logger"
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+// Notification kinds
+const (
+	NotificationEmailVerification = "email-verification"
+	NotificationMagicLink         = "magic-link"
+)
+
+/*
+Notification is the message sent to users
+(verification links, etc)
+*/
+type Notification struct {
+	Kind      string
+	To        string
+	User      user.UserView
+	Link      string
+	Token     string
+	ExpiresAt time.Time
+}
+
+/*
+Notifier delivers notifications to users (by e-mail,
+SMS, chat, etc)
+*/
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+/*
+NotifierFunc is an adapter to use ordinary functions
+as notifiers
+*/
+type NotifierFunc func(n Notification) error
+
+/*
+Notify calls f(n)
+*/
+func (f NotifierFunc) Notify(n Notification) error {
+	return f(n)
+}
+
+/*
+SetNotifier defines the notifier used to deliver
+notifications to users
+*/
+func (s *Service) SetNotifier(n Notifier) {
+	s.notifier = n
+}
+
+func (s *Service) notify(n Notification) error {
+	if s.notifier == nil {
+		logger.Logger().
+			WithField("kind", n.Kind).
+			WithField("user", n.User.User).
+			Warn("No notifier configured, notification discarded")
+		return nil
+	}
+	return s.notifier.Notify(n)
+}
@@ -0,0 +1,481 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/hashtools"
+	"github.com/eldius/jwt-auth-go/logger"
+	"github.com/eldius/jwt-auth-go/user"
+	"github.com/google/uuid"
+)
+
+// One time token purposes used by the OAuth flows
+const (
+	purposeOAuthCode    = "oauth-code"
+	purposeOAuthRefresh = "oauth-refresh"
+)
+
+// OAuth token defaults (used when the config keys are empty)
+const (
+	defaultOAuthCodeTTL    = 60 * time.Second
+	defaultOAuthRefreshTTL = 30 * 24 * time.Hour
+)
+
+// OAuth error codes (RFC 6749)
+const (
+	oauthInvalidRequest       = "invalid_request"
+	oauthInvalidClient        = "invalid_client"
+	oauthInvalidGrant         = "invalid_grant"
+	oauthInvalidScope         = "invalid_scope"
+	oauthUnauthorizedClient   = "unauthorized_client"
+	oauthUnsupportedGrantType = "unsupported_grant_type"
+	oauthUnsupportedResponse  = "unsupported_response_type"
+	oauthAccessDenied         = "access_denied"
+	oauthServerError          = "server_error"
+)
+
+/*
+OAuthError is an error returned by the OAuth flows,
+Code is one of the RFC 6749 error codes
+*/
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func oauthError(code string, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+/*
+NewClient is a VO to pass the new OAuth clients
+registration parameters
+*/
+type NewClient struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grantTypes"`
+	Audience     string   `json:"audience"`
+	Public       bool     `json:"public"`
+	FirstParty   bool     `json:"firstParty"`
+}
+
+/*
+AuthorizeRequest holds the authorization endpoint parameters
+*/
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+/*
+TokenResponse is the token endpoint response
+*/
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+/*
+oauthGrant is the data bound to authorization codes
+and refresh tokens
+*/
+type oauthGrant struct {
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri,omitempty"`
+	Scope         string `json:"scope"`
+	CodeChallenge string `json:"code_challenge,omitempty"`
+	Nonce         string `json:"nonce,omitempty"`
+	AuthTime      int64  `json:"auth_time,omitempty"`
+}
+
+/*
+RegisterClient registers a new OAuth client, the client
+secret is returned only here (public clients have no secret)
+*/
+func (s *Service) RegisterClient(nc *NewClient) (c *user.OAuthClient, secret string, err error) {
+	if nc.Name == "" {
+		return nil, "", oauthError(oauthInvalidRequest, "client name must not be empty")
+	}
+	grants := nc.GrantTypes
+	if len(grants) == 0 {
+		grants = []string{user.GrantAuthorizationCode, user.GrantRefreshToken}
+	}
+	for _, g := range grants {
+		switch g {
+		case user.GrantAuthorizationCode, user.GrantRefreshToken:
+		case user.GrantClientCredentials:
+			if nc.Public {
+				return nil, "", oauthError(oauthInvalidRequest, "public clients can't use client credentials")
+			}
+		default:
+			return nil, "", oauthError(oauthUnsupportedGrantType, g)
+		}
+	}
+	c = &user.OAuthClient{
+		ClientID:     uuid.New().String(),
+		Name:         nc.Name,
+		RedirectURIs: strings.Join(nc.RedirectURIs, " "),
+		Scopes:       strings.Join(nc.Scopes, " "),
+		GrantTypes:   strings.Join(grants, " "),
+		Audience:     nc.Audience,
+		Public:       nc.Public,
+		FirstParty:   nc.FirstParty,
+		Active:       true,
+	}
+	if !nc.Public {
+		if secret, err = randomCode(); err != nil {
+			return nil, "", err
+		}
+		c.SecretSalt = hashtools.Salt()
+		if c.SecretHash, err = hashtools.Hash(secret, c.SecretSalt); err != nil {
+			return nil, "", err
+		}
+	}
+	err = s.repo.SaveClient(c)
+	return
+}
+
+/*
+AuthenticateClient authenticates the OAuth client using
+the HTTP basic authentication or the `client_id` and
+`client_secret` form params. Public clients are identified
+only by their `client_id`.
+*/
+func (s *Service) AuthenticateClient(r *http.Request) (*user.OAuthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	if clientID == "" {
+		return nil, oauthError(oauthInvalidClient, "client authentication failed")
+	}
+	c := s.repo.FindClient(clientID)
+	if c == nil || !c.Active {
+		return nil, oauthError(oauthInvalidClient, "client authentication failed")
+	}
+	if c.Public {
+		return c, nil
+	}
+	h, err := hashtools.Hash(secret, c.SecretSalt)
+	if err != nil || secret == "" || subtle.ConstantTimeCompare(h, c.SecretHash) != 1 {
+		return nil, oauthError(oauthInvalidClient, "client authentication failed")
+	}
+	return c, nil
+}
+
+/*
+ValidateAuthorizeRequest validates the authorization request
+parameters, returning the client and the granted scopes. Errors
+returned before the redirect URI is validated must not be
+redirected to the client.
+*/
+func (s *Service) ValidateAuthorizeRequest(ar *AuthorizeRequest) (c *user.OAuthClient, scopes []string, redirectable bool, err error) {
+	c = s.repo.FindClient(ar.ClientID)
+	if c == nil || !c.Active {
+		return nil, nil, false, oauthError(oauthInvalidClient, "unknown client")
+	}
+	if ar.RedirectURI == "" {
+		// it's optional only if the client has a single redirect URI
+		uris := strings.Fields(c.RedirectURIs)
+		if len(uris) == 1 {
+			ar.RedirectURI = uris[0]
+		}
+	}
+	if !c.AllowsRedirectURI(ar.RedirectURI) {
+		return nil, nil, false, oauthError(oauthInvalidRequest, "invalid redirect_uri")
+	}
+	if ar.ResponseType != "code" {
+		return c, nil, true, oauthError(oauthUnsupportedResponse, "only 'code' response type is supported")
+	}
+	if !c.AllowsGrant(user.GrantAuthorizationCode) {
+		return c, nil, true, oauthError(oauthUnauthorizedClient, "client can't use authorization code")
+	}
+	if ar.CodeChallenge == "" && c.Public {
+		return c, nil, true, oauthError(oauthInvalidRequest, "public clients must use PKCE")
+	}
+	if ar.CodeChallenge != "" && ar.CodeChallengeMethod != "S256" {
+		return c, nil, true, oauthError(oauthInvalidRequest, "only 'S256' code challenge method is supported")
+	}
+	scopes, err = grantScopes(ar.Scope, c.ScopeList())
+	if err != nil {
+		return c, nil, true, err
+	}
+	return c, scopes, true, nil
+}
+
+/*
+NeedsConsent returns true if the user must consent the
+client access to the scopes
+*/
+func (s *Service) NeedsConsent(u *user.CredentialInfo, c *user.OAuthClient, scopes []string) bool {
+	if c.FirstParty {
+		return false
+	}
+	consent := s.repo.FindConsent(u.ID, c.ClientID)
+	return consent == nil || !consent.Covers(scopes)
+}
+
+/*
+SaveConsent stores the user consent for the client
+*/
+func (s *Service) SaveConsent(u *user.CredentialInfo, c *user.OAuthClient, scopes []string) error {
+	consent := s.repo.FindConsent(u.ID, c.ClientID)
+	if consent == nil {
+		consent = &user.OAuthConsent{
+			UserID:   u.ID,
+			ClientID: c.ClientID,
+		}
+	}
+	consent.Scope = strings.Join(mergeScopes(strings.Fields(consent.Scope), scopes), " ")
+	return s.repo.SaveConsent(consent)
+}
+
+/*
+IssueAuthorizationCode issues the authorization code for the
+validated authorization request, the scopes the user isn't
+allowed to (see AllowedScopes) are not granted
+*/
+func (s *Service) IssueAuthorizationCode(u *user.CredentialInfo, ar *AuthorizeRequest, scopes []string) (string, error) {
+	data, err := json.Marshal(&oauthGrant{
+		ClientID:      ar.ClientID,
+		RedirectURI:   ar.RedirectURI,
+		Scope:         strings.Join(s.userGrantScopes(u, scopes), " "),
+		CodeChallenge: ar.CodeChallenge,
+		Nonce:         ar.Nonce,
+		AuthTime:      s.now().Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	code, _, err := s.issueOneTimeToken(purposeOAuthCode, u.ID, durationOr(s.config().OAuthCodeTTL, defaultOAuthCodeTTL), string(data))
+	return code, err
+}
+
+/*
+ExchangeAuthorizationCode exchanges the authorization code
+for the access and refresh tokens (`authorization_code` grant)
+*/
+func (s *Service) ExchangeAuthorizationCode(c *user.OAuthClient, code string, redirectURI string, verifier string) (*TokenResponse, error) {
+	if !c.AllowsGrant(user.GrantAuthorizationCode) {
+		return nil, oauthError(oauthUnauthorizedClient, "client can't use authorization code")
+	}
+	t, err := s.useOneTimeToken(purposeOAuthCode, code)
+	if err != nil {
+		return nil, oauthError(oauthInvalidGrant, "invalid authorization code")
+	}
+	var g oauthGrant
+	if err := json.Unmarshal([]byte(t.Data), &g); err != nil {
+		return nil, err
+	}
+	if g.ClientID != c.ClientID || g.RedirectURI != redirectURI {
+		return nil, oauthError(oauthInvalidGrant, "authorization code was issued to another client")
+	}
+	if g.CodeChallenge != "" || verifier != "" {
+		if subtle.ConstantTimeCompare([]byte(pkceChallenge(verifier)), []byte(g.CodeChallenge)) != 1 {
+			return nil, oauthError(oauthInvalidGrant, "invalid code verifier")
+		}
+	}
+	u := s.repo.FindUserByID(t.UserID)
+	if u == nil || u.ID == 0 {
+		return nil, oauthError(oauthInvalidGrant, "user not found")
+	}
+	if !u.Active {
+		return nil, oauthError(oauthInvalidGrant, "user is inactive")
+	}
+	return s.issueOAuthTokens(u, c, &g)
+}
+
+/*
+RefreshOAuthToken exchanges the refresh token for new access
+and refresh tokens (`refresh_token` grant), refresh tokens are
+rotated so each one is accepted only once. The scopes the user
+is no longer allowed to are dropped.
+*/
+func (s *Service) RefreshOAuthToken(c *user.OAuthClient, refreshToken string, scope string) (*TokenResponse, error) {
+	if !c.AllowsGrant(user.GrantRefreshToken) {
+		return nil, oauthError(oauthUnauthorizedClient, "client can't use refresh tokens")
+	}
+	t, err := s.useOneTimeToken(purposeOAuthRefresh, refreshToken)
+	if err != nil {
+		return nil, oauthError(oauthInvalidGrant, "invalid refresh token")
+	}
+	var g oauthGrant
+	if err := json.Unmarshal([]byte(t.Data), &g); err != nil {
+		return nil, err
+	}
+	if g.ClientID != c.ClientID {
+		return nil, oauthError(oauthInvalidGrant, "refresh token was issued to another client")
+	}
+	if scope != "" {
+		// the new token can only narrow the original scopes
+		scopes, err := grantScopes(scope, strings.Fields(g.Scope))
+		if err != nil {
+			return nil, err
+		}
+		g.Scope = strings.Join(scopes, " ")
+	}
+	u := s.repo.FindUserByID(t.UserID)
+	if u == nil || u.ID == 0 {
+		return nil, oauthError(oauthInvalidGrant, "user not found")
+	}
+	if !u.Active {
+		return nil, oauthError(oauthInvalidGrant, "user is inactive")
+	}
+	g.Scope = strings.Join(s.userGrantScopes(u, strings.Fields(g.Scope)), " ")
+	return s.issueOAuthTokens(u, c, &g)
+}
+
+/*
+ClientCredentialsToken issues an access token to the client
+itself (`client_credentials` grant)
+*/
+func (s *Service) ClientCredentialsToken(c *user.OAuthClient, scope string) (*TokenResponse, error) {
+	if c.Public || !c.AllowsGrant(user.GrantClientCredentials) {
+		return nil, oauthError(oauthUnauthorizedClient, "client can't use client credentials")
+	}
+	scopes, err := grantScopes(scope, c.ScopeList())
+	if err != nil {
+		return nil, err
+	}
+	access, err := s.ToJWTWithClaims(user.CredentialInfo{
+		User: c.ClientID,
+		Name: c.Name,
+	}, map[string]string{
+		TokenDataSubjectType: SubjectTypeClient,
+		TokenDataClientID:    c.ClientID,
+		TokenDataScope:       strings.Join(scopes, " "),
+		TokenDataAudience:    clientAudience(c),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.config().tokenTTL().Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+func (s *Service) issueOAuthTokens(u *user.CredentialInfo, c *user.OAuthClient, g *oauthGrant) (*TokenResponse, error) {
+	access, err := s.ToJWTWithClaims(*u, map[string]string{
+		TokenDataClientID: c.ClientID,
+		TokenDataScope:    g.Scope,
+		TokenDataAudience: clientAudience(c),
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &TokenResponse{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.config().tokenTTL().Seconds()),
+		Scope:       g.Scope,
+	}
+	if containsScope(strings.Fields(g.Scope), ScopeOpenID) {
+		if res.IDToken, err = s.idToken(u, c, g, access); err != nil {
+			if !errors.Is(err, errOIDCNotConfigured) {
+				return nil, err
+			}
+			logger.Logger().Warn("OpenID Connect issuer not configured, ID token not issued")
+		}
+	}
+	if c.AllowsGrant(user.GrantRefreshToken) {
+		data, err := json.Marshal(&oauthGrant{
+			ClientID: g.ClientID,
+			Scope:    g.Scope,
+			Nonce:    g.Nonce,
+			AuthTime: g.AuthTime,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.RefreshToken, _, err = s.issueOneTimeToken(purposeOAuthRefresh, u.ID, durationOr(s.config().OAuthRefreshTTL, defaultOAuthRefreshTTL), string(data)); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+/*
+grantScopes returns the requested scopes the client is allowed
+to (an empty request means all the allowed scopes)
+*/
+func grantScopes(requested string, allowed []string) ([]string, error) {
+	if requested == "" {
+		return allowed, nil
+	}
+	scopes := make([]string, 0)
+	for _, s := range strings.Fields(requested) {
+		if !containsScope(allowed, s) {
+			return nil, oauthError(oauthInvalidScope, fmt.Sprintf("scope '%s' is not allowed", s))
+		}
+		scopes = append(scopes, s)
+	}
+	return scopes, nil
+}
+
+func mergeScopes(a []string, b []string) []string {
+	for _, s := range b {
+		if !containsScope(a, s) {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func clientAudience(c *user.OAuthClient) string {
+	if c.Audience != "" {
+		return c.Audience
+	}
+	return c.ClientID
+}
+
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func durationOr(d time.Duration, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
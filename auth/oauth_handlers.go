@@ -0,0 +1,486 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Authorize {{ .Client.Name }}</title></head>
+<body>
+<p><strong>{{ .Client.Name }}</strong> wants to access your account ({{ .User.User }}).</p>
+{{ if .Scopes }}<ul>{{ range .Scopes }}<li>{{ . }}</li>{{ end }}</ul>{{ end }}
+<form method="post">
+<input type="hidden" name="response_type" value="{{ .Request.ResponseType }}">
+<input type="hidden" name="client_id" value="{{ .Request.ClientID }}">
+<input type="hidden" name="redirect_uri" value="{{ .Request.RedirectURI }}">
+<input type="hidden" name="scope" value="{{ .Request.Scope }}">
+<input type="hidden" name="state" value="{{ .Request.State }}">
+<input type="hidden" name="code_challenge" value="{{ .Request.CodeChallenge }}">
+<input type="hidden" name="code_challenge_method" value="{{ .Request.CodeChallengeMethod }}">
+<input type="hidden" name="nonce" value="{{ .Request.Nonce }}">
+<input type="hidden" name="csrf_token" value="{{ .CSRF }}">
+<button type="submit" name="consent" value="approve">Allow</button>
+<button type="submit" name="consent" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+/*
+HandleAuthorize handles the OAuth authorization endpoint
+(authorization code flow with PKCE). The user must be logged
+in (usually by the session cookie, so the cookie SameSite mode
+must be 'lax' to accept requests coming from other sites),
+users not logged in are redirected to the `auth.oauth.login.url`.
+*/
+func (h *Handler) HandleAuthorize() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		ar := &AuthorizeRequest{
+			ResponseType:        r.FormValue("response_type"),
+			ClientID:            r.FormValue("client_id"),
+			RedirectURI:         r.FormValue("redirect_uri"),
+			Scope:               r.FormValue("scope"),
+			State:               r.FormValue("state"),
+			CodeChallenge:       r.FormValue("code_challenge"),
+			CodeChallengeMethod: r.FormValue("code_challenge_method"),
+			Nonce:               r.FormValue("nonce"),
+		}
+		c, scopes, redirectable, err := h.svc.ValidateAuthorizeRequest(ar)
+		if err != nil {
+			log.Println(err.Error())
+			if redirectable {
+				redirectAuthorize(rw, r, ar, url.Values{"error": {oauthErrorCode(err)}})
+			} else {
+				writeOAuthError(rw, http.StatusBadRequest, err)
+			}
+			return
+		}
+
+		u, err := h.svc.authenticate(r, WithoutAPIKeys())
+		if err != nil {
+			log.Println(err.Error())
+			if loginURL := h.svc.config().OAuthLoginURL; loginURL != "" && r.Method == http.MethodGet {
+				http.Redirect(rw, r, returnTo(loginURL, r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// the user can only grant the scopes it's allowed to
+		scopes = h.svc.userGrantScopes(u, scopes)
+
+		if r.Method == http.MethodPost {
+			if r.PostFormValue("consent") != "approve" {
+				redirectAuthorize(rw, r, ar, url.Values{"error": {oauthAccessDenied}})
+				return
+			}
+			if err := h.svc.SaveConsent(u, c, scopes); err != nil {
+				log.Println(err.Error())
+				redirectAuthorize(rw, r, ar, url.Values{"error": {oauthServerError}})
+				return
+			}
+		} else if h.svc.NeedsConsent(u, c, scopes) {
+			h.renderConsent(rw, r, u, c, scopes, ar)
+			return
+		}
+
+		code, err := h.svc.IssueAuthorizationCode(u, ar, scopes)
+		if err != nil {
+			log.Println(err.Error())
+			redirectAuthorize(rw, r, ar, url.Values{"error": {oauthServerError}})
+			return
+		}
+		redirectAuthorize(rw, r, ar, url.Values{"code": {code}})
+	}
+}
+
+/*
+HandleToken handles the OAuth token endpoint
+(`authorization_code`, `refresh_token` and
+`client_credentials` grants)
+*/
+func (h *Handler) HandleToken() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Header().Set("Pragma", "no-cache")
+
+		c, err := h.svc.AuthenticateClient(r)
+		if err != nil {
+			log.Println(err.Error())
+			rw.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			writeOAuthError(rw, http.StatusUnauthorized, err)
+			return
+		}
+
+		var res *TokenResponse
+		switch r.PostFormValue("grant_type") {
+		case user.GrantAuthorizationCode:
+			res, err = h.svc.ExchangeAuthorizationCode(c, r.PostFormValue("code"), r.PostFormValue("redirect_uri"), r.PostFormValue("code_verifier"))
+		case user.GrantRefreshToken:
+			res, err = h.svc.RefreshOAuthToken(c, r.PostFormValue("refresh_token"), r.PostFormValue("scope"))
+		case user.GrantClientCredentials:
+			res, err = h.svc.ClientCredentialsToken(c, r.PostFormValue("scope"))
+		default:
+			err = oauthError(oauthUnsupportedGrantType, "")
+		}
+		if err != nil {
+			log.Println(err.Error())
+			writeOAuthError(rw, http.StatusBadRequest, err)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(res)
+	}
+}
+
+/*
+HandleIntrospect handles the token introspection endpoint
+(RFC 7662), only confidential clients are allowed
+*/
+func (h *Handler) HandleIntrospect() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Cache-Control", "no-store")
+		c, err := h.svc.AuthenticateClient(r)
+		if err == nil && c.Public {
+			err = oauthError(oauthInvalidClient, "public clients can't introspect tokens")
+		}
+		if err != nil {
+			log.Println(err.Error())
+			rw.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			writeOAuthError(rw, http.StatusUnauthorized, err)
+			return
+		}
+		token := r.PostFormValue("token")
+		if token == "" {
+			writeOAuthError(rw, http.StatusBadRequest, oauthError(oauthInvalidRequest, "token must not be empty"))
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(h.svc.IntrospectToken(token))
+	}
+}
+
+/*
+HandleRevoke handles the token revocation endpoint (RFC 7009),
+it accepts access and refresh tokens (`token_type_hint` param)
+*/
+func (h *Handler) HandleRevoke() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		c, err := h.svc.AuthenticateClient(r)
+		if err != nil {
+			log.Println(err.Error())
+			rw.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			writeOAuthError(rw, http.StatusUnauthorized, err)
+			return
+		}
+		token := r.PostFormValue("token")
+		if token == "" {
+			writeOAuthError(rw, http.StatusBadRequest, oauthError(oauthInvalidRequest, "token must not be empty"))
+			return
+		}
+		if err := h.svc.RevokeToken(c, token, r.PostFormValue("token_type_hint")); err != nil {
+			log.Println(err.Error())
+			writeOAuthError(rw, http.StatusServiceUnavailable, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+/*
+HandleClients handles the OAuth clients registration, GET
+requests list the clients and POST requests register a new
+client (only admins are allowed)
+*/
+func (h *Handler) HandleClients() http.HandlerFunc {
+	clients := h.AuthInterceptor(func(rw http.ResponseWriter, r *http.Request) {
+		if !h.svc.GetCurrentUser(r).Admin {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			rw.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(rw).Encode(h.svc.GetRepository().ListClients())
+			return
+		}
+		var nc NewClient
+		if err := json.NewDecoder(r.Body).Decode(&nc); err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		c, secret, err := h.svc.RegisterClient(&nc)
+		if err != nil {
+			log.Println(err.Error())
+			writeOAuthError(rw, http.StatusUnprocessableEntity, err)
+			return
+		}
+		rw.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(rw).Encode(&map[string]interface{}{
+			"client":       c,
+			"clientSecret": secret,
+		})
+	}, WithoutAPIKeys())
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		clients.ServeHTTP(rw, r)
+	}
+}
+
+/*
+HandleServiceToken issues access tokens to service accounts
+(`client_credentials` grant), authenticated by TLS client
+certificate or client secret. Tokens requested over mutual
+TLS are bound to the client certificate (RFC 8705).
+*/
+func (h *Handler) HandleServiceToken() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Header().Set("Pragma", "no-cache")
+
+		a, cert, err := h.svc.AuthenticateServiceAccount(r)
+		if err != nil {
+			log.Println(err.Error())
+			rw.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			writeOAuthError(rw, http.StatusUnauthorized, err)
+			return
+		}
+		if r.PostFormValue("grant_type") != user.GrantClientCredentials {
+			writeOAuthError(rw, http.StatusBadRequest, oauthError(oauthUnsupportedGrantType, ""))
+			return
+		}
+		res, err := h.svc.ServiceAccountToken(a, cert, r.PostFormValue("scope"))
+		if err != nil {
+			log.Println(err.Error())
+			writeOAuthError(rw, http.StatusBadRequest, err)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(res)
+	}
+}
+
+/*
+HandleServiceAccounts handles the service accounts, GET requests
+list the accounts and POST requests create a new account (only
+admins are allowed)
+*/
+func (h *Handler) HandleServiceAccounts() http.HandlerFunc {
+	accounts := h.AuthInterceptor(func(rw http.ResponseWriter, r *http.Request) {
+		if !h.svc.GetCurrentUser(r).Admin {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			rw.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(rw).Encode(h.svc.GetRepository().ListServiceAccounts())
+			return
+		}
+		var na NewServiceAccount
+		if err := json.NewDecoder(r.Body).Decode(&na); err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		a, secret, err := h.svc.CreateServiceAccount(&na)
+		if err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		rw.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(rw).Encode(&map[string]interface{}{
+			"serviceAccount": a,
+			"clientSecret":   secret,
+		})
+	}, WithoutAPIKeys())
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		accounts.ServeHTTP(rw, r)
+	}
+}
+
+/*
+HandleOpenIDConfiguration handles the OpenID Connect discovery
+document (`/.well-known/openid-configuration`), it's available
+only if the issuer (`auth.jwt.issuer`) is configured
+*/
+func (h *Handler) HandleOpenIDConfiguration() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		doc, err := h.svc.OpenIDConfiguration()
+		if err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(doc)
+	}
+}
+
+/*
+HandleJWKS handles the JSON Web Key Set with the public
+keys used to verify the tokens
+*/
+func (h *Handler) HandleJWKS() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		jwks, err := h.svc.PublicKeys()
+		if err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(jwks)
+	}
+}
+
+/*
+HandleUserInfo handles the OpenID Connect userinfo endpoint,
+the access token must be granted the `openid` scope and the
+claims returned are filtered by the token scopes
+*/
+func (h *Handler) HandleUserInfo() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		u, tokenData, err := h.svc.authenticateToken(r)
+		if err != nil {
+			log.Println(err.Error())
+			rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		scopes := strings.Fields(tokenData[TokenDataScope])
+		if !containsScope(scopes, ScopeOpenID) {
+			rw.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(UserInfo(u, scopes))
+	}
+}
+
+func (h *Handler) renderConsent(rw http.ResponseWriter, r *http.Request, u *user.CredentialInfo, c *user.OAuthClient, scopes []string, ar *AuthorizeRequest) {
+	var csrf string
+	cfg := h.svc.config()
+	if _, err := r.Cookie(cfg.cookieName()); err == nil && cfg.CookieEnabled {
+		if csrf, err = h.svc.IssueCSRFToken(rw, r); err != nil {
+			log.Println(err.Error())
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("X-Frame-Options", "DENY")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusOK)
+	_ = consentTemplate.Execute(rw, map[string]interface{}{
+		"User":    u.View(),
+		"Client":  c,
+		"Scopes":  scopes,
+		"Request": ar,
+		"CSRF":    csrf,
+	})
+}
+
+func redirectAuthorize(rw http.ResponseWriter, r *http.Request, ar *AuthorizeRequest, params url.Values) {
+	u, err := url.Parse(ar.RedirectURI)
+	if err != nil {
+		writeOAuthError(rw, http.StatusBadRequest, oauthError(oauthInvalidRequest, "invalid redirect_uri"))
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if ar.State != "" {
+		q.Set("state", ar.State)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(rw, r, u.String(), http.StatusFound)
+}
+
+func returnTo(loginURL string, uri string) string {
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set("return_to", uri)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func writeOAuthError(rw http.ResponseWriter, status int, err error) {
+	var oe *OAuthError
+	if !errors.As(err, &oe) {
+		oe = oauthError(oauthServerError, "")
+		status = http.StatusInternalServerError
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(oe)
+}
+
+func oauthErrorCode(err error) string {
+	var oe *OAuthError
+	if errors.As(err, &oe) {
+		return oe.Code
+	}
+	return oauthServerError
+}
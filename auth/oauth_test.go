@@ -0,0 +1,422 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+type oauthTestSetup struct {
+	svc    *Service
+	server *httptest.Server
+	client *user.OAuthClient
+	secret string
+	token  string
+}
+
+func setupOAuth(t *testing.T, nc *NewClient) *oauthTestSetup {
+	svc := newTestService(t)
+	h := NewHandlerCustom(svc)
+	if err := svc.GetRepository().SaveProfile(&user.Profile{Name: "oauth", Active: true, Scopes: "read write"}); err != nil {
+		t.Errorf("Failed to create profile: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc.CreateNewUser(&NewUser{User: "oauth.user", Pass: "pass", Name: "oauth.user", Active: true, Admin: true, Roles: []string{"oauth"}}); err != nil {
+		t.Errorf("Failed to create test user: %v", err)
+		t.FailNow()
+	}
+
+	c, secret, err := svc.RegisterClient(nc)
+	if err != nil {
+		t.Errorf("Failed to register client: %s", err.Error())
+		t.FailNow()
+	}
+	token, err := svc.ToJWT(*svc.GetRepository().FindUser("oauth.user"))
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/authorize", h.HandleAuthorize())
+	mux.Handle("/token", h.HandleToken())
+	mux.Handle("/introspect", h.HandleIntrospect())
+	mux.Handle("/revoke", h.HandleRevoke())
+	mux.Handle("/protected", h.AuthInterceptor(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return &oauthTestSetup{svc: svc, server: s, client: c, secret: secret, token: token}
+}
+
+func (o *oauthTestSetup) authorize(t *testing.T, method string, params url.Values) *http.Response {
+	var req *http.Request
+	if method == http.MethodGet {
+		req, _ = http.NewRequest(method, o.server.URL+"/authorize?"+params.Encode(), nil)
+	} else {
+		req, _ = http.NewRequest(method, o.server.URL+"/authorize", strings.NewReader(params.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", o.token))
+	res, err := noRedirectClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	return res
+}
+
+func (o *oauthTestSetup) tokenRequest(t *testing.T, params url.Values) (int, map[string]interface{}) {
+	return o.clientRequest(t, "/token", params)
+}
+
+/*
+clientRequest sends a form post authenticated
+with the client credentials
+*/
+func (o *oauthTestSetup) clientRequest(t *testing.T, path string, params url.Values) (int, map[string]interface{}) {
+	req, _ := http.NewRequest(http.MethodPost, o.server.URL+path, strings.NewReader(params.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	if o.secret != "" {
+		req.SetBasicAuth(o.client.ClientID, o.secret)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	var body map[string]interface{}
+	_ = json.NewDecoder(res.Body).Decode(&body)
+	return res.StatusCode, body
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	o := setupOAuth(t, &NewClient{
+		Name:         "Third party app",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{"read", "write"},
+	})
+
+	verifier := "a-very-long-and-random-code-verifier-0123456789"
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.client.ClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	res := o.authorize(t, http.MethodGet, params)
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("Should ask for consent, but was '%s'", res.Status)
+	}
+
+	params.Set("consent", "approve")
+	res = o.authorize(t, http.MethodPost, params)
+	if res.StatusCode != http.StatusFound {
+		t.Errorf("Should redirect to the client, but was '%s'", res.Status)
+		t.FailNow()
+	}
+	location, _ := url.Parse(res.Header.Get("Location"))
+	if location.Query().Get("state") != "xyz" || location.Query().Get("code") == "" {
+		t.Errorf("Should redirect with code and state, but was '%s'", location)
+		t.FailNow()
+	}
+	code := location.Query().Get("code")
+
+	// consent is remembered
+	params.Del("consent")
+	if res := o.authorize(t, http.MethodGet, params); res.StatusCode != http.StatusFound {
+		t.Errorf("Should not ask for consent again, but was '%s'", res.Status)
+	}
+
+	status, body := o.tokenRequest(t, url.Values{
+		"grant_type":    {user.GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {"wrong-verifier"},
+	})
+	if status != http.StatusBadRequest || body["error"] != oauthInvalidGrant {
+		t.Errorf("Should reject invalid code verifier, but was '%d' '%v'", status, body)
+	}
+
+	// the code was consumed by the failed attempt, so get a new one
+	res = o.authorize(t, http.MethodGet, params)
+	location, _ = url.Parse(res.Header.Get("Location"))
+	code = location.Query().Get("code")
+
+	status, body = o.tokenRequest(t, url.Values{
+		"grant_type":    {user.GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {verifier},
+	})
+	if status != http.StatusOK {
+		t.Errorf("Should exchange the code, but was '%d' '%v'", status, body)
+		t.FailNow()
+	}
+	if body["scope"] != "read" || body["token_type"] != "Bearer" || body["refresh_token"] == "" {
+		t.Errorf("Invalid token response '%v'", body)
+	}
+	data, err := o.svc.FromJWT(body["access_token"].(string))
+	if err != nil {
+		t.Errorf("Failed to parse access token: %s", err.Error())
+	}
+	if data[TokenDataClientID] != o.client.ClientID || data[TokenDataScope] != "read" || data[TokenDataAudience] != o.client.ClientID {
+		t.Errorf("Invalid access token claims '%v'", data)
+	}
+
+	refresh := body["refresh_token"].(string)
+	status, body = o.tokenRequest(t, url.Values{
+		"grant_type":    {user.GrantRefreshToken},
+		"refresh_token": {refresh},
+	})
+	if status != http.StatusOK || body["refresh_token"] == refresh {
+		t.Errorf("Should rotate the refresh token, but was '%d' '%v'", status, body)
+	}
+	status, _ = o.tokenRequest(t, url.Values{
+		"grant_type":    {user.GrantRefreshToken},
+		"refresh_token": {refresh},
+	})
+	if status != http.StatusBadRequest {
+		t.Errorf("Should not accept the same refresh token twice, but was '%d'", status)
+	}
+}
+
+func TestOAuthRejectsAPIKeys(t *testing.T) {
+	o := setupOAuth(t, &NewClient{
+		Name:         "Third party app",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{"read", "write"},
+		FirstParty:   true,
+	})
+	_, key, err := o.svc.CreateAPIKey(o.svc.GetRepository().FindUser("oauth.user"), &NewAPIKey{Name: "narrow", Scopes: []string{"read"}})
+	if err != nil {
+		t.Errorf("Failed to create API key: %s", err.Error())
+		t.FailNow()
+	}
+	jwt := o.token
+	o.token = key
+	res := o.authorize(t, http.MethodGet, url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.client.ClientID},
+		"scope":         {"read write"},
+	})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("API keys must not authorize clients, but was '%s' (%s)", res.Status, res.Header.Get("Location"))
+	}
+
+	h := NewHandlerCustom(o.svc)
+	for name, handler := range map[string]http.Handler{
+		"clients":          h.HandleClients(),
+		"service accounts": h.HandleServiceAccounts(),
+	} {
+		for _, test := range []struct {
+			header string
+			token  string
+			status int
+		}{
+			{APIKeyHeader, key, http.StatusForbidden},
+			{"Authorization", "Bearer " + jwt, http.StatusOK},
+		} {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(test.header, test.token)
+			handler.ServeHTTP(rw, req)
+			if rw.Code != test.status {
+				t.Errorf("%s: should return '%d' to %s, but was '%d'", name, test.status, test.header, rw.Code)
+			}
+		}
+	}
+}
+
+func TestOAuthGrantsOnlyUserScopes(t *testing.T) {
+	o := setupOAuth(t, &NewClient{
+		Name:         "Admin console",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{"read", "admin"},
+		FirstParty:   true,
+	})
+	if err := o.svc.GetRepository().SaveProfile(&user.Profile{Name: "reader", Active: true, Scopes: "read"}); err != nil {
+		t.Errorf("Failed to create profile: %s", err.Error())
+		t.FailNow()
+	}
+	u, err := o.svc.CreateNewUser(&NewUser{User: "oauth.reader", Pass: "pass", Active: true, Roles: []string{"reader"}})
+	if err != nil {
+		t.Errorf("Failed to create test user: %v", err)
+		t.FailNow()
+	}
+	if o.token, err = o.svc.ToJWT(*u); err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+
+	res := o.authorize(t, http.MethodGet, url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.client.ClientID},
+		"scope":         {"read admin"},
+	})
+	location, _ := url.Parse(res.Header.Get("Location"))
+	status, body := o.tokenRequest(t, url.Values{
+		"grant_type":   {user.GrantAuthorizationCode},
+		"code":         {location.Query().Get("code")},
+		"redirect_uri": {testRedirectURI},
+	})
+	if status != http.StatusOK || body["scope"] != "read" {
+		t.Errorf("Should grant only the user scopes, but was '%d' '%v'", status, body)
+		t.FailNow()
+	}
+
+	status, body = o.tokenRequest(t, url.Values{
+		"grant_type":    {user.GrantRefreshToken},
+		"refresh_token": {body["refresh_token"].(string)},
+	})
+	data, _ := o.svc.FromJWT(fmt.Sprint(body["access_token"]))
+	if status != http.StatusOK || body["scope"] != "read" || data[TokenDataScope] != "read" {
+		t.Errorf("Should refresh only the user scopes, but was '%d' '%v'", status, body)
+	}
+}
+
+func TestOAuthInactiveUser(t *testing.T) {
+	o := setupOAuth(t, &NewClient{
+		Name:         "Third party app",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{"read"},
+		FirstParty:   true,
+	})
+	code := func() string {
+		res := o.authorize(t, http.MethodGet, url.Values{
+			"response_type": {"code"},
+			"client_id":     {o.client.ClientID},
+		})
+		location, _ := url.Parse(res.Header.Get("Location"))
+		return location.Query().Get("code")
+	}
+
+	_, body := o.tokenRequest(t, url.Values{
+		"grant_type":   {user.GrantAuthorizationCode},
+		"code":         {code()},
+		"redirect_uri": {testRedirectURI},
+	})
+	refresh, _ := body["refresh_token"].(string)
+	pending := code()
+	disableUser(t, o.svc, "oauth.user")
+
+	status, body := o.tokenRequest(t, url.Values{
+		"grant_type":   {user.GrantAuthorizationCode},
+		"code":         {pending},
+		"redirect_uri": {testRedirectURI},
+	})
+	if status != http.StatusBadRequest || body["error"] != oauthInvalidGrant {
+		t.Errorf("Should not exchange codes of inactive users, but was '%d' '%v'", status, body)
+	}
+	status, body = o.tokenRequest(t, url.Values{
+		"grant_type":    {user.GrantRefreshToken},
+		"refresh_token": {refresh},
+	})
+	if status != http.StatusBadRequest || body["error"] != oauthInvalidGrant {
+		t.Errorf("Should not refresh tokens of inactive users, but was '%d' '%v'", status, body)
+	}
+}
+
+func TestOAuthAuthorizeInvalidRedirectURI(t *testing.T) {
+	o := setupOAuth(t, &NewClient{
+		Name:         "Third party app",
+		RedirectURIs: []string{testRedirectURI},
+		FirstParty:   true,
+	})
+
+	res := o.authorize(t, http.MethodGet, url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.client.ClientID},
+		"redirect_uri":  {testRedirectURI + "/evil"},
+	})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Should not redirect to unknown URIs, but was '%s'", res.Status)
+	}
+}
+
+func TestOAuthPublicClientRequiresPKCE(t *testing.T) {
+	o := setupOAuth(t, &NewClient{
+		Name:         "SPA",
+		RedirectURIs: []string{testRedirectURI},
+		Public:       true,
+		FirstParty:   true,
+	})
+
+	res := o.authorize(t, http.MethodGet, url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.client.ClientID},
+	})
+	location, _ := url.Parse(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusFound || location.Query().Get("error") != oauthInvalidRequest {
+		t.Errorf("Should require PKCE for public clients, but was '%s' '%s'", res.Status, location)
+	}
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	o := setupOAuth(t, &NewClient{
+		Name:       "Backend",
+		Scopes:     []string{"read"},
+		GrantTypes: []string{user.GrantClientCredentials},
+		Audience:   "billing-api",
+	})
+
+	status, body := o.tokenRequest(t, url.Values{
+		"grant_type": {user.GrantClientCredentials},
+		"scope":      {"write"},
+	})
+	if status != http.StatusBadRequest || body["error"] != oauthInvalidScope {
+		t.Errorf("Should reject not allowed scopes, but was '%d' '%v'", status, body)
+	}
+
+	status, body = o.tokenRequest(t, url.Values{
+		"grant_type": {user.GrantClientCredentials},
+	})
+	if status != http.StatusOK || body["refresh_token"] != nil {
+		t.Errorf("Should issue only the access token, but was '%d' '%v'", status, body)
+		t.FailNow()
+	}
+	data, _ := o.svc.FromJWT(body["access_token"].(string))
+	if data[TokenDataAudience] != "billing-api" || data[TokenDataSubjectType] != SubjectTypeClient {
+		t.Errorf("Invalid access token claims '%v'", data)
+	}
+
+	// client tokens must not be accepted as user tokens
+	req, _ := http.NewRequest(http.MethodGet, o.server.URL+"/protected", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", body["access_token"]))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should return 403 (Forbidden), but was '%s'", res.Status)
+	}
+
+	o.secret = "wrong-secret"
+	status, _ = o.tokenRequest(t, url.Values{
+		"grant_type": {user.GrantClientCredentials},
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("Should reject invalid client secret, but was '%d'", status)
+	}
+}
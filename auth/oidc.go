@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+// OpenID Connect scopes
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var errOIDCNotConfigured = errors.New("auth.oidc.issuer.not.configured")
+
+/*
+OpenIDConfiguration returns the OpenID Connect discovery
+document (`/.well-known/openid-configuration`)
+*/
+func (s *Service) OpenIDConfiguration() (map[string]interface{}, error) {
+	cfg := s.config()
+	issuer := cfg.Issuer
+	if issuer == "" {
+		return nil, errOIDCNotConfigured
+	}
+	return map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                cfg.oidcEndpointURL(issuer, "authorize"),
+		"token_endpoint":                        cfg.oidcEndpointURL(issuer, "token"),
+		"userinfo_endpoint":                     cfg.oidcEndpointURL(issuer, "userinfo"),
+		"jwks_uri":                              cfg.oidcEndpointURL(issuer, "jwks"),
+		"introspection_endpoint":                cfg.oidcEndpointURL(issuer, "introspect"),
+		"revocation_endpoint":                   cfg.oidcEndpointURL(issuer, "revoke"),
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{user.GrantAuthorizationCode, user.GrantRefreshToken, user.GrantClientCredentials},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jose.RS256},
+		"scopes_supported":                      []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "at_hash",
+			"name", "preferred_username", "email", "email_verified",
+		},
+	}, nil
+}
+
+/*
+UserInfo returns the user claims the scopes allow
+(`sub` is always returned)
+*/
+func UserInfo(u *user.CredentialInfo, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": u.User,
+	}
+	if containsScope(scopes, ScopeProfile) {
+		claims["name"] = u.Name
+		claims["preferred_username"] = u.User
+	}
+	if containsScope(scopes, ScopeEmail) && u.Email != nil {
+		claims["email"] = u.GetEmail()
+		claims["email_verified"] = u.IsEmailVerified()
+	}
+	return claims
+}
+
+/*
+idToken issues the OpenID Connect ID token, it's always
+signed with the RSA key (clients verify it using the JWKS)
+*/
+func (s *Service) idToken(u *user.CredentialInfo, c *user.OAuthClient, g *oauthGrant, accessToken string) (string, error) {
+	issuer := s.config().Issuer
+	if issuer == "" {
+		return "", errOIDCNotConfigured
+	}
+	key, err := s.rsaKey()
+	if err != nil {
+		return "", err
+	}
+	now := s.now()
+	claims := UserInfo(u, strings.Fields(g.Scope))
+	claims["iss"] = issuer
+	claims["aud"] = c.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(s.config().tokenTTL()).Unix()
+	claims["at_hash"] = atHash(accessToken)
+	if g.AuthTime > 0 {
+		claims["auth_time"] = g.AuthTime
+	}
+	if g.Nonce != "" {
+		claims["nonce"] = g.Nonce
+	}
+	return jose.Sign(claims, key)
+}
+
+/*
+atHash is the access token hash (left-most half of the
+SHA-256 hash, base64url encoded)
+*/
+func atHash(accessToken string) string {
+	h := sha256.Sum256([]byte(accessToken))
+	return jose.Encode(h[:len(h)/2])
+}
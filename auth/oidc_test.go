@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/user"
+	"github.com/spf13/viper"
+)
+
+const testIssuer = "https://auth.example.com"
+
+func oidcCode(t *testing.T, o *oauthTestSetup, scope string, nonce string) string {
+	res := o.authorize(t, http.MethodGet, url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.client.ClientID},
+		"scope":         {scope},
+		"nonce":         {nonce},
+	})
+	location, _ := url.Parse(res.Header.Get("Location"))
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Errorf("Failed to get authorization code: '%s' '%s'", res.Status, location)
+		t.FailNow()
+	}
+	return code
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	viper.Set("auth.jwt.issuer", testIssuer)
+	defer viper.Set("auth.jwt.issuer", "")
+
+	o := setupOAuth(t, &NewClient{
+		Name:         "Grafana",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		FirstParty:   true,
+	})
+	u := o.svc.GetRepository().FindUser("oauth.user")
+	_ = u.SetEmail("oauth.user@example.com")
+	_ = o.svc.GetRepository().SaveUser(u)
+
+	status, body := o.tokenRequest(t, url.Values{
+		"grant_type":   {user.GrantAuthorizationCode},
+		"code":         {oidcCode(t, o, "openid profile", "n-0S6_WzA2Mj")},
+		"redirect_uri": {testRedirectURI},
+	})
+	if status != http.StatusOK || body["id_token"] == nil {
+		t.Errorf("Should issue the ID token, but was '%d' '%v'", status, body)
+		t.FailNow()
+	}
+
+	jwks, err := o.svc.PublicKeys()
+	if err != nil {
+		t.Errorf("Failed to get public keys: %s", err.Error())
+		t.FailNow()
+	}
+	idToken, err := jose.Parse(body["id_token"].(string))
+	if err != nil {
+		t.Errorf("Failed to parse ID token: %s", err.Error())
+		t.FailNow()
+	}
+	key, err := jwks.Find(idToken.Header.Kid)
+	if err != nil || idToken.Verify(key) != nil {
+		t.Errorf("Failed to verify ID token using the JWKS: %v", err)
+	}
+	var claims map[string]interface{}
+	_ = idToken.Claims(&claims)
+	if claims["iss"] != testIssuer || claims["aud"] != o.client.ClientID || claims["sub"] != "oauth.user" {
+		t.Errorf("Invalid ID token claims '%v'", claims)
+	}
+	if claims["nonce"] != "n-0S6_WzA2Mj" || claims["at_hash"] != atHash(body["access_token"].(string)) || claims["auth_time"] == nil {
+		t.Errorf("Invalid ID token claims '%v'", claims)
+	}
+	if claims["preferred_username"] != "oauth.user" || claims["email"] != nil {
+		t.Errorf("ID token claims must be filtered by scope '%v'", claims)
+	}
+
+	h := NewHandlerCustom(o.svc)
+	s := httptest.NewServer(h.HandleUserInfo())
+	defer s.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", body["access_token"]))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	var info map[string]interface{}
+	_ = json.NewDecoder(res.Body).Decode(&info)
+	if res.StatusCode != http.StatusOK || info["name"] != "oauth.user" || info["email"] != nil {
+		t.Errorf("Invalid userinfo response '%s' '%v'", res.Status, info)
+	}
+
+	// tokens without the openid scope can't access userinfo
+	req, _ = http.NewRequest(http.MethodGet, s.URL, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", o.token))
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should return 403 (Forbidden), but was '%s'", res.Status)
+	}
+}
+
+func TestOIDCUserInfoEmailScope(t *testing.T) {
+	email := "someone@example.com"
+	u := &user.CredentialInfo{User: "someone", Name: "Someone", Email: &email}
+
+	claims := UserInfo(u, []string{ScopeOpenID, ScopeEmail})
+	if claims["email"] != email || claims["email_verified"] != false || claims["name"] != nil {
+		t.Errorf("Invalid claims '%v'", claims)
+	}
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	h := NewHandlerCustom(newTestService(t))
+	s := httptest.NewServer(h.HandleOpenIDConfiguration())
+	defer s.Close()
+
+	res, err := http.Get(s.URL)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Should return 404 (Not Found) without issuer, but was '%s'", res.Status)
+	}
+
+	viper.Set("auth.jwt.issuer", testIssuer)
+	defer viper.Set("auth.jwt.issuer", "")
+	if err := h.svc.SetConfig(LoadConfig()); err != nil {
+		t.Errorf("Failed to reload the config: %s", err.Error())
+		t.FailNow()
+	}
+
+	res, err = http.Get(s.URL)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	var doc map[string]interface{}
+	_ = json.NewDecoder(res.Body).Decode(&doc)
+	if doc["issuer"] != testIssuer || doc["jwks_uri"] != testIssuer+"/.well-known/jwks.json" {
+		t.Errorf("Invalid discovery document '%v'", doc)
+	}
+}
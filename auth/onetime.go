@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+var errInvalidOneTimeToken = errors.New("auth.token.invalid")
+
+/*
+issueOneTimeToken creates a signed single use token, the
+returned string is the only place the token is available
+(just its hash is stored)
+*/
+func (s *Service) issueOneTimeToken(purpose string, userID int, ttl time.Duration, data string) (string, *user.OneTimeToken, error) {
+	code, err := randomCode()
+	if err != nil {
+		return "", nil, err
+	}
+	t := &user.OneTimeToken{
+		Purpose:   purpose,
+		Hash:      hashCode(code),
+		UserID:    userID,
+		Data:      data,
+		ExpiresAt: s.now().Add(ttl),
+	}
+	if err := s.repo.SaveOneTimeToken(t); err != nil {
+		return "", nil, err
+	}
+	sig, err := s.hmacSign(oneTimeTokenContent(purpose, code))
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%s.%s", code, sig), t, nil
+}
+
+/*
+useOneTimeToken validates the token signature and marks
+it as used (tokens are accepted only once)
+*/
+func (s *Service) useOneTimeToken(purpose string, token string) (*user.OneTimeToken, error) {
+	t, err := s.findOneTimeToken(purpose, token)
+	if err != nil {
+		return nil, err
+	}
+	if !s.repo.UseOneTimeToken(t, s.now()) {
+		return nil, errInvalidOneTimeToken
+	}
+	return t, nil
+}
+
+/*
+findOneTimeToken validates the token signature and returns
+it if it's still valid (without marking it as used)
+*/
+func (s *Service) findOneTimeToken(purpose string, token string) (*user.OneTimeToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errInvalidOneTimeToken
+	}
+	if !s.hmacVerify(oneTimeTokenContent(purpose, parts[0]), parts[1]) {
+		return nil, errInvalidOneTimeToken
+	}
+	t := s.repo.FindOneTimeToken(hashCode(parts[0]))
+	now := s.now()
+	if t == nil || t.Purpose != purpose || !t.IsValid(now) {
+		return nil, errInvalidOneTimeToken
+	}
+	return t, nil
+}
+
+func oneTimeTokenContent(purpose string, code string) string {
+	return fmt.Sprintf("%s.%s", purpose, code)
+}
+
+/*
+tokenLink appends the token to the base URL (it returns
+an empty string if there is no base URL)
+*/
+func tokenLink(base string, token string) string {
+	if base == "" {
+		return ""
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/eldius/jwt-auth-go/logger"
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+// Registration modes (`auth.user.registration.mode` config key)
+const (
+	RegistrationOpen       = "open"
+	RegistrationInviteOnly = "invite-only"
+	RegistrationAdminOnly  = "admin-only"
+	RegistrationDisabled   = "disabled"
+)
+
+const (
+	profileNotFound = "auth.user.profile.not.found"
+	adminExists     = "auth.user.bootstrap.admin.exists"
+)
+
+var (
+	errRegistrationDisabled  = errors.New("auth.user.registration.disabled")
+	errRegistrationForbidden = errors.New("auth.user.registration.forbidden")
+	errInvalidInvitation     = errors.New("auth.user.invitation.invalid")
+)
+
+/*
+RegisterUser creates a new user applying the registration
+mode policy. The requester is the authenticated user
+doing the registration (nil for anonymous requests) and
+only admins can create admins or assign roles (the
+registration is forbidden otherwise).
+*/
+func (s *Service) RegisterUser(u *NewUser, requester *user.CredentialInfo, invitation string) (*user.CredentialInfo, error) {
+	admin := isAdmin(requester)
+	if !admin && (u.Admin || len(u.Roles) > 0) {
+		return nil, fmt.Errorf("%w: only admins can create admins or assign roles", errRegistrationForbidden)
+	}
+
+	if s.config().EmailVerificationRequired && u.Email == "" {
+		return nil, errEmailRequired
+	}
+
+	var inv *user.Invitation
+	switch s.config().RegistrationMode {
+	case RegistrationDisabled:
+		return nil, errRegistrationDisabled
+	case RegistrationAdminOnly:
+		if !admin {
+			return nil, errRegistrationForbidden
+		}
+	case RegistrationInviteOnly:
+		if !admin {
+			var err error
+			if inv, err = s.useInvitation(invitation); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	c, err := s.CreateNewUser(u)
+	if err != nil {
+		if inv != nil {
+			s.repo.ReleaseInvitation(inv)
+		}
+		return nil, err
+	}
+	if inv != nil {
+		inv.UsedBy = c.ID
+		if err := s.repo.SaveInvitation(inv); err != nil {
+			logger.Logger().WithError(err).Warn("Failed to save invitation usage")
+		}
+	}
+	if c.Email != nil {
+		if err := s.SendEmailVerification(c); err != nil {
+			logger.Logger().WithError(err).Warn("Failed to send e-mail verification")
+		}
+	}
+	return c, nil
+}
+
+/*
+CreateInvitation creates a new invitation code (the code
+is returned only here, just its hash is stored), only
+active admins can create invitations
+*/
+func (s *Service) CreateInvitation(createdBy *user.CredentialInfo) (code string, inv *user.Invitation, err error) {
+	if !isAdmin(createdBy) {
+		return "", nil, errRegistrationForbidden
+	}
+	code, err = randomCode()
+	if err != nil {
+		return
+	}
+	inv = &user.Invitation{
+		Code:      hashCode(code),
+		CreatedBy: createdBy.ID,
+	}
+	if ttl := s.config().InvitationTTL; ttl > 0 {
+		expires := s.now().Add(ttl)
+		inv.ExpiresAt = &expires
+	}
+	err = s.repo.SaveInvitation(inv)
+	return
+}
+
+/*
+BootstrapAdmin creates the very first admin user. It fails
+if there is already an active admin.
+*/
+func (s *Service) BootstrapAdmin(u *NewUser) (*user.CredentialInfo, error) {
+	if s.repo.CountAdmins() > 0 {
+		return nil, fmt.Errorf(adminExists)
+	}
+	u.Admin = true
+	u.Active = true
+	return s.CreateNewUser(u)
+}
+
+/*
+BootstrapAdminFromConfig creates the first admin using the
+`auth.user.bootstrap.user` and `auth.user.bootstrap.pass`
+config keys. It does nothing if they are not set or if
+there is already an active admin.
+*/
+func (s *Service) BootstrapAdminFromConfig() error {
+	cfg := s.config()
+	username := cfg.BootstrapAdminUser
+	pass := cfg.BootstrapAdminPass
+	if username == "" || pass == "" || s.repo.CountAdmins() > 0 {
+		return nil
+	}
+	_, err := s.BootstrapAdmin(&NewUser{
+		User: username,
+		Pass: pass,
+		Name: username,
+	})
+	return err
+}
+
+/*
+isAdmin returns if the user is an active admin
+*/
+func isAdmin(u *user.CredentialInfo) bool {
+	return u != nil && u.Admin && u.Active
+}
+
+func (s *Service) useInvitation(code string) (*user.Invitation, error) {
+	if code == "" {
+		return nil, errInvalidInvitation
+	}
+	inv := s.repo.FindInvitation(hashCode(code))
+	now := s.now()
+	if inv == nil || !inv.IsValid(now) {
+		return nil, errInvalidInvitation
+	}
+	if !s.repo.UseInvitation(inv, now) {
+		return nil, errInvalidInvitation
+	}
+	return inv, nil
+}
+
+func randomCode() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashCode(code string) string {
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
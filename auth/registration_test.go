@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/eldius/jwt-auth-go/repository"
+	"github.com/spf13/viper"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestService(t *testing.T) *Service {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Errorf("Failed to open test database: %s", err.Error())
+		t.FailNow()
+	}
+	return NewServiceCustom(repository.NewRepositoryCustom(db))
+}
+
+func setRegistrationMode(t *testing.T, mode string) {
+	viper.Set("auth.user.registration.mode", mode)
+	t.Cleanup(func() {
+		viper.Set("auth.user.registration.mode", RegistrationOpen)
+	})
+}
+
+func TestRegisterUserRejectsAdminFromAnonymous(t *testing.T) {
+	svc := newTestService(t)
+
+	for _, u := range []*NewUser{
+		{User: "reg.user.001", Pass: "pass", Active: true, Admin: true},
+		{User: "reg.user.001", Pass: "pass", Active: true, Roles: []string{"billing"}},
+	} {
+		if _, err := svc.RegisterUser(u, nil, ""); !errors.Is(err, errRegistrationForbidden) {
+			t.Errorf("Anonymous registration must not create admins or assign roles, but returned '%v'", err)
+		}
+	}
+	if svc.GetRepository().FindUser("reg.user.001") != nil {
+		t.Error("Rejected registration must not create the user")
+	}
+}
+
+func TestCreateInvitationRequiresActiveAdmin(t *testing.T) {
+	svc := newTestService(t)
+
+	admin, err := svc.BootstrapAdmin(&NewUser{User: "reg.admin", Pass: "pass"})
+	if err != nil {
+		t.Errorf("Failed to bootstrap admin: %s", err.Error())
+		t.FailNow()
+	}
+	admin.Active = false
+	if _, _, err := svc.CreateInvitation(admin); !errors.Is(err, errRegistrationForbidden) {
+		t.Errorf("Inactive admins must not create invitations, but returned '%v'", err)
+	}
+}
+
+func TestRegisterUserAdminCreatesAdmin(t *testing.T) {
+	setRegistrationMode(t, RegistrationAdminOnly)
+	svc := newTestService(t)
+
+	admin, err := svc.BootstrapAdmin(&NewUser{User: "reg.admin", Pass: "pass"})
+	if err != nil {
+		t.Errorf("Failed to bootstrap admin: %s", err.Error())
+		t.FailNow()
+	}
+
+	if _, err := svc.RegisterUser(&NewUser{User: "reg.user.002", Pass: "pass"}, nil, ""); err != errRegistrationForbidden {
+		t.Errorf("Should forbid anonymous registration, but returned '%v'", err)
+	}
+
+	c, err := svc.RegisterUser(&NewUser{User: "reg.user.003", Pass: "pass", Admin: true}, admin, "")
+	if err != nil {
+		t.Errorf("Failed to register user: %s", err.Error())
+		t.FailNow()
+	}
+	if !c.Admin {
+		t.Error("Admins should be able to create admins")
+	}
+}
+
+func TestRegisterUserInviteOnly(t *testing.T) {
+	setRegistrationMode(t, RegistrationInviteOnly)
+	svc := newTestService(t)
+
+	admin, err := svc.BootstrapAdmin(&NewUser{User: "reg.admin", Pass: "pass"})
+	if err != nil {
+		t.Errorf("Failed to bootstrap admin: %s", err.Error())
+		t.FailNow()
+	}
+	code, _, err := svc.CreateInvitation(admin)
+	if err != nil {
+		t.Errorf("Failed to create invitation: %s", err.Error())
+		t.FailNow()
+	}
+
+	if _, err := svc.RegisterUser(&NewUser{User: "reg.user.004", Pass: "pass"}, nil, "invalid"); err != errInvalidInvitation {
+		t.Errorf("Should reject invalid invitation, but returned '%v'", err)
+	}
+	if _, err := svc.RegisterUser(&NewUser{User: "reg.user.005", Pass: "pass"}, nil, code); err != nil {
+		t.Errorf("Should accept invitation, but returned '%v'", err)
+	}
+	if _, err := svc.RegisterUser(&NewUser{User: "reg.user.006", Pass: "pass"}, nil, code); err != errInvalidInvitation {
+		t.Errorf("Should reject used invitation, but returned '%v'", err)
+	}
+}
+
+func TestBootstrapAdminOnlyOnce(t *testing.T) {
+	svc := newTestService(t)
+
+	if _, err := svc.BootstrapAdmin(&NewUser{User: "reg.admin.1", Pass: "pass"}); err != nil {
+		t.Errorf("Failed to bootstrap admin: %s", err.Error())
+	}
+	if _, err := svc.BootstrapAdmin(&NewUser{User: "reg.admin.2", Pass: "pass"}); err == nil {
+		t.Error("Should not bootstrap a second admin")
+	}
+
+	// a disabled admin can't be used, so another one can be bootstrapped
+	disableUser(t, svc, "reg.admin.1")
+	if _, err := svc.BootstrapAdmin(&NewUser{User: "reg.admin.3", Pass: "pass"}); err != nil {
+		t.Errorf("Should bootstrap an admin when the others are inactive, but was '%v'", err)
+	}
+}
+
+func TestAuthHandleUserRegistrationDisabled(t *testing.T) {
+	setRegistrationMode(t, RegistrationDisabled)
+	h := NewHandlerCustom(newTestService(t))
+	s := httptest.NewServer(h.HandleUser())
+	defer s.Close()
+
+	res, err := http.Post(s.URL, "application/json", bytes.NewBuffer([]byte(validUserPayload)))
+	if err != nil {
+		t.Errorf("Failed to execute request")
+		t.FailNow()
+	}
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should return status code 403 (Forbidden), but was '%s'", res.Status)
+	}
+}
+
+func TestHandleInvitation(t *testing.T) {
+	svc := newTestService(t)
+	h := NewHandlerCustom(svc)
+
+	admin, err := svc.BootstrapAdmin(&NewUser{User: "reg.admin", Pass: "pass"})
+	if err != nil {
+		t.Errorf("Failed to bootstrap admin: %s", err.Error())
+		t.FailNow()
+	}
+	jwt, err := svc.ToJWT(*admin)
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+
+	s := httptest.NewServer(h.HandleInvitation())
+	defer s.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, s.URL, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("Should return 201 (Created), but was '%s'", res.Status)
+	}
+	var body map[string]interface{}
+	_ = json.NewDecoder(res.Body).Decode(&body)
+	if body["code"] == "" || body["code"] == nil {
+		t.Error("Should return the invitation code")
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+const (
+	insufficientScope = "auth.jwt.validation.scope.insufficient"
+)
+
+/*
+AllowedScopes returns the scopes the user is allowed to request
+(the default scopes and the scopes of its active profiles)
+*/
+func (s *Service) AllowedScopes(u *user.CredentialInfo) []string {
+	return allowedScopes(u, s.config().DefaultScopes)
+}
+
+func allowedScopes(u *user.CredentialInfo, defaultScopes []string) []string {
+	scopes := mergeScopes(make([]string, 0), defaultScopes)
+	for _, p := range u.Profiles {
+		if p.Active {
+			scopes = mergeScopes(scopes, strings.Fields(p.Scopes))
+		}
+	}
+	return scopes
+}
+
+/*
+ToJWTWithScopes generates the JWT token with the requested
+scopes (space separated) the user is allowed to, scopes not
+allowed are ignored (an empty request means all the allowed
+scopes)
+*/
+func (s *Service) ToJWTWithScopes(u user.CredentialInfo, requested string) (string, error) {
+	return s.ToJWTWithAudience(u, requested, "")
+}
+
+/*
+ToJWTWithAudience generates the JWT token with the requested
+scopes to the audience (service), it must be one of the allowed
+audiences (`auth.jwt.audiences`) or empty for the default one
+*/
+func (s *Service) ToJWTWithAudience(u user.CredentialInfo, requested string, audience string) (string, error) {
+	claims := map[string]string{}
+	if scopes := intersectScopes(strings.Fields(requested), s.AllowedScopes(&u)); len(scopes) > 0 {
+		claims[TokenDataScope] = strings.Join(scopes, " ")
+	}
+	if audience != "" {
+		if audience != s.config().Audience && !containsScope(s.config().Audiences, audience) {
+			return "", fmt.Errorf(invalidAudience)
+		}
+		claims[TokenDataAudience] = audience
+	}
+	return s.ToJWTWithClaims(u, claims)
+}
+
+/*
+RequireScopes is an interceptor to validate the user is logged
+and the token was granted all the scopes
+*/
+func (s *Service) RequireScopes(f http.HandlerFunc, scopes ...string) http.Handler {
+	return s.RequireScopesWith(f, scopes)
+}
+
+/*
+RequireScopesWith is the RequireScopes interceptor
+with the AuthInterceptor options
+*/
+func (s *Service) RequireScopesWith(f http.HandlerFunc, scopes []string, opts ...InterceptorOption) http.Handler {
+	return s.AuthInterceptor(func(w http.ResponseWriter, r *http.Request) {
+		if !HasScopes(r.Context(), scopes...) {
+			log.Println(insufficientScope)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		f.ServeHTTP(w, r)
+	}, opts...)
+}
+
+/*
+ScopesFromContext returns the scopes granted to the request
+token (added to the context by the AuthInterceptor)
+*/
+func ScopesFromContext(ctx context.Context) []string {
+	if c, ok := ClaimsFromContext(ctx); ok {
+		return c.Scopes
+	}
+	// added with the legacy key
+	scopes, _ := ctx.Value(ScopesKey).([]string)
+	return scopes
+}
+
+/*
+HasScopes returns true if the request token was
+granted all the scopes
+*/
+func HasScopes(ctx context.Context, scopes ...string) bool {
+	granted := ScopesFromContext(ctx)
+	for _, scope := range scopes {
+		if !containsScope(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+userGrantScopes returns the scopes the user can grant to an
+OAuth client, the ones the user is allowed to (see AllowedScopes)
+and the OpenID Connect scopes
+*/
+func (s *Service) userGrantScopes(u *user.CredentialInfo, scopes []string) []string {
+	allowed := mergeScopes(s.AllowedScopes(u), []string{ScopeOpenID, ScopeProfile, ScopeEmail})
+	granted := make([]string, 0)
+	for _, scope := range scopes {
+		if containsScope(allowed, scope) && !containsScope(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted
+}
+
+/*
+intersectScopes returns the requested scopes that are
+allowed (all the allowed scopes if none was requested)
+*/
+func intersectScopes(requested []string, allowed []string) []string {
+	if len(requested) == 0 {
+		return allowed
+	}
+	scopes := make([]string, 0)
+	for _, scope := range requested {
+		if containsScope(allowed, scope) && !containsScope(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
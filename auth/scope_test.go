@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+func setupScopedUser(t *testing.T, svc *Service) {
+	if err := svc.GetRepository().SaveProfile(&user.Profile{Name: "billing", Active: true, Scopes: "invoices:read invoices:write"}); err != nil {
+		t.Errorf("Failed to create profile: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc.CreateNewUser(&NewUser{User: "scoped.user", Pass: "pass", Active: true, Roles: []string{"billing"}}); err != nil {
+		t.Errorf("Failed to create test user: %s", err.Error())
+		t.FailNow()
+	}
+}
+
+func TestLoginScopes(t *testing.T) {
+	svc := newTestService(t)
+	setupScopedUser(t, svc)
+	h := NewHandlerCustom(svc)
+	s := httptest.NewServer(h.HandleLogin())
+	defer s.Close()
+
+	for _, test := range []struct {
+		requested string
+		granted   string
+	}{
+		{"", "invoices:read invoices:write"},
+		{"invoices:read admin", "invoices:read"},
+	} {
+		body, _ := json.Marshal(&LoginRequest{User: "scoped.user", Pass: "pass", Scope: test.requested})
+		res, err := http.Post(s.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		var token map[string]string
+		_ = json.NewDecoder(res.Body).Decode(&token)
+		claims, err := svc.FromJWT(token["token"])
+		if err != nil || claims[TokenDataScope] != test.granted {
+			t.Errorf("Should grant '%s' when requesting '%s', but was '%v'", test.granted, test.requested, claims)
+		}
+	}
+}
+
+func TestRequireScopes(t *testing.T) {
+	svc := newTestService(t)
+	setupScopedUser(t, svc)
+	h := NewHandlerCustom(svc)
+	u := *svc.GetRepository().FindUser("scoped.user")
+
+	s := httptest.NewServer(h.RequireScopes(func(rw http.ResponseWriter, r *http.Request) {
+		if !HasScopes(r.Context(), "invoices:read") || len(ScopesFromContext(r.Context())) != 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}, "invoices:read"))
+	defer s.Close()
+
+	for _, test := range []struct {
+		scope  string
+		status int
+	}{
+		{"invoices:read", http.StatusNoContent},
+		{"invoices:write", http.StatusForbidden},
+	} {
+		token, _ := svc.ToJWTWithScopes(u, test.scope)
+		req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		if res.StatusCode != test.status {
+			t.Errorf("Should return '%d' for scope '%s', but was '%s'", test.status, test.scope, res.Status)
+		}
+		if test.status == http.StatusForbidden && res.Header.Get("WWW-Authenticate") == "" {
+			t.Error("Should return the insufficient scope challenge")
+		}
+	}
+}
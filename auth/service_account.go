@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/hashtools"
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/user"
+	"github.com/google/uuid"
+)
+
+/*
+NewServiceAccount is a VO to pass the new service
+account parameters (the certificate fields are optional)
+*/
+type NewServiceAccount struct {
+	Name           string   `json:"name"`
+	Scopes         []string `json:"scopes"`
+	CertThumbprint string   `json:"certThumbprint"`
+	CertSubject    string   `json:"certSubject"`
+}
+
+/*
+CreateServiceAccount creates the service account and its user,
+the client secret is returned only here
+*/
+func (s *Service) CreateServiceAccount(na *NewServiceAccount) (*user.ServiceAccount, string, error) {
+	pass, err := randomCode()
+	if err != nil {
+		return nil, "", err
+	}
+	// service accounts can't login with password, it's
+	// random just to fill the credentials
+	u, err := s.CreateNewUser(&NewUser{
+		User:   na.Name,
+		Pass:   pass,
+		Name:   na.Name,
+		Active: true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	u.ServiceAccount = true
+	if err := s.repo.SaveUser(u); err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomCode()
+	if err != nil {
+		return nil, "", err
+	}
+	a := &user.ServiceAccount{
+		UserID:         u.ID,
+		ClientID:       uuid.New().String(),
+		Name:           na.Name,
+		Scopes:         strings.Join(na.Scopes, " "),
+		CertThumbprint: na.CertThumbprint,
+		CertSubject:    na.CertSubject,
+		SecretSalt:     hashtools.Salt(),
+	}
+	if a.SecretHash, err = hashtools.Hash(secret, a.SecretSalt); err != nil {
+		return nil, "", err
+	}
+	return a, secret, s.repo.SaveServiceAccount(a)
+}
+
+/*
+AuthenticateServiceAccount authenticates the service account
+by its TLS client certificate (thumbprint, or subject if the
+certificate chain was verified by the TLS server) or by its
+client secret (HTTP basic authentication or the `client_id`
+and `client_secret` form params). The client certificate is
+returned to bind the tokens to it.
+*/
+func (s *Service) AuthenticateServiceAccount(r *http.Request) (*user.ServiceAccount, *x509.Certificate, error) {
+	var cert *x509.Certificate
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cert = r.TLS.PeerCertificates[0]
+		if a := s.repo.FindServiceAccountByThumbprint(CertificateThumbprint(cert)); a != nil {
+			return a, cert, nil
+		}
+		if len(r.TLS.VerifiedChains) > 0 {
+			if a := s.repo.FindServiceAccountBySubject(cert.Subject.String()); a != nil {
+				return a, cert, nil
+			}
+		}
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	a := s.repo.FindServiceAccount(clientID)
+	if a == nil || secret == "" {
+		return nil, nil, oauthError(oauthInvalidClient, "service account authentication failed")
+	}
+	h, err := hashtools.Hash(secret, a.SecretSalt)
+	if err != nil || subtle.ConstantTimeCompare(h, a.SecretHash) != 1 {
+		return nil, nil, oauthError(oauthInvalidClient, "service account authentication failed")
+	}
+	return a, cert, nil
+}
+
+/*
+ServiceAccountToken issues an access token to the service
+account, if the client certificate is not nil the token is
+bound to it (`cnf.x5t#S256`)
+*/
+func (s *Service) ServiceAccountToken(a *user.ServiceAccount, cert *x509.Certificate, scope string) (*TokenResponse, error) {
+	u := s.repo.FindUserByID(a.UserID)
+	if u == nil || u.ID == 0 || !u.Active {
+		return nil, oauthError(oauthInvalidClient, "service account is not active")
+	}
+	scopes, err := grantScopes(scope, a.ScopeList())
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]string{
+		TokenDataSubjectType: SubjectTypeService,
+		TokenDataClientID:    a.ClientID,
+		TokenDataScope:       strings.Join(scopes, " "),
+	}
+	if cert != nil {
+		claims[TokenDataCertThumbprint] = CertificateThumbprint(cert)
+	}
+	access, err := s.ToJWTWithClaims(*u, claims)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.config().tokenTTL().Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+/*
+CertificateThumbprint returns the certificate SHA-256
+thumbprint (base64url encoded, as `x5t#S256`)
+*/
+func CertificateThumbprint(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.Raw)
+	return jose.Encode(h[:])
+}
+
+/*
+verifyCertificateBinding checks that certificate bound tokens
+are sent over a TLS connection using the same client certificate
+*/
+func verifyCertificateBinding(cert *x509.Certificate, tokenData map[string]string) error {
+	thumbprint, ok := tokenData[TokenDataCertThumbprint]
+	if !ok {
+		return nil
+	}
+	if cert == nil {
+		return fmt.Errorf(invalidBinding)
+	}
+	if subtle.ConstantTimeCompare([]byte(CertificateThumbprint(cert)), []byte(thumbprint)) != 1 {
+		return fmt.Errorf(invalidBinding)
+	}
+	return nil
+}
+
+/*
+peerCertificate returns the TLS client certificate
+of the request (nil if there is none)
+*/
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
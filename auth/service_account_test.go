@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+func newClientCertificate(t *testing.T, cn string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Errorf("Failed to generate key: %s", err.Error())
+		t.FailNow()
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Errorf("Failed to create certificate: %s", err.Error())
+		t.FailNow()
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+func mtlsClient(s *httptest.Server, cert *tls.Certificate) *http.Client {
+	c := s.Client()
+	transport := c.Transport.(*http.Transport).Clone()
+	if cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{Transport: transport}
+}
+
+func TestServiceAccountMTLSBoundToken(t *testing.T) {
+	svc := newTestService(t)
+	h := NewHandlerCustom(svc)
+	cert := newClientCertificate(t, "billing-service")
+
+	a, _, err := svc.CreateServiceAccount(&NewServiceAccount{
+		Name:           "billing-service",
+		Scopes:         []string{"invoices"},
+		CertThumbprint: CertificateThumbprint(cert.Leaf),
+	})
+	if err != nil {
+		t.Errorf("Failed to create service account: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc.ValidatePass("billing-service", "any"); err == nil {
+		t.Error("Service accounts must not login with password")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/token", h.HandleServiceToken())
+	mux.Handle("/protected", h.AuthInterceptor(func(rw http.ResponseWriter, r *http.Request) {
+		if !h.svc.GetCurrentUser(r).ServiceAccount {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	s := httptest.NewUnstartedServer(mux)
+	s.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	s.StartTLS()
+	defer s.Close()
+
+	client := mtlsClient(s, &cert)
+	res, err := client.PostForm(s.URL+"/token", url.Values{"grant_type": {user.GrantClientCredentials}})
+	if err != nil {
+		t.Errorf("Failed to execute request: %v", err)
+		t.FailNow()
+	}
+	var tr TokenResponse
+	_ = json.NewDecoder(res.Body).Decode(&tr)
+	if res.StatusCode != http.StatusOK || tr.AccessToken == "" || tr.Scope != "invoices" {
+		t.Errorf("Should issue the token, but was '%s' '%v'", res.Status, tr)
+		t.FailNow()
+	}
+	claims, _ := svc.FromJWT(tr.AccessToken)
+	if claims[TokenDataCertThumbprint] != a.CertThumbprint || claims[TokenDataSubjectType] != SubjectTypeService {
+		t.Errorf("Token should be bound to the certificate '%v'", claims)
+	}
+
+	protected := func(c *http.Client) int {
+		req, _ := http.NewRequest(http.MethodGet, s.URL+"/protected", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", tr.AccessToken))
+		res, err := c.Do(req)
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		return res.StatusCode
+	}
+	if status := protected(client); status != http.StatusNoContent {
+		t.Errorf("Should accept the bound token, but was '%d'", status)
+	}
+	other := newClientCertificate(t, "billing-service")
+	if status := protected(mtlsClient(s, &other)); status != http.StatusForbidden {
+		t.Errorf("Should reject the token sent with another certificate, but was '%d'", status)
+	}
+	if status := protected(mtlsClient(s, nil)); status != http.StatusForbidden {
+		t.Errorf("Should reject the token sent without certificate, but was '%d'", status)
+	}
+}
+
+func TestServiceAccountClientSecret(t *testing.T) {
+	svc := newTestService(t)
+	h := NewHandlerCustom(svc)
+	a, secret, err := svc.CreateServiceAccount(&NewServiceAccount{Name: "report-job"})
+	if err != nil {
+		t.Errorf("Failed to create service account: %s", err.Error())
+		t.FailNow()
+	}
+	s := httptest.NewServer(h.HandleServiceToken())
+	defer s.Close()
+
+	for _, test := range []struct {
+		secret string
+		status int
+	}{
+		{"wrong", http.StatusUnauthorized},
+		{secret, http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, s.URL, strings.NewReader("grant_type=client_credentials"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(a.ClientID, test.secret)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		var tr TokenResponse
+		_ = json.NewDecoder(res.Body).Decode(&tr)
+		if res.StatusCode != test.status {
+			t.Errorf("Should return '%d', but was '%s'", test.status, res.Status)
+		}
+		if test.status == http.StatusOK {
+			claims, _ := svc.FromJWT(tr.AccessToken)
+			if _, ok := claims[TokenDataCertThumbprint]; ok {
+				t.Errorf("Token should not be bound without certificate '%v'", claims)
+			}
+		}
+	}
+}
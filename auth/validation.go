@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	notYetValidToken = "auth.jwt.validation.token.not.valid.yet"
+	futureToken      = "auth.jwt.validation.token.issued.in.future"
+	tooOldToken      = "auth.jwt.validation.token.too.old"
+	missingClaim     = "auth.jwt.validation.claim.missing"
+	invalidTime      = "auth.jwt.validation.time.invalid"
+)
+
+// Standard JWT time claims (tokens issued by other
+// servers use them instead of expires and issued)
+const (
+	TokenDataExp       = "exp"
+	TokenDataIssuedAt  = "iat"
+	TokenDataNotBefore = "nbf"
+)
+
+/*
+Clock returns the current time, the service clock can
+be replaced to make the token validation deterministic
+*/
+type Clock func() time.Time
+
+/*
+SetClock replaces the clock used to issue and validate
+tokens (nil restores the system clock)
+*/
+func (s *Service) SetClock(c Clock) {
+	s.clock = c
+}
+
+func (s *Service) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return time.Now()
+}
+
+/*
+validateTokenData validates the token times (tolerating the
+leeway clock skew), the token max age and the required claims
+*/
+func (c *Config) validateTokenData(tokenData map[string]string, now time.Time) error {
+	for _, claim := range c.requiredClaims() {
+		if _, ok := tokenData[claim]; !ok {
+			return fmt.Errorf("%s: %s", missingClaim, claim)
+		}
+	}
+	leeway := c.Leeway
+
+	expires, err := tokenTime(tokenData, TokenDataExpires, TokenDataExp)
+	if err != nil {
+		return err
+	}
+	if !expires.IsZero() && now.After(expires.Add(leeway)) {
+		return fmt.Errorf(expiredToken)
+	}
+
+	notBefore, err := tokenTime(tokenData, TokenDataNotBefore)
+	if err != nil {
+		return err
+	}
+	if !notBefore.IsZero() && now.Add(leeway).Before(notBefore) {
+		return fmt.Errorf(notYetValidToken)
+	}
+
+	issued, err := tokenTime(tokenData, TokenDataIssued, TokenDataIssuedAt)
+	if err != nil {
+		return err
+	}
+	if !issued.IsZero() && now.Add(leeway).Before(issued) {
+		return fmt.Errorf(futureToken)
+	}
+	if maxAge := c.MaxAge; maxAge > 0 {
+		if issued.IsZero() || now.Sub(issued) > maxAge+leeway {
+			return fmt.Errorf(tooOldToken)
+		}
+	}
+	return nil
+}
+
+/*
+tokenTime returns the first time claim found (zero
+if the token has none of them)
+*/
+func tokenTime(tokenData map[string]string, claims ...string) (time.Time, error) {
+	for _, claim := range claims {
+		if val, ok := tokenData[claim]; ok {
+			t, err := parseTokenTime(val)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%s: %s", invalidTime, claim)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+/*
+parseTokenTime parses RFC3339 times (used by this library
+tokens) and NumericDate values (seconds since epoch)
+*/
+func parseTokenTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(secs), 0), nil
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestValidateTokenDataTimes(t *testing.T) {
+	cfg := &Config{Leeway: 30 * time.Second, RequiredClaims: []string{}}
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string {
+		return now.Add(d).Format(time.RFC3339)
+	}
+	for _, test := range []struct {
+		name      string
+		tokenData map[string]string
+		err       string
+	}{
+		{"expired within leeway", map[string]string{TokenDataExpires: at(-20 * time.Second)}, ""},
+		{"expired", map[string]string{TokenDataExpires: at(-time.Minute)}, expiredToken},
+		{"numeric exp", map[string]string{TokenDataExp: strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)}, expiredToken},
+		{"not valid yet", map[string]string{TokenDataNotBefore: strconv.FormatInt(now.Add(time.Minute).Unix(), 10)}, notYetValidToken},
+		{"nbf within leeway", map[string]string{TokenDataNotBefore: at(20 * time.Second)}, ""},
+		{"issued in the future", map[string]string{TokenDataIssued: at(time.Minute)}, futureToken},
+		{"invalid time", map[string]string{TokenDataExpires: "tomorrow"}, invalidTime},
+	} {
+		err := cfg.validateTokenData(test.tokenData, now)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: must not return error: '%s'", test.name, err.Error())
+		}
+		if test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)) {
+			t.Errorf("%s: must return '%s', but was '%v'", test.name, test.err, err)
+		}
+	}
+}
+
+func TestValidateTokenDataPolicies(t *testing.T) {
+	cfg := &Config{MaxAge: time.Hour, RequiredClaims: []string{TokenDataExpires}}
+	now := time.Now()
+	expires := now.Add(time.Hour).Format(time.RFC3339)
+	if err := cfg.validateTokenData(map[string]string{TokenDataIssued: now.Format(time.RFC3339)}, now); err == nil || !strings.HasPrefix(err.Error(), missingClaim) {
+		t.Errorf("Must reject tokens without expiration, but was '%v'", err)
+	}
+	if err := cfg.validateTokenData(map[string]string{TokenDataExpires: expires}, now); err == nil || err.Error() != tooOldToken {
+		t.Errorf("Must reject tokens without issued time, but was '%v'", err)
+	}
+	old := map[string]string{TokenDataExpires: expires, TokenDataIssued: now.Add(-2 * time.Hour).Format(time.RFC3339)}
+	if err := cfg.validateTokenData(old, now); err == nil || err.Error() != tooOldToken {
+		t.Errorf("Must reject tokens older than max age, but was '%v'", err)
+	}
+}
+
+func TestServiceClock(t *testing.T) {
+	viper.Set("auth.jwt.ttl", "1h")
+	defer viper.Set("auth.jwt.ttl", "")
+
+	svc := newTestService(t)
+	setupUser(t, "clock.user", "pass", svc)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	svc.SetClock(func() time.Time { return now })
+
+	token, err := svc.ToJWT(*svc.GetRepository().FindUser("clock.user"))
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc.validateToken(token); err != nil {
+		t.Errorf("Must not return error: '%s'", err.Error())
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := svc.validateToken(token); err == nil || err.Error() != expiredToken {
+		t.Errorf("Must return expired token error, but was '%v'", err)
+	}
+}
+
+func TestTokensAlwaysExpire(t *testing.T) {
+	svc := newConfigTestService(t, &Config{Secret: "secret"})
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	svc.SetClock(func() time.Time { return now })
+
+	claims, err := svc.FromJWTClaims(issueTestToken(t, svc, "ttl.user"))
+	if err != nil {
+		t.Errorf("Failed to parse token: %s", err.Error())
+		t.FailNow()
+	}
+	if !claims.ExpiresAt.Equal(now.Add(defaultTTL)) {
+		t.Errorf("Tokens must expire after the default TTL when it's not set, but was '%v'", claims.ExpiresAt)
+	}
+}
+
+func TestServiceClockExpiresTokens(t *testing.T) {
+	svc := newTestService(t)
+	setupUser(t, "clock.user", "pass", svc)
+	u := svc.GetRepository().FindUser("clock.user")
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	svc.SetClock(func() time.Time { return now })
+
+	token, _, err := svc.issueOneTimeToken("clock", u.ID, 10*time.Minute, "")
+	if err != nil {
+		t.Errorf("Failed to issue one time token: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc.findOneTimeToken("clock", token); err != nil {
+		t.Errorf("Must accept one time tokens before they expire, but was '%v'", err)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if _, err := svc.findOneTimeToken("clock", token); err == nil {
+		t.Error("Must reject one time tokens after they expire")
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Package client is the Go client of the auth server, it logs
+in, caches the token (logging in again before it expires) and
+authenticates the requests to the services
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/verifier"
+)
+
+const (
+	// DefaultLoginPath is the login endpoint path (relative to the base URL)
+	DefaultLoginPath = "/login"
+
+	// tokens are renewed a bit before they expire, so
+	// they don't expire in the middle of the request
+	defaultRefreshBefore = 30 * time.Second
+)
+
+/*
+Client is the auth server client, it's safe
+for concurrent use
+*/
+type Client struct {
+	baseURL       string
+	loginPath     string
+	httpClient    *http.Client
+	refreshBefore time.Duration
+	scope         string
+	audience      string
+
+	mu        sync.Mutex
+	user      string
+	pass      string
+	token     string
+	expiresAt time.Time
+}
+
+/*
+Option customizes the client
+*/
+type Option func(*Client)
+
+/*
+WithCredentials sets the user credentials (they are
+kept to login again when the token expires)
+*/
+func WithCredentials(user string, pass string) Option {
+	return func(c *Client) {
+		c.user = user
+		c.pass = pass
+	}
+}
+
+/*
+WithScope requests the scopes (space separated)
+*/
+func WithScope(scope string) Option {
+	return func(c *Client) {
+		c.scope = scope
+	}
+}
+
+/*
+WithAudience requests tokens to the audience
+*/
+func WithAudience(audience string) Option {
+	return func(c *Client) {
+		c.audience = audience
+	}
+}
+
+/*
+WithHTTPClient replaces the HTTP client used
+to call the auth server
+*/
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+/*
+WithLoginPath replaces the login endpoint path
+*/
+func WithLoginPath(path string) Option {
+	return func(c *Client) {
+		c.loginPath = path
+	}
+}
+
+/*
+WithRefreshBefore sets how long before the token
+expiration the client logs in again (default 30s)
+*/
+func WithRefreshBefore(d time.Duration) Option {
+	return func(c *Client) {
+		c.refreshBefore = d
+	}
+}
+
+/*
+New creates a client of the auth server (the base URL
+includes the endpoints prefix, eg: `https://example.com/auth`)
+*/
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		loginPath:     DefaultLoginPath,
+		httpClient:    http.DefaultClient,
+		refreshBefore: defaultRefreshBefore,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+/*
+Login authenticates the user, the credentials are kept
+to login again when the token expires
+*/
+func (c *Client) Login(ctx context.Context, user string, pass string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.user = user
+	c.pass = pass
+	return c.login(ctx)
+}
+
+/*
+Token returns the cached token, logging in if there is
+no token or it's about to expire
+*/
+func (c *Client) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && (c.expiresAt.IsZero() || time.Now().Add(c.refreshBefore).Before(c.expiresAt)) {
+		return c.token, nil
+	}
+	return c.login(ctx)
+}
+
+/*
+Invalidate discards the cached token (the next
+request logs in again)
+*/
+func (c *Client) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+	c.expiresAt = time.Time{}
+}
+
+/*
+Transport returns a RoundTripper adding the token to the
+requests, requests receiving a 401 are sent again (once)
+after logging in again. A nil base uses the default transport.
+*/
+func (c *Client) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{client: c, base: base}
+}
+
+/*
+HTTPClient returns an HTTP client authenticating
+the requests with the token
+*/
+func (c *Client) HTTPClient() *http.Client {
+	return &http.Client{Transport: c.Transport(nil)}
+}
+
+/*
+login must be called holding the lock
+*/
+func (c *Client) login(ctx context.Context) (string, error) {
+	if c.user == "" || c.pass == "" {
+		return "", ErrNoCredentials
+	}
+	body, err := json.Marshal(map[string]string{
+		"user":     c.user,
+		"pass":     c.pass,
+		"scope":    c.scope,
+		"audience": c.audience,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+c.loginPath, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", responseError(res)
+	}
+	var tr struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil || tr.Token == "" {
+		// cookie mode servers don't return the token
+		return "", ErrInvalidToken
+	}
+	expiresAt, err := tokenExpiration(tr.Token)
+	if err != nil {
+		return "", err
+	}
+	c.token = tr.Token
+	c.expiresAt = expiresAt
+	return c.token, nil
+}
+
+/*
+tokenExpiration reads the token expiration (the signature
+is not verified, the client doesn't have the server keys)
+*/
+func tokenExpiration(token string) (time.Time, error) {
+	t, err := jose.Parse(token)
+	if err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+	var raw map[string]interface{}
+	if err := t.Claims(&raw); err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+	claims, err := verifier.ParseClaims(raw)
+	if err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+	return claims.ExpiresAt, nil
+}
+
+type transport struct {
+	client *Client
+	base   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.roundTrip(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	// requests with a body can be sent again only if it can be rewound
+	if req.Body != nil && req.GetBody == nil {
+		return res, nil
+	}
+	res.Body.Close()
+	t.client.Invalidate()
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	return t.roundTrip(req)
+}
+
+func (t *transport) roundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.client.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	// RoundTrippers must not modify the request
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eldius/jwt-auth-go/auth"
+	"github.com/eldius/jwt-auth-go/repository"
+	"github.com/spf13/viper"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type testServer struct {
+	*httptest.Server
+	logins int
+	calls  int
+}
+
+func newTestServer(t *testing.T, protected func(ts *testServer) http.Handler) *testServer {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Errorf("Failed to open test database: %s", err.Error())
+		t.FailNow()
+	}
+	cfg := auth.LoadConfig()
+	cfg.Secret = "client-test-secret"
+	svc, err := auth.NewServiceWithConfig(cfg, repository.NewRepositoryCustom(db))
+	if err != nil {
+		t.Errorf("Failed to create service: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc.CreateNewUser(&auth.NewUser{User: "client.user", Pass: "pass", Active: true}); err != nil {
+		t.Errorf("Failed to create test user: %s", err.Error())
+		t.FailNow()
+	}
+	h := auth.NewHandlerCustom(svc)
+	ts := &testServer{}
+	mux := http.NewServeMux()
+	login := h.HandleLogin()
+	mux.HandleFunc("/login", func(rw http.ResponseWriter, r *http.Request) {
+		ts.logins++
+		login(rw, r)
+	})
+	mux.Handle("/protected", svc.Middleware()(protected(ts)))
+	ts.Server = httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClientTransport(t *testing.T) {
+	viper.Set("auth.jwt.ttl", "1h")
+	defer viper.Set("auth.jwt.ttl", "")
+
+	s := newTestServer(t, func(ts *testServer) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			ts.calls++
+			u, _ := auth.UserFromContext(r.Context())
+			_, _ = rw.Write([]byte(u.User))
+		})
+	})
+	c := New(s.URL, WithCredentials("client.user", "pass"))
+	hc := c.HTTPClient()
+	for i := 0; i < 2; i++ {
+		res, err := hc.Get(s.URL + "/protected")
+		if err != nil || res.StatusCode != http.StatusOK {
+			t.Errorf("Should authenticate the request, but was '%v' '%v'", res, err)
+			t.FailNow()
+		}
+	}
+	if s.logins != 1 || s.calls != 2 {
+		t.Errorf("Should reuse the token, but logged in %d times", s.logins)
+	}
+}
+
+func TestClientRetriesUnauthorized(t *testing.T) {
+	s := newTestServer(t, func(ts *testServer) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			ts.calls++
+			if ts.calls == 1 {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			rw.WriteHeader(http.StatusNoContent)
+		})
+	})
+	c := New(s.URL, WithCredentials("client.user", "pass"))
+	res, err := c.HTTPClient().Post(s.URL+"/protected", "text/plain", strings.NewReader("body"))
+	if err != nil || res.StatusCode != http.StatusNoContent {
+		t.Errorf("Should retry after logging in again, but was '%v' '%v'", res, err)
+		t.FailNow()
+	}
+	if s.logins != 2 || s.calls != 2 {
+		t.Errorf("Should login again and retry once, but was %d logins and %d calls", s.logins, s.calls)
+	}
+}
+
+func TestClientLoginErrors(t *testing.T) {
+	s := newTestServer(t, func(ts *testServer) http.Handler {
+		return http.NotFoundHandler()
+	})
+	c := New(s.URL)
+	if _, err := c.Token(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Should require credentials, but was '%v'", err)
+	}
+	_, err := c.Login(context.Background(), "client.user", "wrong")
+	var e *Error
+	if !errors.Is(err, ErrUnauthorized) || !errors.As(err, &e) || e.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Should return an unauthorized error, but was '%v'", err)
+	}
+	c = New(s.URL, WithCredentials("client.user", "pass"), WithAudience("unknown"))
+	if _, err := c.Token(context.Background()); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("Should return a bad request error, but was '%v'", err)
+	}
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors returned by the client (use errors.Is to check the
+// server responses, they are returned as *Error values)
+var (
+	ErrNoCredentials = errors.New("auth.client.credentials.missing")
+	ErrUnauthorized  = errors.New("auth.client.unauthorized")
+	ErrForbidden     = errors.New("auth.client.forbidden")
+	ErrBadRequest    = errors.New("auth.client.request.invalid")
+	ErrServer        = errors.New("auth.client.server.error")
+	ErrInvalidToken  = errors.New("auth.client.token.invalid")
+)
+
+/*
+Error is an error response of the auth server, Code is the
+server error code (eg: `auth.jwt.validation.token.expired`
+or an OAuth error code) when the response has one
+*/
+type Error struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("auth server returned %d", e.StatusCode)
+	}
+	if e.Description == "" {
+		return fmt.Sprintf("auth server returned %d: %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("auth server returned %d: %s (%s)", e.StatusCode, e.Code, e.Description)
+}
+
+/*
+Is maps the response status to the client errors
+*/
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+/*
+responseError builds the error from the response (the
+server sends `{"error": "code"}` or the OAuth errors
+with `error_description`)
+*/
+func responseError(res *http.Response) error {
+	e := &Error{StatusCode: res.StatusCode}
+	var body struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err == nil {
+		e.Code = body.Error
+		e.Description = body.ErrorDescription
+	}
+	return e
+}
@@ -0,0 +1,35 @@
+/*
+The auth-server command runs the standalone auth server
+(the config is read from `~/.auth-server/auth-server.yml`
+or from the file passed in the `-config` flag, changes in it
+and in the secret/key files are applied without restarting)
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/eldius/jwt-auth-go/auth"
+	"github.com/eldius/jwt-auth-go/config"
+	"github.com/eldius/jwt-auth-go/server"
+)
+
+func main() {
+	cfgFile := flag.String("config", "", "config file (default is $HOME/.auth-server/auth-server.yml)")
+	flag.Parse()
+
+	config.SetupViper(*cfgFile)
+	svc, err := auth.LoadService(nil)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	svc.WatchConfig()
+	if err := svc.WatchKeys(context.Background()); err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := server.New(auth.NewHandlerCustom(svc)).Run(); err != nil {
+		log.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,147 @@
+/*
+The jwt-auth command administers the auth database (users,
+profiles and tokens) and generates signing keys. It uses the
+auth server config (`~/.auth-server/auth-server.yml` or the
+file passed in the `-config` flag).
+
+Usage:
+
+	jwt-auth [-config file] [-json] <command> <subcommand> [flags]
+
+Commands:
+
+	user create|list|enable|disable|delete|passwd|profiles
+	token mint|decode|verify
+	keys generate
+	config dump
+	migrate
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/eldius/jwt-auth-go/auth"
+	"github.com/eldius/jwt-auth-go/config"
+)
+
+const usage = `Usage: jwt-auth [-config file] [-json] <command> <subcommand> [flags]
+
+Commands:
+  user create -user <user> -pass <pass> [-name <name>] [-email <email>] [-admin] [-inactive] [-profiles <p1,p2>]
+  user list
+  user enable -user <user>
+  user disable -user <user>
+  user delete -user <user>
+  user passwd -user <user> -pass <pass>
+  user profiles -user <user> -set <p1,p2>
+  token mint -user <user> [-scope <scopes>] [-audience <audience>]
+  token decode <token>
+  token verify <token>
+  keys generate [-alg HS256|RS256] [-bits 2048]
+  config dump
+  migrate
+`
+
+/*
+command is a subcommand, it returns the value
+printed as the command output
+*/
+type command func(c *cli, args []string) (interface{}, error)
+
+type cli struct {
+	jsonOutput bool
+	svc        *auth.Service
+}
+
+/*
+service creates the auth service (it opens
+the database) the first time it's used
+*/
+func (c *cli) service() *auth.Service {
+	if c.svc == nil {
+		c.svc = auth.NewService()
+	}
+	return c.svc
+}
+
+var commands = map[string]map[string]command{
+	"user": {
+		"create":   userCreate,
+		"list":     userList,
+		"enable":   userActive("user enable", true),
+		"disable":  userActive("user disable", false),
+		"delete":   userDelete,
+		"passwd":   userPasswd,
+		"profiles": userProfiles,
+	},
+	"token": {
+		"mint":   tokenMint,
+		"decode": tokenDecode,
+		"verify": tokenVerify,
+	},
+	"keys": {
+		"generate": keysGenerate,
+	},
+	"config": {
+		"dump": configDump,
+	},
+	"migrate": {
+		"": migrate,
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("jwt-auth", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	cfgFile := fs.String("config", "", "config file (default is $HOME/.auth-server/auth-server.yml)")
+	jsonOutput := fs.Bool("json", false, "print the output as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cmd, args, ok := findCommand(fs.Args())
+	if !ok {
+		fs.Usage()
+		return 2
+	}
+	config.SetupViper(*cfgFile)
+
+	c := &cli{jsonOutput: *jsonOutput}
+	out, err := cmd(c, args)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err.Error())
+		return 1
+	}
+	if err := c.print(stdout, out); err != nil {
+		fmt.Fprintln(stderr, "Error:", err.Error())
+		return 1
+	}
+	return 0
+}
+
+func findCommand(args []string) (command, []string, bool) {
+	if len(args) == 0 {
+		return nil, nil, false
+	}
+	subcommands, ok := commands[args[0]]
+	if !ok {
+		return nil, nil, false
+	}
+	if cmd, ok := subcommands[""]; ok {
+		return cmd, args[1:], true
+	}
+	if len(args) < 2 {
+		return nil, nil, false
+	}
+	cmd, ok := subcommands[args[1]]
+	return cmd, args[2:], ok
+}
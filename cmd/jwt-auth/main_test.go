@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.Set("auth.dev.mode", true)
+}
+
+func runCommand(t *testing.T, args ...string) (string, int) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	if code != 0 {
+		t.Logf("%v: %s", args, stderr.String())
+	}
+	return stdout.String(), code
+}
+
+func TestUserCommands(t *testing.T) {
+	viper.Set("auth.database.url", filepath.Join(t.TempDir(), "test.db"))
+	defer viper.Set("auth.database.url", "")
+
+	if _, code := runCommand(t, "user", "create", "-user", "cli.user", "-pass", "pass"); code != 0 {
+		t.Errorf("Should create the user, but exited with %d", code)
+	}
+	if _, code := runCommand(t, "user", "disable", "-user", "cli.user"); code != 0 {
+		t.Errorf("Should disable the user, but exited with %d", code)
+	}
+	out, code := runCommand(t, "-json", "user", "list")
+	var users []map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &users); err != nil || code != 0 {
+		t.Errorf("Should list the users as JSON, but was '%s' (%v)", out, err)
+		t.FailNow()
+	}
+	if len(users) != 1 || users[0]["user"] != "cli.user" || users[0]["active"] != false {
+		t.Errorf("Should list the disabled user, but was '%v'", users)
+	}
+	if _, code := runCommand(t, "user", "delete", "-user", "cli.user"); code != 0 {
+		t.Errorf("Should delete the user, but exited with %d", code)
+	}
+	if out, _ := runCommand(t, "-json", "user", "list"); strings.TrimSpace(out) != "[]" {
+		t.Errorf("Should not list deleted users, but was '%s'", out)
+	}
+	if _, code := runCommand(t, "user", "delete", "-user", "unknown"); code != 1 {
+		t.Errorf("Should fail to delete unknown users, but exited with %d", code)
+	}
+	if _, code := runCommand(t, "user", "unknown"); code != 2 {
+		t.Errorf("Should fail on unknown commands, but exited with %d", code)
+	}
+}
+
+func TestTokenCommands(t *testing.T) {
+	viper.Set("auth.database.url", filepath.Join(t.TempDir(), "test.db"))
+	viper.Set("auth.jwt.secret", "cli-test-secret")
+	defer viper.Set("auth.database.url", "")
+	defer viper.Set("auth.jwt.secret", "")
+
+	if _, code := runCommand(t, "user", "create", "-user", "cli.user", "-pass", "pass"); code != 0 {
+		t.Errorf("Should create the user, but exited with %d", code)
+	}
+	token, code := runCommand(t, "token", "mint", "-user", "cli.user")
+	token = strings.TrimSpace(token)
+	if code != 0 || token == "" {
+		t.Errorf("Should mint a token, but exited with %d", code)
+		t.FailNow()
+	}
+	out, code := runCommand(t, "-json", "token", "verify", token)
+	var claims map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &claims); err != nil || code != 0 || claims["user"] != "cli.user" {
+		t.Errorf("Should verify the token, but was '%s'", out)
+	}
+	if _, code := runCommand(t, "token", "verify", token+"x"); code != 1 {
+		t.Errorf("Should reject invalid tokens, but exited with %d", code)
+	}
+	out, code = runCommand(t, "-json", "keys", "generate", "-alg", "RS256", "-bits", "1024")
+	if code != 0 || !strings.Contains(out, "RSA PRIVATE KEY") {
+		t.Errorf("Should generate a RSA key, but was '%s'", out)
+	}
+}
+
+func TestConfigDump(t *testing.T) {
+	t.Setenv("JWTAUTH_AUTH_JWT_ISSUER", "https://issuer.test")
+	t.Setenv("JWTAUTH_AUTH_USER_BOOTSTRAP_PASS", "admin-pass")
+
+	out, code := runCommand(t, "-json", "config", "dump")
+	var settings []map[string]string
+	if err := json.Unmarshal([]byte(out), &settings); err != nil || code != 0 {
+		t.Errorf("Should dump the config as JSON, but was '%s' (%v)", out, err)
+		t.FailNow()
+	}
+	values := map[string]map[string]string{}
+	for _, s := range settings {
+		values[s["key"]] = s
+	}
+	if s := values["auth.jwt.issuer"]; s["value"] != "https://issuer.test" || s["source"] != "env (JWTAUTH_AUTH_JWT_ISSUER)" {
+		t.Errorf("Should read the issuer from the environment, but was '%v'", s)
+	}
+	if s := values["auth.user.bootstrap.pass"]; s["value"] != "[REDACTED]" {
+		t.Errorf("Should redact secrets, but was '%v'", s)
+	}
+	if s := values["auth.jwt.ttl"]; s["value"] != "3600s" || s["source"] != "default" {
+		t.Errorf("Should print the defaults, but was '%v'", s)
+	}
+
+	out, code = runCommand(t, "config", "dump")
+	if code != 0 || !strings.HasPrefix(out, "KEY") || strings.Contains(out, "admin-pass") {
+		t.Errorf("Should dump the config as text, but was '%s'", out)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+/*
+message is the output of commands without data
+*/
+type message struct {
+	Message string `json:"message"`
+}
+
+/*
+print writes the command output as JSON or as text
+*/
+func (c *cli) print(w io.Writer, out interface{}) error {
+	if out == nil {
+		return nil
+	}
+	if c.jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	switch v := out.(type) {
+	case message:
+		_, err := fmt.Fprintln(w, v.Message)
+		return err
+	case string:
+		_, err := fmt.Fprintln(w, v)
+		return err
+	case []user.UserView:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSER\tNAME\tEMAIL\tACTIVE\tADMIN\tPROFILES")
+		for _, u := range v {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%t\t%t\t%s\n", u.ID, u.User, u.Name, u.Email, u.Active, u.Admin, strings.Join(u.Roles, ","))
+		}
+		return tw.Flush()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/auth"
+	"github.com/eldius/jwt-auth-go/config"
+	"github.com/eldius/jwt-auth-go/jose"
+	"github.com/eldius/jwt-auth-go/repository"
+)
+
+var errTokenRequired = errors.New("the token argument is required")
+
+type tokenOutput struct {
+	Token string `json:"token"`
+}
+
+type decodedToken struct {
+	Header jose.Header            `json:"header"`
+	Claims map[string]interface{} `json:"claims"`
+}
+
+type keyOutput struct {
+	Algorithm string `json:"algorithm"`
+	Key       string `json:"key"`
+}
+
+func tokenMint(c *cli, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("token mint", flag.ContinueOnError)
+	username := fs.String("user", "", "username")
+	scope := fs.String("scope", "", "requested scopes (space separated, all the allowed scopes by default)")
+	audience := fs.String("audience", "", "token audience")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	u, err := c.getUser(*username)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.service().ToJWTWithAudience(*u, *scope, *audience)
+	if err != nil {
+		return nil, err
+	}
+	if c.jsonOutput {
+		return tokenOutput{token}, nil
+	}
+	return token, nil
+}
+
+/*
+tokenDecode prints the token header and claims
+without verifying it
+*/
+func tokenDecode(c *cli, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errTokenRequired
+	}
+	t, err := jose.Parse(args[0])
+	if err != nil {
+		return nil, err
+	}
+	d := decodedToken{Header: t.Header}
+	if err := t.Claims(&d.Claims); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+/*
+tokenVerify validates the token like the auth interceptor
+(signature, times, revocation and user)
+*/
+func tokenVerify(c *cli, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errTokenRequired
+	}
+	ctx, err := c.service().AuthenticateContext(context.Background(), args[0], "", nil)
+	if err != nil {
+		return nil, err
+	}
+	claims, _ := auth.ClaimsFromContext(ctx)
+	return claims.Map(), nil
+}
+
+func keysGenerate(c *cli, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	alg := fs.String("alg", jose.HS256, "key algorithm (HS256 or RS256)")
+	bits := fs.Int("bits", 2048, "RSA key size")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	var key string
+	switch *alg {
+	case jose.HS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		// binary secret (see config.ResolveSecret)
+		key = config.SecretBase64Prefix + base64.StdEncoding.EncodeToString(secret)
+	case jose.RS256:
+		k, err := rsa.GenerateKey(rand.Reader, *bits)
+		if err != nil {
+			return nil, err
+		}
+		key = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}))
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", *alg)
+	}
+	if c.jsonOutput {
+		return keyOutput{Algorithm: *alg, Key: key}, nil
+	}
+	return key, nil
+}
+
+/*
+configDump prints the effective configuration
+(secrets are redacted)
+*/
+func configDump(c *cli, args []string) (interface{}, error) {
+	if c.jsonOutput {
+		return config.Settings(), nil
+	}
+	var b strings.Builder
+	if err := config.Dump(&b); err != nil {
+		return nil, err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+/*
+migrate creates/updates the database tables (the
+repository migrates the database when it's created)
+*/
+func migrate(c *cli, args []string) (interface{}, error) {
+	repository.NewRepository()
+	return message{"database migrated"}, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/auth"
+	"github.com/eldius/jwt-auth-go/user"
+)
+
+var errUserRequired = errors.New("the -user flag is required")
+
+func userCreate(c *cli, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	username := fs.String("user", "", "username")
+	pass := fs.String("pass", "", "password")
+	name := fs.String("name", "", "user name")
+	email := fs.String("email", "", "user e-mail")
+	admin := fs.Bool("admin", false, "create an admin user")
+	inactive := fs.Bool("inactive", false, "create an inactive user")
+	profiles := fs.String("profiles", "", "profiles (comma separated)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	u, err := c.service().CreateNewUser(&auth.NewUser{
+		User:   *username,
+		Pass:   *pass,
+		Name:   *name,
+		Email:  *email,
+		Admin:  *admin,
+		Active: !*inactive,
+		Roles:  splitList(*profiles),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u.View(), nil
+}
+
+func userList(c *cli, args []string) (interface{}, error) {
+	users := c.service().GetRepository().ListUSers()
+	views := make([]user.UserView, 0, len(users))
+	for i := range users {
+		views = append(views, users[i].View())
+	}
+	return views, nil
+}
+
+func userActive(name string, active bool) command {
+	return func(c *cli, args []string) (interface{}, error) {
+		u, err := c.findUser(name, args)
+		if err != nil {
+			return nil, err
+		}
+		u.Active = active
+		if err := c.service().GetRepository().SaveUser(u); err != nil {
+			return nil, err
+		}
+		return u.View(), nil
+	}
+}
+
+func userDelete(c *cli, args []string) (interface{}, error) {
+	u, err := c.findUser("user delete", args)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.service().GetRepository().DeleteUser(u); err != nil {
+		return nil, err
+	}
+	return message{fmt.Sprintf("user %s deleted", u.User)}, nil
+}
+
+func userPasswd(c *cli, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("user passwd", flag.ContinueOnError)
+	username := fs.String("user", "", "username")
+	pass := fs.String("pass", "", "new password")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	u, err := c.getUser(*username)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.SetPassword(*pass); err != nil {
+		return nil, err
+	}
+	if err := c.service().GetRepository().SaveUser(u); err != nil {
+		return nil, err
+	}
+	return message{fmt.Sprintf("user %s password changed", u.User)}, nil
+}
+
+func userProfiles(c *cli, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("user profiles", flag.ContinueOnError)
+	username := fs.String("user", "", "username")
+	set := fs.String("set", "", "profiles (comma separated, empty removes all)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	u, err := c.getUser(*username)
+	if err != nil {
+		return nil, err
+	}
+	repo := c.service().GetRepository()
+	profiles := make([]user.Profile, 0)
+	for _, name := range splitList(*set) {
+		p := repo.FindProfile(name)
+		if p == nil {
+			return nil, fmt.Errorf("profile %s not found", name)
+		}
+		profiles = append(profiles, *p)
+	}
+	if err := repo.SetUserProfiles(u, profiles); err != nil {
+		return nil, err
+	}
+	return u.View(), nil
+}
+
+/*
+findUser parses the `-user` flag and finds the user
+*/
+func (c *cli) findUser(name string, args []string) (*user.CredentialInfo, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	username := fs.String("user", "", "username")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return c.getUser(*username)
+}
+
+func (c *cli) getUser(username string) (*user.CredentialInfo, error) {
+	if username == "" {
+		return nil, errUserRequired
+	}
+	u := c.service().GetRepository().FindUser(username)
+	if u == nil {
+		return nil, fmt.Errorf("user %s not found", username)
+	}
+	return u, nil
+}
+
+func splitList(s string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
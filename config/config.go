@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/spf13/viper"
@@ -38,12 +39,102 @@ func GetPasswordPattern() string {
 }
 
 /*
-GetJWTSecret returns the JWT secret to be used
+GetJWTSecret returns the JWT secret to be used (it can
+be a secret reference, see ResolveSecret)
 */
 func GetJWTSecret() string {
 	return viper.GetString("auth.jwt.secret")
 }
 
+/*
+GetJWTRotationGrace returns for how long the rotated secrets
+and keys are still accepted to verify the tokens
+*/
+func GetJWTRotationGrace() time.Duration {
+	return viper.GetDuration("auth.jwt.rotation.grace")
+}
+
+/*
+GetDevMode returns if the development mode is enabled
+(a random JWT secret is generated when none is configured)
+*/
+func GetDevMode() bool {
+	return viper.GetBool("auth.dev.mode")
+}
+
+/*
+GetJWTAlgorithm returns the algorithm used to sign
+the tokens (HS256 or RS256)
+*/
+func GetJWTAlgorithm() string {
+	return viper.GetString("auth.jwt.algorithm")
+}
+
+/*
+GetJWTKeyFile returns the path of the PEM encoded RSA
+private key used to sign RS256 tokens (and OpenID
+Connect ID tokens)
+*/
+func GetJWTKeyFile() string {
+	return viper.GetString("auth.jwt.key.file")
+}
+
+/*
+GetJWTKeyID returns the ID of the RSA signing key
+(the JWT `kid` header)
+*/
+func GetJWTKeyID() string {
+	return viper.GetString("auth.jwt.key.id")
+}
+
+/*
+GetJWTIssuer returns the tokens issuer (it's also the
+OpenID Connect issuer identifier)
+*/
+func GetJWTIssuer() string {
+	return viper.GetString("auth.jwt.issuer")
+}
+
+/*
+GetJWTAudience returns the default tokens
+audience (`aud` claim)
+*/
+func GetJWTAudience() string {
+	return viper.GetString("auth.jwt.audience")
+}
+
+/*
+GetJWTAudiences returns the audiences (services) users
+are allowed to request tokens to when they login
+*/
+func GetJWTAudiences() []string {
+	return viper.GetStringSlice("auth.jwt.audiences")
+}
+
+/*
+GetJWTLeeway returns the clock skew tolerated
+when validating the token times
+*/
+func GetJWTLeeway() time.Duration {
+	return viper.GetDuration("auth.jwt.leeway")
+}
+
+/*
+GetJWTMaxAge returns the maximum token age (since it
+was issued), zero means no limit
+*/
+func GetJWTMaxAge() time.Duration {
+	return viper.GetDuration("auth.jwt.max.age")
+}
+
+/*
+GetJWTRequiredClaims returns the claims every
+token must have (eg: expires)
+*/
+func GetJWTRequiredClaims() []string {
+	return viper.GetStringSlice("auth.jwt.claims.required")
+}
+
 /*
 GetUserDefaultActive returns configuration about
 new users will be created active or inactive
@@ -60,6 +151,295 @@ func GetDefaultJwtTTL() time.Duration {
 	return viper.GetDuration("auth.jwt.ttl")
 }
 
+/*
+GetRegistrationMode returns the user self-registration
+mode (open, invite-only, admin-only or disabled)
+*/
+func GetRegistrationMode() string {
+	return viper.GetString("auth.user.registration.mode")
+}
+
+/*
+GetInvitationTTL returns how long an invitation
+code is valid
+*/
+func GetInvitationTTL() time.Duration {
+	return viper.GetDuration("auth.user.invitation.ttl")
+}
+
+/*
+GetBootstrapAdminUser returns the username of the
+first admin (created only if there is no admin yet)
+*/
+func GetBootstrapAdminUser() string {
+	return viper.GetString("auth.user.bootstrap.user")
+}
+
+/*
+GetBootstrapAdminPass returns the password of the
+first admin
+*/
+func GetBootstrapAdminPass() string {
+	return viper.GetString("auth.user.bootstrap.pass")
+}
+
+/*
+GetEmailVerificationRequired returns true if users
+with an unverified e-mail must not be able to login
+*/
+func GetEmailVerificationRequired() bool {
+	return viper.GetBool("auth.user.email.verification.required")
+}
+
+/*
+GetEmailVerificationTTL returns how long an e-mail
+verification link is valid
+*/
+func GetEmailVerificationTTL() time.Duration {
+	return viper.GetDuration("auth.user.email.verification.ttl")
+}
+
+/*
+GetEmailVerificationURL returns the base URL used to
+build the e-mail verification links
+*/
+func GetEmailVerificationURL() string {
+	return viper.GetString("auth.user.email.verification.url")
+}
+
+/*
+GetMagicLinkEnabled returns true if users can
+login using magic links
+*/
+func GetMagicLinkEnabled() bool {
+	return viper.GetBool("auth.magiclink.enabled")
+}
+
+/*
+GetMagicLinkTTL returns how long a magic link is valid
+*/
+func GetMagicLinkTTL() time.Duration {
+	return viper.GetDuration("auth.magiclink.ttl")
+}
+
+/*
+GetMagicLinkURL returns the base URL used to
+build the magic links
+*/
+func GetMagicLinkURL() string {
+	return viper.GetString("auth.magiclink.url")
+}
+
+/*
+GetMagicLinkBindBrowser returns true if magic links
+must be consumed by the same browser who requested it
+*/
+func GetMagicLinkBindBrowser() bool {
+	return viper.GetBool("auth.magiclink.bind.browser")
+}
+
+/*
+GetCookieEnabled returns true if the login must set
+the token in a cookie (browser session mode)
+*/
+func GetCookieEnabled() bool {
+	return viper.GetBool("auth.cookie.enabled")
+}
+
+/*
+GetCookieName returns the name of the session cookie
+*/
+func GetCookieName() string {
+	return viper.GetString("auth.cookie.name")
+}
+
+/*
+GetCSRFCookieName returns the name of the CSRF cookie
+*/
+func GetCSRFCookieName() string {
+	return viper.GetString("auth.cookie.csrf.name")
+}
+
+/*
+GetCookieDomain returns the domain of the session cookies
+*/
+func GetCookieDomain() string {
+	return viper.GetString("auth.cookie.domain")
+}
+
+/*
+GetCookiePath returns the path of the session cookies
+*/
+func GetCookiePath() string {
+	return viper.GetString("auth.cookie.path")
+}
+
+/*
+GetCookieSecure returns true if the session cookies
+must be sent only over HTTPS
+*/
+func GetCookieSecure() bool {
+	return viper.GetBool("auth.cookie.secure")
+}
+
+/*
+GetCookieSameSite returns the SameSite attribute of the
+session cookies (strict, lax or none)
+*/
+func GetCookieSameSite() string {
+	return viper.GetString("auth.cookie.samesite")
+}
+
+/*
+GetDefaultScopes returns the scopes every user
+is allowed to (besides its profiles scopes)
+*/
+func GetDefaultScopes() []string {
+	return viper.GetStringSlice("auth.jwt.scopes.default")
+}
+
+/*
+GetOAuthCodeTTL returns how long an OAuth
+authorization code is valid
+*/
+func GetOAuthCodeTTL() time.Duration {
+	return viper.GetDuration("auth.oauth.code.ttl")
+}
+
+/*
+GetOAuthRefreshTTL returns how long an OAuth
+refresh token is valid
+*/
+func GetOAuthRefreshTTL() time.Duration {
+	return viper.GetDuration("auth.oauth.refresh.ttl")
+}
+
+/*
+GetOAuthLoginURL returns the login page URL, users not
+logged in are redirected to it by the authorize endpoint
+(the original URL is passed in the `return_to` param)
+*/
+func GetOAuthLoginURL() string {
+	return viper.GetString("auth.oauth.login.url")
+}
+
+/*
+GetOIDCEndpoint returns the path (relative to the issuer)
+or the URL of an OpenID Connect endpoint (authorize,
+token, userinfo or jwks)
+*/
+func GetOIDCEndpoint(name string) string {
+	return viper.GetString("auth.oidc.endpoints." + name)
+}
+
+/*
+GetFederationProviders returns the names of the external
+identity providers (`auth.federation.providers.<name>`)
+*/
+func GetFederationProviders() []string {
+	providers := make([]string, 0)
+	for name := range viper.GetStringMap("auth.federation.providers") {
+		providers = append(providers, name)
+	}
+	return providers
+}
+
+/*
+GetFederationProviderString returns a string config
+of the external identity provider
+*/
+func GetFederationProviderString(provider string, key string) string {
+	return viper.GetString(fmt.Sprintf("auth.federation.providers.%s.%s", provider, key))
+}
+
+/*
+GetFederationProviderStrings returns a list config
+of the external identity provider
+*/
+func GetFederationProviderStrings(provider string, key string) []string {
+	return viper.GetStringSlice(fmt.Sprintf("auth.federation.providers.%s.%s", provider, key))
+}
+
+/*
+GetFederationProviderBool returns a boolean config
+of the external identity provider
+*/
+func GetFederationProviderBool(provider string, key string) bool {
+	return viper.GetBool(fmt.Sprintf("auth.federation.providers.%s.%s", provider, key))
+}
+
+/*
+GetServerAddress returns the address the
+auth server listens to (eg: `:8080`)
+*/
+func GetServerAddress() string {
+	return viper.GetString("auth.server.address")
+}
+
+/*
+GetServerPrefix returns the path prefix of
+the auth server endpoints (eg: `/auth`)
+*/
+func GetServerPrefix() string {
+	return viper.GetString("auth.server.prefix")
+}
+
+/*
+GetServerReadTimeout returns the maximum duration
+for reading the entire request
+*/
+func GetServerReadTimeout() time.Duration {
+	return viper.GetDuration("auth.server.timeout.read")
+}
+
+/*
+GetServerWriteTimeout returns the maximum duration
+before timing out writes of the response
+*/
+func GetServerWriteTimeout() time.Duration {
+	return viper.GetDuration("auth.server.timeout.write")
+}
+
+/*
+GetServerIdleTimeout returns how long keep-alive
+connections wait for the next request
+*/
+func GetServerIdleTimeout() time.Duration {
+	return viper.GetDuration("auth.server.timeout.idle")
+}
+
+/*
+GetServerShutdownTimeout returns how long the server waits
+the active requests to finish when it's shutting down
+*/
+func GetServerShutdownTimeout() time.Duration {
+	return viper.GetDuration("auth.server.timeout.shutdown")
+}
+
+/*
+GetServerMaxBodySize returns the maximum request
+body size (in bytes)
+*/
+func GetServerMaxBodySize() int64 {
+	return viper.GetInt64("auth.server.body.max")
+}
+
+/*
+GetServerTLSCertFile returns the path of the PEM encoded
+TLS certificate (the server uses HTTPS if it's set)
+*/
+func GetServerTLSCertFile() string {
+	return viper.GetString("auth.server.tls.cert")
+}
+
+/*
+GetServerTLSKeyFile returns the path of the PEM
+encoded TLS private key
+*/
+func GetServerTLSKeyFile() string {
+	return viper.GetString("auth.server.tls.key")
+}
+
 /*
 GetLoggerFormat returns the type of log
 */
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/viper"
+)
+
+/*
+EnvPrefix is the prefix of the environment variables, the
+variable name is the prefix followed by the config key in
+upper case with the dots replaced by underscores, eg:
+
+auth.jwt.secret: JWTAUTH_AUTH_JWT_SECRET
+auth.database.url: JWTAUTH_AUTH_DATABASE_URL
+auth.federation.providers.google.client.secret: JWTAUTH_AUTH_FEDERATION_PROVIDERS_GOOGLE_CLIENT_SECRET
+*/
+const EnvPrefix = "JWTAUTH"
+
+const redacted = "[REDACTED]"
+
+// Config value sources (see Dump)
+const (
+	SourceOverride = "override"
+	SourceEnv      = "env"
+	SourceFile     = "file"
+	SourceDefault  = "default"
+	SourceUnset    = "unset"
+)
+
+/*
+defaults are the default values (set by SetDefaults)
+*/
+var defaults = map[string]interface{}{}
+
+/*
+keys are all the known config keys, the keys with a default
+value and the optional ones (both registered by SetDefaults,
+the federation providers keys depend on the provider names)
+*/
+var keys = map[string]bool{}
+
+func setDefault(key string, value interface{}) {
+	keys[key] = true
+	defaults[key] = value
+	viper.SetDefault(key, value)
+}
+
+/*
+setOptional registers the config keys without default value
+*/
+func setOptional(optional ...string) {
+	for _, key := range optional {
+		keys[key] = true
+	}
+}
+
+/*
+Keys returns all the known config keys (sorted)
+*/
+func Keys() []string {
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+/*
+EnvVar returns the environment variable of the config key
+*/
+func EnvVar(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+/*
+BindEnv enables the environment variables (see EnvPrefix),
+all the known keys are bound so they are also returned by
+viper.AllKeys (and Unmarshal)
+*/
+func BindEnv() {
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
+	for key := range keys {
+		_ = viper.BindEnv(key, EnvVar(key))
+	}
+}
+
+/*
+Source returns where the effective value of the config key
+comes from (override, env, file, default or unset)
+*/
+func Source(key string) string {
+	return keySource(strings.ToLower(key), fileKeys())
+}
+
+func keySource(key string, inFile map[string]bool) string {
+	if v, ok := os.LookupEnv(EnvVar(key)); ok && v != "" {
+		return SourceEnv
+	}
+	if inFile[key] {
+		return SourceFile
+	}
+	def, hasDefault := defaults[key]
+	if viper.IsSet(key) && (!hasDefault || !reflect.DeepEqual(viper.Get(key), def)) {
+		return SourceOverride
+	}
+	if hasDefault {
+		return SourceDefault
+	}
+	return SourceUnset
+}
+
+/*
+fileKeys returns the keys set in the config file (viper.InConfig
+only finds the top level keys, so the file is read again)
+*/
+func fileKeys() map[string]bool {
+	inFile := map[string]bool{}
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return inFile
+	}
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return inFile
+	}
+	for _, key := range v.AllKeys() {
+		inFile[key] = true
+	}
+	return inFile
+}
+
+/*
+Setting is a config value (secrets redacted) and its source
+*/
+type Setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+/*
+Settings returns the effective configuration (all the known and
+configured keys sorted) with the source of each value. Secret values
+are redacted, but secret references (`file://` and `env://`) are kept.
+*/
+func Settings() []Setting {
+	all := map[string]bool{}
+	for key := range keys {
+		all[key] = true
+	}
+	for _, key := range viper.AllKeys() {
+		all[key] = true
+	}
+	sorted := make([]string, 0, len(all))
+	for key := range all {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	inFile := fileKeys()
+	settings := make([]Setting, 0, len(sorted))
+	for _, key := range sorted {
+		source := keySource(key, inFile)
+		if source == SourceEnv {
+			source = fmt.Sprintf("%s (%s)", source, EnvVar(key))
+		} else if source == SourceFile {
+			source = fmt.Sprintf("%s (%s)", source, viper.ConfigFileUsed())
+		}
+		settings = append(settings, Setting{Key: key, Value: dumpValue(key), Source: source})
+	}
+	return settings
+}
+
+/*
+Dump prints the effective configuration (see Settings)
+*/
+func Dump(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, s := range Settings() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Key, s.Value, s.Source)
+	}
+	return tw.Flush()
+}
+
+func dumpValue(key string) string {
+	value := viper.Get(key)
+	if value == nil {
+		return ""
+	}
+	s := fmt.Sprint(value)
+	if s == "" || !isSecretKey(key) {
+		return s
+	}
+	if strings.HasPrefix(s, SecretFilePrefix) || strings.HasPrefix(s, SecretEnvPrefix) {
+		return s
+	}
+	return redacted
+}
+
+/*
+isSecretKey returns if the key holds a secret (secrets,
+passwords and the database url, it can have credentials)
+*/
+func isSecretKey(key string) bool {
+	for _, suffix := range []string{".secret", ".pass", ".password", "auth.database.url"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func setupTestViper(t *testing.T, content string) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	SetDefaults()
+	BindEnv()
+	if content == "" {
+		return
+	}
+	file := filepath.Join(t.TempDir(), "auth-server.yml")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Errorf("Failed to write file: %s", err.Error())
+		t.FailNow()
+	}
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Errorf("Failed to read config file: %s", err.Error())
+		t.FailNow()
+	}
+}
+
+func TestKeys(t *testing.T) {
+	setupTestViper(t, "")
+	all := Keys()
+	if !sort.StringsAreSorted(all) {
+		t.Errorf("Keys should be sorted, but was '%v'", all)
+	}
+	known := map[string]bool{}
+	for _, key := range all {
+		known[key] = true
+	}
+	for key := range defaults {
+		if !known[key] {
+			t.Errorf("Keys should return the key '%s' (it has a default value)", key)
+		}
+	}
+	for _, key := range []string{"auth.jwt.secret", "auth.database.url", "app.log.format"} {
+		if !known[key] {
+			t.Errorf("Keys should return the optional key '%s'", key)
+		}
+	}
+}
+
+func TestEnvVar(t *testing.T) {
+	for key, env := range map[string]string{
+		"auth.jwt.secret":   "JWTAUTH_AUTH_JWT_SECRET",
+		"auth.database.url": "JWTAUTH_AUTH_DATABASE_URL",
+		"auth.federation.providers.my-idp.client.secret": "JWTAUTH_AUTH_FEDERATION_PROVIDERS_MY_IDP_CLIENT_SECRET",
+	} {
+		if v := EnvVar(key); v != env {
+			t.Errorf("%s: should return '%s', but was '%s'", key, env, v)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	setupTestViper(t, "auth:\n  jwt:\n    issuer: https://file.example.com\n  cookie:\n    name: file-cookie\n")
+	t.Setenv(EnvVar("auth.cookie.name"), "env-cookie")
+	t.Setenv(EnvVar("auth.jwt.audience"), "env-audience")
+	viper.Set("auth.magiclink.url", "https://override.example.com")
+	viper.Set("auth.cookie.path", "/")
+
+	for key, source := range map[string]string{
+		"auth.cookie.name":   SourceEnv,
+		"auth.jwt.audience":  SourceEnv,
+		"auth.jwt.issuer":    SourceFile,
+		"auth.magiclink.url": SourceOverride,
+		"auth.cookie.path":   SourceDefault,
+		"auth.jwt.ttl":       SourceDefault,
+		"auth.jwt.secret":    SourceUnset,
+	} {
+		if s := Source(key); s != source {
+			t.Errorf("%s: should come from '%s', but was '%s'", key, source, s)
+		}
+	}
+	if v := viper.GetString("auth.cookie.name"); v != "env-cookie" {
+		t.Errorf("Environment variables should override the config file, but was '%s'", v)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	setupTestViper(t, "auth:\n  jwt:\n    issuer: https://file.example.com\n  custom: value\n")
+	t.Setenv(EnvVar("auth.jwt.audience"), "env-audience")
+
+	settings := Settings()
+	values := map[string]Setting{}
+	for i, s := range settings {
+		if i > 0 && settings[i-1].Key >= s.Key {
+			t.Errorf("Settings should be sorted, but '%s' comes after '%s'", s.Key, settings[i-1].Key)
+		}
+		values[s.Key] = s
+	}
+	for _, key := range Keys() {
+		if _, ok := values[key]; !ok {
+			t.Errorf("Settings should return the known key '%s'", key)
+		}
+	}
+	for key, want := range map[string]Setting{
+		"auth.custom":       {Value: "value", Source: SourceFile + " (" + viper.ConfigFileUsed() + ")"},
+		"auth.jwt.issuer":   {Value: "https://file.example.com", Source: SourceFile + " (" + viper.ConfigFileUsed() + ")"},
+		"auth.jwt.audience": {Value: "env-audience", Source: SourceEnv + " (JWTAUTH_AUTH_JWT_AUDIENCE)"},
+		"auth.cookie.name":  {Value: "jwt-auth-token", Source: SourceDefault},
+		"auth.jwt.secret":   {Value: "", Source: SourceUnset},
+	} {
+		want.Key = key
+		if s := values[key]; s != want {
+			t.Errorf("%s: should be '%v', but was '%v'", key, want, s)
+		}
+	}
+}
+
+func TestDumpRedactsSecrets(t *testing.T) {
+	setupTestViper(t, "")
+	viper.Set("auth.jwt.secret", "jwt-secret")
+	viper.Set("auth.user.bootstrap.pass", "admin-pass")
+	viper.Set("auth.database.url", "user:db-pass@tcp(localhost)/auth")
+	viper.Set("auth.federation.providers.idp.client.secret", SecretFilePrefix+"/run/secrets/idp")
+	viper.Set("auth.federation.providers.other.client.secret", SecretEnvPrefix+"OTHER_SECRET")
+
+	var out bytes.Buffer
+	if err := Dump(&out); err != nil {
+		t.Errorf("Failed to dump config: %s", err.Error())
+		t.FailNow()
+	}
+	dump := out.String()
+	for _, secret := range []string{"jwt-secret", "admin-pass", "db-pass"} {
+		if strings.Contains(dump, secret) {
+			t.Errorf("Dump should redact '%s', but was:\n%s", secret, dump)
+		}
+	}
+	for _, ref := range []string{SecretFilePrefix + "/run/secrets/idp", SecretEnvPrefix + "OTHER_SECRET", "KEY", "auth.cookie.name"} {
+		if !strings.Contains(dump, ref) {
+			t.Errorf("Dump should contain '%s', but was:\n%s", ref, dump)
+		}
+	}
+	if strings.Count(dump, redacted) != 3 {
+		t.Errorf("Dump should redact 3 values, but was:\n%s", dump)
+	}
+}
+
+func TestIsSecretKey(t *testing.T) {
+	for key, secret := range map[string]bool{
+		"auth.jwt.secret":                             true,
+		"auth.user.bootstrap.pass":                    true,
+		"auth.federation.providers.idp.client.secret": true,
+		"auth.smtp.password":                          true,
+		"auth.database.url":                           true,
+		"auth.pass.pattern":                           false,
+		"auth.jwt.secret.file":                        false,
+		"auth.jwt.issuer":                             false,
+	} {
+		if isSecretKey(key) != secret {
+			t.Errorf("%s: isSecretKey should be %v", key, secret)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Secret reference prefixes
+const (
+	SecretFilePrefix   = "file://"
+	SecretEnvPrefix    = "env://"
+	SecretBase64Prefix = "base64:"
+)
+
+/*
+ErrInvalidSecret is returned when a secret reference
+can't be resolved
+*/
+var ErrInvalidSecret = errors.New("auth.config.secret.invalid")
+
+/*
+ResolveSecret resolves a secret reference:
+
+`file:///run/secrets/jwt` reads the file (trailing line breaks are removed)
+`env://JWT_SECRET` reads the environment variable
+`base64:<value>` decodes binary secrets (it can also be the content of
+the file or of the environment variable)
+
+Other values are returned as they are.
+*/
+func ResolveSecret(ref string) ([]byte, error) {
+	value := ref
+	switch {
+	case strings.HasPrefix(ref, SecretFilePrefix):
+		b, err := ioutil.ReadFile(SecretFile(ref))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSecret, err)
+		}
+		value = strings.TrimRight(string(b), "\r\n")
+	case strings.HasPrefix(ref, SecretEnvPrefix):
+		name := strings.TrimPrefix(ref, SecretEnvPrefix)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: environment variable %s is not set", ErrInvalidSecret, name)
+		}
+		value = v
+	}
+	if strings.HasPrefix(value, SecretBase64Prefix) {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, SecretBase64Prefix))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSecret, err)
+		}
+		return b, nil
+	}
+	return []byte(value), nil
+}
+
+/*
+SecretFile returns the path of `file://` secret
+references (empty for other references)
+*/
+func SecretFile(ref string) string {
+	if !strings.HasPrefix(ref, SecretFilePrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(ref, SecretFilePrefix)
+}
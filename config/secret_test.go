@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	binary := []byte{0, 1, 2, 254, 255}
+	encoded := SecretBase64Prefix + base64.StdEncoding.EncodeToString(binary)
+	for name, content := range map[string]string{
+		"secret": "file-secret\r\n\n",
+		"binary": encoded + "\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Errorf("Failed to write file: %s", err.Error())
+			t.FailNow()
+		}
+	}
+	t.Setenv("JWT_AUTH_TEST_SECRET", "env-secret")
+	t.Setenv("JWT_AUTH_TEST_BINARY", encoded)
+	t.Setenv("JWT_AUTH_TEST_EMPTY", "")
+
+	for _, test := range []struct {
+		ref    string
+		secret string
+	}{
+		{SecretFilePrefix + filepath.Join(dir, "secret"), "file-secret"},
+		{SecretFilePrefix + filepath.Join(dir, "binary"), string(binary)},
+		{SecretEnvPrefix + "JWT_AUTH_TEST_SECRET", "env-secret"},
+		{SecretEnvPrefix + "JWT_AUTH_TEST_BINARY", string(binary)},
+		{SecretEnvPrefix + "JWT_AUTH_TEST_EMPTY", ""},
+		{encoded, string(binary)},
+		{"plain-secret", "plain-secret"},
+		{"", ""},
+	} {
+		secret, err := ResolveSecret(test.ref)
+		if err != nil || string(secret) != test.secret {
+			t.Errorf("%s: should resolve to '%v', but was '%v' (%v)", test.ref, []byte(test.secret), secret, err)
+		}
+	}
+}
+
+func TestResolveSecretErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "invalid"), []byte(SecretBase64Prefix+"not base64!"), 0600); err != nil {
+		t.Errorf("Failed to write file: %s", err.Error())
+		t.FailNow()
+	}
+	t.Setenv("JWT_AUTH_TEST_INVALID", SecretBase64Prefix+"not base64!")
+
+	for _, ref := range []string{
+		SecretFilePrefix + filepath.Join(dir, "missing"),
+		SecretFilePrefix + dir,
+		SecretFilePrefix + filepath.Join(dir, "invalid"),
+		SecretEnvPrefix + "JWT_AUTH_TEST_MISSING",
+		SecretEnvPrefix + "JWT_AUTH_TEST_INVALID",
+		SecretBase64Prefix + "not base64!",
+	} {
+		if secret, err := ResolveSecret(ref); !errors.Is(err, ErrInvalidSecret) || secret != nil {
+			t.Errorf("%s: should be rejected, but was '%v' (%v)", ref, secret, err)
+		}
+	}
+}
+
+func TestSecretFile(t *testing.T) {
+	for ref, file := range map[string]string{
+		"file:///run/secrets/jwt": "/run/secrets/jwt",
+		"env://JWT_SECRET":        "",
+		"base64:c2VjcmV0":         "",
+		"secret":                  "",
+	} {
+		if f := SecretFile(ref); f != file {
+			t.Errorf("%s: should return '%s', but was '%s'", ref, file, f)
+		}
+	}
+}
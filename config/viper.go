@@ -5,7 +5,7 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/google/uuid"
+	"github.com/fsnotify/fsnotify"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 )
@@ -16,22 +16,115 @@ Default values:
 
 auth.user.pattern: `^[a-zA-Z0-9\\._-]*$`
 auth.pass.pattern: `^[a-zA-Z0-9\\._-]*$`
-auth.jwt.secret: `uuid.New().String()`
+auth.dev.mode: false
+auth.jwt.rotation.grace: 1h
 auth.user.default.active: true
 auth.jwt.ttl: 3600s
+auth.user.registration.mode: open
+auth.user.invitation.ttl: 72h
+auth.user.email.verification.required: false
+auth.user.email.verification.ttl: 24h
+auth.magiclink.enabled: false
+auth.magiclink.ttl: 10m
+auth.magiclink.bind.browser: false
+auth.cookie.enabled: false
+auth.cookie.name: jwt-auth-token
+auth.cookie.csrf.name: jwt-auth-csrf
+auth.cookie.path: /
+auth.cookie.secure: true
+auth.cookie.samesite: strict
+auth.oauth.code.ttl: 60s
+auth.oauth.refresh.ttl: 720h
+auth.jwt.algorithm: HS256
+auth.jwt.scopes.default: []
+auth.jwt.audiences: []
+auth.jwt.leeway: 0s
+auth.jwt.max.age: 0s
+auth.jwt.claims.required: [expires]
+auth.oidc.endpoints.authorize: /authorize
+auth.oidc.endpoints.token: /token
+auth.oidc.endpoints.userinfo: /userinfo
+auth.oidc.endpoints.jwks: /.well-known/jwks.json
+auth.oidc.endpoints.introspect: /introspect
+auth.oidc.endpoints.revoke: /revoke
+auth.server.address: :8080
+auth.server.prefix: ""
+auth.server.timeout.read: 10s
+auth.server.timeout.write: 10s
+auth.server.timeout.idle: 60s
+auth.server.timeout.shutdown: 30s
+auth.server.body.max: 1048576
+
+The other known keys (eg: auth.jwt.secret) have no default
+value, they're registered as optional (see Keys)
 */
 func SetDefaults() {
 	//viper.SetDefault("auth.database.url", "test.db")
 	//viper.SetDefault("auth.database.engine", "sqlite3")
-	viper.SetDefault("auth.user.pattern", "^[a-zA-Z0-9\\._-]*$")
-	viper.SetDefault("auth.pass.pattern", "^[a-zA-Z0-9\\._-]*$")
-	viper.SetDefault("auth.jwt.secret", uuid.New().String())
-	viper.SetDefault("auth.user.default.active", true)
-	viper.SetDefault("auth.jwt.ttl", "3600s")
+	setDefault("auth.user.pattern", "^[a-zA-Z0-9\\._-]*$")
+	setDefault("auth.pass.pattern", "^[a-zA-Z0-9\\._-]*$")
+	setDefault("auth.dev.mode", false)
+	setDefault("auth.jwt.rotation.grace", "1h")
+	setDefault("auth.user.default.active", true)
+	setDefault("auth.jwt.ttl", "3600s")
+	setDefault("auth.user.registration.mode", "open")
+	setDefault("auth.user.invitation.ttl", "72h")
+	setDefault("auth.user.email.verification.required", false)
+	setDefault("auth.user.email.verification.ttl", "24h")
+	setDefault("auth.magiclink.enabled", false)
+	setDefault("auth.magiclink.ttl", "10m")
+	setDefault("auth.magiclink.bind.browser", false)
+	setDefault("auth.cookie.enabled", false)
+	setDefault("auth.cookie.name", "jwt-auth-token")
+	setDefault("auth.cookie.csrf.name", "jwt-auth-csrf")
+	setDefault("auth.cookie.path", "/")
+	setDefault("auth.cookie.secure", true)
+	setDefault("auth.cookie.samesite", "strict")
+	setDefault("auth.oauth.code.ttl", "60s")
+	setDefault("auth.oauth.refresh.ttl", "720h")
+	setDefault("auth.jwt.algorithm", "HS256")
+	setDefault("auth.jwt.scopes.default", []string{})
+	setDefault("auth.jwt.audiences", []string{})
+	setDefault("auth.jwt.leeway", "0s")
+	setDefault("auth.jwt.max.age", "0s")
+	setDefault("auth.jwt.claims.required", []string{"expires"})
+	setDefault("auth.oidc.endpoints.authorize", "/authorize")
+	setDefault("auth.oidc.endpoints.token", "/token")
+	setDefault("auth.oidc.endpoints.userinfo", "/userinfo")
+	setDefault("auth.oidc.endpoints.jwks", "/.well-known/jwks.json")
+	setDefault("auth.oidc.endpoints.introspect", "/introspect")
+	setDefault("auth.oidc.endpoints.revoke", "/revoke")
+	setDefault("auth.server.address", ":8080")
+	setDefault("auth.server.prefix", "")
+	setDefault("auth.server.timeout.read", "10s")
+	setDefault("auth.server.timeout.write", "10s")
+	setDefault("auth.server.timeout.idle", "60s")
+	setDefault("auth.server.timeout.shutdown", "30s")
+	setDefault("auth.server.body.max", 1048576)
+	setOptional(
+		"app.log.format",
+		"auth.cookie.domain",
+		"auth.database.engine",
+		"auth.database.log",
+		"auth.database.url",
+		"auth.jwt.audience",
+		"auth.jwt.issuer",
+		"auth.jwt.key.file",
+		"auth.jwt.key.id",
+		"auth.jwt.secret",
+		"auth.magiclink.url",
+		"auth.oauth.login.url",
+		"auth.server.tls.cert",
+		"auth.server.tls.key",
+		"auth.user.bootstrap.pass",
+		"auth.user.bootstrap.user",
+		"auth.user.email.verification.url",
+	)
 }
 
 /*
-SetupViper sets up library
+SetupViper sets up library (the config keys can also be
+set by environment variables, see BindEnv)
 */
 func SetupViper(cfgFile string) {
 	if cfgFile != "" {
@@ -41,7 +134,7 @@ func SetupViper(cfgFile string) {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 
@@ -50,10 +143,21 @@ func SetupViper(cfgFile string) {
 		viper.SetConfigType("yml")
 	}
 	SetDefaults()
-	viper.AutomaticEnv() // read in environment variables that match
+	BindEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+/*
+WatchConfig watches the config file (using fsnotify), the
+changes are loaded and then onChange is called
+*/
+func WatchConfig(onChange func()) {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		onChange()
+	})
+	viper.WatchConfig()
+}
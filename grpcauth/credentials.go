@@ -0,0 +1,113 @@
+package grpcauth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// tokens are refreshed a bit before they expire, so
+// they don't expire in the middle of the call
+const defaultRefreshBefore = 30 * time.Second
+
+/*
+TokenSource returns a new token and its expiration
+(zero if the token doesn't expire)
+*/
+type TokenSource func(ctx context.Context) (token string, expiresAt time.Time, err error)
+
+/*
+StaticToken is a TokenSource of a token that
+doesn't change (eg: an API key)
+*/
+func StaticToken(token string) TokenSource {
+	return func(ctx context.Context) (string, time.Time, error) {
+		return token, time.Time{}, nil
+	}
+}
+
+/*
+Credentials are the client PerRPCCredentials, they attach
+the token to the calls requesting a new one from the
+source when it's about to expire
+*/
+type Credentials struct {
+	source        TokenSource
+	refreshBefore time.Duration
+	insecure      bool
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+var _ credentials.PerRPCCredentials = (*Credentials)(nil)
+
+/*
+CredentialsOption customizes the Credentials
+*/
+type CredentialsOption func(*Credentials)
+
+/*
+WithRefreshBefore sets how long before the token
+expiration a new one is requested (default 30s)
+*/
+func WithRefreshBefore(d time.Duration) CredentialsOption {
+	return func(c *Credentials) {
+		c.refreshBefore = d
+	}
+}
+
+/*
+WithInsecureTransport allows sending the token over
+connections without TLS (local development only)
+*/
+func WithInsecureTransport() CredentialsOption {
+	return func(c *Credentials) {
+		c.insecure = true
+	}
+}
+
+/*
+NewCredentials creates the client credentials, use it with
+the `grpc.WithPerRPCCredentials` dial option
+*/
+func NewCredentials(source TokenSource, opts ...CredentialsOption) *Credentials {
+	c := &Credentials{
+		source:        source,
+		refreshBefore: defaultRefreshBefore,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+/*
+GetRequestMetadata returns the authorization metadata
+*/
+func (c *Credentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == "" || (!c.expiresAt.IsZero() && time.Now().Add(c.refreshBefore).After(c.expiresAt)) {
+		token, expiresAt, err := c.source(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.token = token
+		c.expiresAt = expiresAt
+	}
+	return map[string]string{
+		AuthorizationKey: "Bearer " + c.token,
+	}, nil
+}
+
+/*
+RequireTransportSecurity returns true unless the
+WithInsecureTransport option is used
+*/
+func (c *Credentials) RequireTransportSecurity() bool {
+	return !c.insecure
+}
@@ -0,0 +1,132 @@
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/auth"
+	"github.com/eldius/jwt-auth-go/repository"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestService(t *testing.T) (*auth.Service, string) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Errorf("Failed to open test database: %s", err.Error())
+		t.FailNow()
+	}
+	cfg := auth.LoadConfig()
+	cfg.Secret = "grpc-test-secret"
+	svc, err := auth.NewServiceWithConfig(cfg, repository.NewRepositoryCustom(db))
+	if err != nil {
+		t.Errorf("Failed to create service: %s", err.Error())
+		t.FailNow()
+	}
+	u, err := svc.CreateNewUser(&auth.NewUser{User: "grpc.user", Pass: "pass", Active: true})
+	if err != nil {
+		t.Errorf("Failed to create test user: %s", err.Error())
+		t.FailNow()
+	}
+	jwt, err := svc.ToJWT(*u)
+	if err != nil {
+		t.Errorf("Failed to create JWT string: %s", err.Error())
+		t.FailNow()
+	}
+	return svc, jwt
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	svc, jwt := newTestService(t)
+	interceptor := UnaryServerInterceptor(svc)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		u, ok := auth.UserFromContext(ctx)
+		if !ok {
+			return nil, errors.New("user not found")
+		}
+		return u.User, nil
+	}
+
+	for _, test := range []struct {
+		name string
+		md   metadata.MD
+		code codes.Code
+	}{
+		{"valid token", metadata.Pairs(AuthorizationKey, "Bearer "+jwt), codes.OK},
+		{"missing token", metadata.MD{}, codes.Unauthenticated},
+		{"invalid token", metadata.Pairs(AuthorizationKey, "Bearer invalid"), codes.Unauthenticated},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), test.md)
+		res, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Call"}, handler)
+		if status.Code(err) != test.code {
+			t.Errorf("%s: should return '%s', but was '%v'", test.name, test.code, err)
+		}
+		if test.code == codes.OK && res != "grpc.user" {
+			t.Errorf("%s: should add the user to the context, but was '%v'", test.name, res)
+		}
+	}
+
+	interceptor = UnaryServerInterceptor(svc, auth.WithAudiences("billing"))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationKey, "Bearer "+jwt))
+	if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Should deny tokens to other audiences, but was '%v'", err)
+	}
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	svc, jwt := newTestService(t)
+	var user string
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		if u, ok := auth.UserFromContext(ss.Context()); ok {
+			user = u.User
+		}
+		return nil
+	}
+	ss := &testStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationKey, "Bearer "+jwt))}
+	if err := StreamServerInterceptor(svc)(nil, ss, &grpc.StreamServerInfo{}, handler); err != nil || user != "grpc.user" {
+		t.Errorf("Should add the user to the stream context, but was '%s' '%v'", user, err)
+	}
+}
+
+func TestCredentialsRefresh(t *testing.T) {
+	calls := 0
+	expiresAt := time.Now().Add(10 * time.Second)
+	c := NewCredentials(func(ctx context.Context) (string, time.Time, error) {
+		calls++
+		return "token", expiresAt, nil
+	}, WithRefreshBefore(time.Second))
+
+	for i := 0; i < 2; i++ {
+		md, err := c.GetRequestMetadata(context.Background())
+		if err != nil || md[AuthorizationKey] != "Bearer token" {
+			t.Errorf("Should return the token metadata, but was '%v' '%v'", md, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Should reuse the token, but it was requested %d times", calls)
+	}
+	c.refreshBefore = time.Minute
+	_, _ = c.GetRequestMetadata(context.Background())
+	if calls != 2 {
+		t.Errorf("Should refresh tokens about to expire, but it was requested %d times", calls)
+	}
+	if !c.RequireTransportSecurity() || NewCredentials(StaticToken("key"), WithInsecureTransport()).RequireTransportSecurity() {
+		t.Errorf("Should require transport security by default")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Package grpcauth authenticates gRPC calls with the
+tokens (JWT or API keys) issued by the auth service
+*/
+package grpcauth
+
+import (
+	"context"
+	"crypto/x509"
+	"log"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AuthorizationKey is the metadata key of the bearer token
+	AuthorizationKey = "authorization"
+	// APIKeyKey is the metadata key of the API keys (they
+	// are accepted as bearer tokens too)
+	APIKeyKey = "x-api-key"
+)
+
+// error codes rejected with PermissionDenied (the token
+// is valid, but it's not allowed to call the service)
+var permissionDenied = []string{
+	"auth.jwt.validation.issuer.invalid",
+	"auth.jwt.validation.audience.invalid",
+	"auth.jwt.validation.subject.invalid",
+}
+
+/*
+UnaryServerInterceptor validates the call token like the
+AuthInterceptor does and adds the user, the claims and the
+token into the call context (they are available through
+auth.UserFromContext, auth.ClaimsFromContext...)
+*/
+func UnaryServerInterceptor(s *auth.Service, opts ...auth.InterceptorOption) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, s, opts)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+/*
+StreamServerInterceptor is the UnaryServerInterceptor
+for streaming calls
+*/
+func StreamServerInterceptor(s *auth.Service, opts ...auth.InterceptorOption) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), s, opts)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+/*
+serverStream replaces the stream context
+*/
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+/*
+authenticate validates the metadata token mapping the errors
+to the Unauthenticated and PermissionDenied codes
+*/
+func authenticate(ctx context.Context, s *auth.Service, opts []auth.InterceptorOption) (context.Context, error) {
+	var remoteAddr string
+	var cert *x509.Certificate
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			remoteAddr = p.Addr.String()
+		}
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			cert = info.State.PeerCertificates[0]
+		}
+	}
+	ctx, err := s.AuthenticateContext(ctx, tokenFromMetadata(ctx), remoteAddr, cert, opts...)
+	if err != nil {
+		log.Println(err.Error())
+		for _, code := range permissionDenied {
+			if err.Error() == code {
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return ctx, nil
+}
+
+/*
+tokenFromMetadata returns the API key or the bearer token
+*/
+func tokenFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if keys := md.Get(APIKeyKey); len(keys) > 0 && keys[0] != "" {
+		return keys[0]
+	}
+	for _, v := range md.Get(AuthorizationKey) {
+		if len(v) > 7 && strings.EqualFold(v[:7], "Bearer ") {
+			return v[7:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,247 @@
+/*
+Package jose implements the small subset of JOSE (JWS, JWK)
+used by the library: compact serialized tokens signed with
+HS256 or RS256 and JSON Web Key Sets.
+*/
+package jose
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+)
+
+// Supported algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+var (
+	// ErrInvalidFormat is returned when the token is not a compact serialized JWS
+	ErrInvalidFormat = errors.New("jose.token.format.invalid")
+	// ErrInvalidSignature is returned when the token signature doesn't match
+	ErrInvalidSignature = errors.New("jose.token.sign.invalid")
+	// ErrUnsupportedAlgorithm is returned for algorithms other than HS256 and RS256
+	ErrUnsupportedAlgorithm = errors.New("jose.algorithm.unsupported")
+	// ErrKeyNotFound is returned when there is no key to verify the token
+	ErrKeyNotFound = errors.New("jose.key.not.found")
+)
+
+/*
+Header is the JWS header
+*/
+type Header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+/*
+Key is a signing/verification key
+*/
+type Key struct {
+	ID        string
+	Algorithm string
+	Secret    []byte
+	Private   *rsa.PrivateKey
+	Public    *rsa.PublicKey
+}
+
+/*
+Token is a parsed (not yet verified) token
+*/
+type Token struct {
+	Header       Header
+	Payload      []byte
+	signingInput string
+	signature    []byte
+}
+
+/*
+NewHMACKey creates a HS256 key
+*/
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:        id,
+		Algorithm: HS256,
+		Secret:    secret,
+	}
+}
+
+/*
+NewRSAKey creates a RS256 key (private can be nil for
+verification only keys)
+*/
+func NewRSAKey(id string, private *rsa.PrivateKey, public *rsa.PublicKey) *Key {
+	if public == nil && private != nil {
+		public = &private.PublicKey
+	}
+	return &Key{
+		ID:        id,
+		Algorithm: RS256,
+		Private:   private,
+		Public:    public,
+	}
+}
+
+/*
+ParseRSAPrivateKeyPEM parses a PEM encoded RSA private
+key (PKCS #1 or PKCS #8)
+*/
+func ParseRSAPrivateKeyPEM(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("jose.key.pem.invalid")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rk, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("jose.key.pem.not.rsa")
+	}
+	return rk, nil
+}
+
+/*
+Sign serializes the claims to JSON and signs them,
+returning the compact serialized token
+*/
+func Sign(claims interface{}, key *Key) (string, error) {
+	header, err := json.Marshal(&Header{
+		Alg: key.Algorithm,
+		Typ: "JWT",
+		Kid: key.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := Encode(header) + "." + Encode(payload)
+	sig, err := sign(signingInput, key)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + Encode(sig), nil
+}
+
+/*
+Parse parses the compact serialized token (it does
+not verify the signature)
+*/
+func Parse(token string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidFormat
+	}
+	header, err := Decode(parts[0])
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	payload, err := Decode(parts[1])
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	sig, err := Decode(parts[2])
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	t := &Token{
+		Payload:      payload,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    sig,
+	}
+	if err := json.Unmarshal(header, &t.Header); err != nil {
+		return nil, ErrInvalidFormat
+	}
+	return t, nil
+}
+
+/*
+Verify verifies the token signature using the key (the
+token algorithm must match the key algorithm)
+*/
+func (t *Token) Verify(key *Key) error {
+	if key == nil {
+		return ErrKeyNotFound
+	}
+	if t.Header.Alg != key.Algorithm {
+		return ErrInvalidSignature
+	}
+	switch key.Algorithm {
+	case HS256:
+		if !hmac.Equal(t.signature, hmacSHA256(t.signingInput, key.Secret)) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case RS256:
+		if key.Public == nil {
+			return ErrKeyNotFound
+		}
+		h := sha256.Sum256([]byte(t.signingInput))
+		if err := rsa.VerifyPKCS1v15(key.Public, crypto.SHA256, h[:], t.signature); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+}
+
+/*
+Claims decodes the token payload into v
+*/
+func (t *Token) Claims(v interface{}) error {
+	return json.Unmarshal(t.Payload, v)
+}
+
+/*
+Encode encodes using the unpadded base64url encoding
+*/
+func Encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+/*
+Decode decodes unpadded base64url encoded values
+*/
+func Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func sign(signingInput string, key *Key) ([]byte, error) {
+	switch key.Algorithm {
+	case HS256:
+		return hmacSHA256(signingInput, key.Secret), nil
+	case RS256:
+		if key.Private == nil {
+			return nil, ErrKeyNotFound
+		}
+		h := sha256.Sum256([]byte(signingInput))
+		return rsa.SignPKCS1v15(rand.Reader, key.Private, crypto.SHA256, h[:])
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+func hmacSHA256(content string, secret []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	_, _ = h.Write([]byte(content))
+	return h.Sum(nil)
+}
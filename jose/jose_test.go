@@ -0,0 +1,89 @@
+package jose
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+)
+
+func TestSignVerifyHS256(t *testing.T) {
+	key := NewHMACKey("", []byte("secret"))
+	token, err := Sign(map[string]string{"user": "user1"}, key)
+	if err != nil {
+		t.Errorf("Failed to sign token: %s", err.Error())
+		t.FailNow()
+	}
+
+	parsed, err := Parse(token)
+	if err != nil {
+		t.Errorf("Failed to parse token: %s", err.Error())
+		t.FailNow()
+	}
+	if err := parsed.Verify(key); err != nil {
+		t.Errorf("Failed to verify token: %s", err.Error())
+	}
+	if err := parsed.Verify(NewHMACKey("", []byte("another"))); err != ErrInvalidSignature {
+		t.Errorf("Should not verify with another key, but returned '%v'", err)
+	}
+
+	var claims map[string]string
+	if err := parsed.Claims(&claims); err != nil || claims["user"] != "user1" {
+		t.Errorf("Failed to decode claims: '%v' '%v'", claims, err)
+	}
+}
+
+func TestSignVerifyRS256(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Errorf("Failed to generate key: %s", err.Error())
+		t.FailNow()
+	}
+	key := NewRSAKey("key-1", pk, nil)
+	token, err := Sign(map[string]string{"user": "user1"}, key)
+	if err != nil {
+		t.Errorf("Failed to sign token: %s", err.Error())
+		t.FailNow()
+	}
+
+	jwk, ok := key.PublicJWK()
+	if !ok {
+		t.Error("Should return the public JWK")
+		t.FailNow()
+	}
+	b, _ := json.Marshal(JWKS{Keys: []JWK{jwk}})
+	var jwks JWKS
+	_ = json.Unmarshal(b, &jwks)
+
+	parsed, err := Parse(token)
+	if err != nil {
+		t.Errorf("Failed to parse token: %s", err.Error())
+		t.FailNow()
+	}
+	if parsed.Header.Kid != "key-1" {
+		t.Errorf("Should carry the key ID, but was '%s'", parsed.Header.Kid)
+	}
+	pub, err := jwks.Find(parsed.Header.Kid)
+	if err != nil {
+		t.Errorf("Failed to find key: %s", err.Error())
+		t.FailNow()
+	}
+	if err := parsed.Verify(pub); err != nil {
+		t.Errorf("Failed to verify token: %s", err.Error())
+	}
+
+	// HS256 tokens signed with the public key must be rejected
+	forged, _ := Sign(map[string]string{"user": "admin"}, NewHMACKey("key-1", pub.Public.N.Bytes()))
+	parsed, _ = Parse(forged)
+	if err := parsed.Verify(pub); err == nil {
+		t.Error("Should not accept algorithm confusion")
+	}
+}
+
+func TestParseInvalidFormat(t *testing.T) {
+	for _, token := range []string{"", "abc", "a.b", "a.b.c.d", "!!.e30.e30"} {
+		if _, err := Parse(token); err != ErrInvalidFormat {
+			t.Errorf("Should return invalid format for '%s', but returned '%v'", token, err)
+		}
+	}
+}
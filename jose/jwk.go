@@ -0,0 +1,84 @@
+package jose
+
+import (
+	"crypto/rsa"
+	"errors"
+	"math/big"
+)
+
+/*
+JWK is a JSON Web Key (only public RSA keys are supported)
+*/
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+/*
+JWKS is a JSON Web Key Set
+*/
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+/*
+PublicJWK returns the public JWK of the key (symmetric
+keys have no public representation)
+*/
+func (k *Key) PublicJWK() (JWK, bool) {
+	if k.Algorithm != RS256 || k.Public == nil {
+		return JWK{}, false
+	}
+	return JWK{
+		Kty: "RSA",
+		Kid: k.ID,
+		Use: "sig",
+		Alg: RS256,
+		N:   Encode(k.Public.N.Bytes()),
+		E:   Encode(big.NewInt(int64(k.Public.E)).Bytes()),
+	}, true
+}
+
+/*
+Key returns the verification key represented by the JWK
+*/
+func (j JWK) Key() (*Key, error) {
+	if j.Kty != "RSA" || (j.Alg != "" && j.Alg != RS256) {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	n, err := Decode(j.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := Decode(j.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(n) == 0 || len(e) == 0 {
+		return nil, errors.New("jose.jwk.invalid")
+	}
+	return NewRSAKey(j.Kid, nil, &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}), nil
+}
+
+/*
+Find returns the verification key with the key ID (if
+kid is empty and there is a single key it's returned)
+*/
+func (s JWKS) Find(kid string) (*Key, error) {
+	if kid == "" && len(s.Keys) == 1 {
+		return s.Keys[0].Key()
+	}
+	for _, j := range s.Keys {
+		if j.Kid == kid && j.Use != "enc" {
+			return j.Key()
+		}
+	}
+	return nil, ErrKeyNotFound
+}
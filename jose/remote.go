@@ -0,0 +1,98 @@
+package jose
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultMinRefreshInterval is the minimum interval between JWKS fetches
+const DefaultMinRefreshInterval = 30 * time.Second
+
+/*
+RemoteKeySet is a JWKS fetched from an URL, keys are cached
+and the set is fetched again when an unknown key ID is
+requested (at most once every MinRefreshInterval)
+*/
+type RemoteKeySet struct {
+	URL                string
+	Client             *http.Client
+	MinRefreshInterval time.Duration
+
+	mu          sync.Mutex
+	jwks        JWKS
+	lastAttempt time.Time
+}
+
+/*
+NewRemoteKeySet creates a new remote key set
+*/
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &RemoteKeySet{
+		URL:                url,
+		Client:             client,
+		MinRefreshInterval: DefaultMinRefreshInterval,
+	}
+}
+
+/*
+Key returns the verification key with the key ID
+*/
+func (r *RemoteKeySet) Key(ctx context.Context, kid string) (*Key, error) {
+	r.mu.Lock()
+	jwks := r.jwks
+	lastAttempt := r.lastAttempt
+	r.mu.Unlock()
+
+	if k, err := jwks.Find(kid); err == nil {
+		return k, nil
+	}
+	// avoid hammering the server with unknown key IDs
+	if time.Since(lastAttempt) < r.MinRefreshInterval {
+		return nil, ErrKeyNotFound
+	}
+	if err := r.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.jwks.Find(kid)
+}
+
+/*
+Refresh fetches the key set
+*/
+func (r *RemoteKeySet) Refresh(ctx context.Context) error {
+	r.mu.Lock()
+	r.lastAttempt = time.Now()
+	r.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("jose.jwks.fetch.failed: %s", res.Status)
+	}
+	var jwks JWKS
+	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jwks = jwks
+	return nil
+}
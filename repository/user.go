@@ -2,6 +2,7 @@ package repository
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/eldius/jwt-auth-go/config"
 	"github.com/eldius/jwt-auth-go/logger"
@@ -23,29 +24,65 @@ type AuthRepository struct {
 	db *gorm.DB
 }
 
+/*
+Config is the repository configuration
+*/
+type Config struct {
+	// Engine is the database engine (sqlite or mysql/mariadb)
+	Engine string
+	// URL is the database connection string
+	URL string
+	// LogQueries enables the query log
+	LogQueries bool
+}
+
+/*
+LoadConfig loads the repository configuration from
+the `auth.database.*` config keys
+*/
+func LoadConfig() Config {
+	return Config{
+		Engine:     config.GetDBEngine(),
+		URL:        config.GetDBURL(),
+		LogQueries: config.GetDBLogQueries(),
+	}
+}
+
 /*
 NewRepository returns a new repository creating a new db (*gorm.DB)
 */
 func NewRepository() *AuthRepository {
-	db, err := gorm.Open(GetDialect())
+	r, err := NewRepositoryWithConfig(LoadConfig())
 	if err != nil {
 		panic("failed to connect database")
 	}
-	if config.GetDBLogQueries() {
-		db.Logger.LogMode(glogger.Info)
+	return r
+}
+
+/*
+NewRepositoryWithConfig returns a new repository creating
+a new db (*gorm.DB) from the configuration
+*/
+func NewRepositoryWithConfig(cfg Config) (*AuthRepository, error) {
+	db, err := gorm.Open(cfg.Dialect())
+	if err != nil {
+		return nil, err
 	}
-	_ = db.AutoMigrate(&user.CredentialInfo{}, &user.Profile{})
+	if cfg.LogQueries {
+		db.Logger = db.Logger.LogMode(glogger.Info)
+	}
+	migrate(db)
 
 	return &AuthRepository{
 		db: db,
-	}
+	}, nil
 }
 
 /*
 NewRepositoryCustom returns a new repository using the passed db (*gorm.DB)
 */
 func NewRepositoryCustom(db *gorm.DB) *AuthRepository {
-	_ = db.AutoMigrate(&user.CredentialInfo{}, &user.Profile{})
+	migrate(db)
 
 	return &AuthRepository{
 		db: db,
@@ -80,7 +117,7 @@ func (r *AuthRepository) FindUser(username string) *user.CredentialInfo {
 
 	var u *user.CredentialInfo
 	//r.db.Where("User = ?", username).First(&u)
-	tx := r.db.Where("User = ?", username).First(&u)
+	tx := r.db.Preload("Profiles").Where("User = ?", username).First(&u)
 	if tx.Error != nil {
 		log.WithError(tx.Error).Info("FindUser")
 		return nil
@@ -88,29 +125,400 @@ func (r *AuthRepository) FindUser(username string) *user.CredentialInfo {
 	return u
 }
 
+// FindUserByEmail finds the user by e-mail
+func (r *AuthRepository) FindUserByEmail(email string) *user.CredentialInfo {
+	var u *user.CredentialInfo
+	tx := r.db.Preload("Profiles").Where("Email = ?", email).First(&u)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("FindUserByEmail")
+		return nil
+	}
+	return u
+}
+
 // FindUserByID finds the user by its ID
 func (r *AuthRepository) FindUserByID(id int) *user.CredentialInfo {
 	u := user.CredentialInfo{}
-	r.db.Where("ID = ?", id).First(&u)
+	r.db.Preload("Profiles").Where("ID = ?", id).First(&u)
 	return &u
 }
 
 // ListUSers returns all users
 func (r *AuthRepository) ListUSers() (c []user.CredentialInfo) {
+	r.db.Preload("Profiles").Find(&c, "")
+	return
+}
+
+// CountAdmins returns how many active admin users exist
+func (r *AuthRepository) CountAdmins() (n int64) {
+	r.db.Model(&user.CredentialInfo{}).Where("admin = ? AND active = ?", true, true).Count(&n)
+	return
+}
+
+/*
+DeleteUser deletes the user and everything bound to it (profiles
+associations, API keys, service account, external identities,
+OAuth consents and one time tokens) in a single transaction. The
+user record is soft deleted, so its ID and username are never
+reused by new users (its e-mail is released).
+*/
+func (r *AuthRepository) DeleteUser(c *user.CredentialInfo) error {
+	if c == nil {
+		return fmt.Errorf("nil credentials received")
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(c).Association("Profiles").Clear(); err != nil {
+			return err
+		}
+		for _, dependent := range []interface{}{
+			&user.APIKey{},
+			&user.ServiceAccount{},
+			&user.ExternalIdentity{},
+			&user.OAuthConsent{},
+			&user.OneTimeToken{},
+		} {
+			if err := tx.Where("user_id = ?", c.ID).Delete(dependent).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(c).Update("email", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(c).Error
+	})
+}
+
+// UserExists returns true if the username is in use (deleted users included)
+func (r *AuthRepository) UserExists(username string) bool {
+	var n int64
+	r.db.Unscoped().Model(&user.CredentialInfo{}).Where("User = ?", username).Count(&n)
+	return n > 0
+}
+
+// SetUserProfiles replaces the user profiles
+func (r *AuthRepository) SetUserProfiles(c *user.CredentialInfo, profiles []user.Profile) error {
+	if err := r.db.Model(c).Association("Profiles").Replace(profiles); err != nil {
+		return err
+	}
+	c.Profiles = profiles
+	return nil
+}
+
+// SaveProfile saves the profile
+func (r *AuthRepository) SaveProfile(p *user.Profile) error {
+	if p == nil {
+		return fmt.Errorf("nil profile received")
+	}
+	return r.db.Save(p).Error
+}
+
+// ListProfiles returns all profiles
+func (r *AuthRepository) ListProfiles() (p []user.Profile) {
+	r.db.Find(&p, "")
+	return
+}
+
+// FindProfile finds the profile by name
+func (r *AuthRepository) FindProfile(name string) *user.Profile {
+	var p *user.Profile
+	tx := r.db.Where("Name = ?", name).First(&p)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("FindProfile")
+		return nil
+	}
+	return p
+}
+
+// SaveInvitation saves the invitation
+func (r *AuthRepository) SaveInvitation(i *user.Invitation) error {
+	if i == nil {
+		return fmt.Errorf("nil invitation received")
+	}
+	return r.db.Save(i).Error
+}
+
+// FindInvitation finds the invitation by its code hash
+func (r *AuthRepository) FindInvitation(code string) *user.Invitation {
+	var i *user.Invitation
+	tx := r.db.Where("Code = ?", code).First(&i)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("FindInvitation")
+		return nil
+	}
+	return i
+}
+
+/*
+UseInvitation marks the invitation as used, returning
+false if it was already used by someone else
+*/
+func (r *AuthRepository) UseInvitation(i *user.Invitation, usedAt time.Time) bool {
+	tx := r.db.Model(&user.Invitation{}).
+		Where("ID = ? AND used_at IS NULL", i.ID).
+		Updates(map[string]interface{}{"used_at": usedAt})
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("UseInvitation")
+		return false
+	}
+	if tx.RowsAffected == 1 {
+		i.UsedAt = &usedAt
+		return true
+	}
+	return false
+}
+
+// ReleaseInvitation makes an used invitation valid again
+func (r *AuthRepository) ReleaseInvitation(i *user.Invitation) {
+	r.db.Model(&user.Invitation{}).
+		Where("ID = ?", i.ID).
+		Updates(map[string]interface{}{"used_at": nil, "used_by": 0})
+	i.UsedAt = nil
+	i.UsedBy = 0
+}
+
+// SaveOneTimeToken saves the one time token
+func (r *AuthRepository) SaveOneTimeToken(t *user.OneTimeToken) error {
+	if t == nil {
+		return fmt.Errorf("nil token received")
+	}
+	return r.db.Save(t).Error
+}
+
+// FindOneTimeToken finds the one time token by its hash
+func (r *AuthRepository) FindOneTimeToken(hash string) *user.OneTimeToken {
+	var t *user.OneTimeToken
+	tx := r.db.Where("Hash = ?", hash).First(&t)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("FindOneTimeToken")
+		return nil
+	}
+	return t
+}
+
+/*
+UseOneTimeToken marks the token as used, returning
+false if it was already used
+*/
+func (r *AuthRepository) UseOneTimeToken(t *user.OneTimeToken, usedAt time.Time) bool {
+	tx := r.db.Model(&user.OneTimeToken{}).
+		Where("ID = ? AND used_at IS NULL", t.ID).
+		Updates(map[string]interface{}{"used_at": usedAt})
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("UseOneTimeToken")
+		return false
+	}
+	if tx.RowsAffected == 1 {
+		t.UsedAt = &usedAt
+		return true
+	}
+	return false
+}
+
+// SaveClient saves the OAuth client
+func (r *AuthRepository) SaveClient(c *user.OAuthClient) error {
+	if c == nil {
+		return fmt.Errorf("nil client received")
+	}
+	return r.db.Save(c).Error
+}
+
+// FindClient finds the OAuth client by its client ID
+func (r *AuthRepository) FindClient(clientID string) *user.OAuthClient {
+	var c *user.OAuthClient
+	tx := r.db.Where("client_id = ?", clientID).First(&c)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("FindClient")
+		return nil
+	}
+	return c
+}
+
+// ListClients returns all OAuth clients
+func (r *AuthRepository) ListClients() (c []user.OAuthClient) {
 	r.db.Find(&c, "")
 	return
 }
 
+// SaveConsent saves the user consent
+func (r *AuthRepository) SaveConsent(c *user.OAuthConsent) error {
+	if c == nil {
+		return fmt.Errorf("nil consent received")
+	}
+	return r.db.Save(c).Error
+}
+
+// FindConsent finds the user consent for the client
+func (r *AuthRepository) FindConsent(userID int, clientID string) *user.OAuthConsent {
+	var c *user.OAuthConsent
+	tx := r.db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&c)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("FindConsent")
+		return nil
+	}
+	return c
+}
+
+// SaveExternalIdentity saves the external identity
+func (r *AuthRepository) SaveExternalIdentity(i *user.ExternalIdentity) error {
+	if i == nil {
+		return fmt.Errorf("nil identity received")
+	}
+	return r.db.Save(i).Error
+}
+
+// FindExternalIdentity finds the external identity by provider and subject
+func (r *AuthRepository) FindExternalIdentity(provider string, subject string) *user.ExternalIdentity {
+	var i *user.ExternalIdentity
+	tx := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&i)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("FindExternalIdentity")
+		return nil
+	}
+	return i
+}
+
+// SaveRevokedToken saves the revoked token
+func (r *AuthRepository) SaveRevokedToken(t *user.RevokedToken) error {
+	if t == nil {
+		return fmt.Errorf("nil revoked token received")
+	}
+	return r.db.Where(user.RevokedToken{JTI: t.JTI}).FirstOrCreate(t).Error
+}
+
+// IsTokenRevoked returns true if the token ID was revoked
+func (r *AuthRepository) IsTokenRevoked(jti string) bool {
+	var count int64
+	tx := r.db.Model(&user.RevokedToken{}).Where("jti = ?", jti).Count(&count)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("IsTokenRevoked")
+		return false
+	}
+	return count > 0
+}
+
+// DeleteExpiredRevokedTokens removes the revoked tokens already expired
+func (r *AuthRepository) DeleteExpiredRevokedTokens(now time.Time) error {
+	return r.db.Where("expires_at < ?", now).Delete(&user.RevokedToken{}).Error
+}
+
+// SaveAPIKey saves the API key
+func (r *AuthRepository) SaveAPIKey(k *user.APIKey) error {
+	if k == nil {
+		return fmt.Errorf("nil API key received")
+	}
+	return r.db.Save(k).Error
+}
+
+// FindAPIKey finds the API key by its hash
+func (r *AuthRepository) FindAPIKey(hash string) *user.APIKey {
+	var k *user.APIKey
+	tx := r.db.Where("hash = ?", hash).First(&k)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("FindAPIKey")
+		return nil
+	}
+	return k
+}
+
+// ListAPIKeys lists the user API keys
+func (r *AuthRepository) ListAPIKeys(userID int) []user.APIKey {
+	var keys []user.APIKey
+	r.db.Where("user_id = ?", userID).Order("id").Find(&keys)
+	return keys
+}
+
+// DeleteAPIKey deletes the user API key, returns false if not found
+func (r *AuthRepository) DeleteAPIKey(userID int, id int) bool {
+	tx := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&user.APIKey{})
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("DeleteAPIKey")
+		return false
+	}
+	return tx.RowsAffected == 1
+}
+
+// TouchAPIKey updates the API key last usage
+func (r *AuthRepository) TouchAPIKey(k *user.APIKey, usedAt time.Time, ip string) error {
+	return r.db.Model(&user.APIKey{}).
+		Where("ID = ?", k.ID).
+		Updates(map[string]interface{}{"last_used_at": usedAt, "last_used_ip": ip}).Error
+}
+
+// SaveServiceAccount saves the service account
+func (r *AuthRepository) SaveServiceAccount(a *user.ServiceAccount) error {
+	if a == nil {
+		return fmt.Errorf("nil service account received")
+	}
+	return r.db.Save(a).Error
+}
+
+// FindServiceAccount finds the service account by its client ID
+func (r *AuthRepository) FindServiceAccount(clientID string) *user.ServiceAccount {
+	return r.findServiceAccount("client_id = ?", clientID)
+}
+
+// FindServiceAccountByThumbprint finds the service account by its certificate thumbprint
+func (r *AuthRepository) FindServiceAccountByThumbprint(thumbprint string) *user.ServiceAccount {
+	return r.findServiceAccount("cert_thumbprint = ?", thumbprint)
+}
+
+// FindServiceAccountBySubject finds the service account by its certificate subject
+func (r *AuthRepository) FindServiceAccountBySubject(subject string) *user.ServiceAccount {
+	return r.findServiceAccount("cert_subject = ?", subject)
+}
+
+// ListServiceAccounts lists the service accounts
+func (r *AuthRepository) ListServiceAccounts() []user.ServiceAccount {
+	var accounts []user.ServiceAccount
+	r.db.Order("id").Find(&accounts)
+	return accounts
+}
+
+func (r *AuthRepository) findServiceAccount(query string, value string) *user.ServiceAccount {
+	if value == "" {
+		return nil
+	}
+	var a *user.ServiceAccount
+	tx := r.db.Where(query, value).First(&a)
+	if tx.Error != nil {
+		log.WithError(tx.Error).Info("FindServiceAccount")
+		return nil
+	}
+	return a
+}
+
+func migrate(db *gorm.DB) {
+	_ = db.AutoMigrate(
+		&user.CredentialInfo{},
+		&user.Profile{},
+		&user.Invitation{},
+		&user.OneTimeToken{},
+		&user.OAuthClient{},
+		&user.OAuthConsent{},
+		&user.ExternalIdentity{},
+		&user.RevokedToken{},
+		&user.APIKey{},
+		&user.ServiceAccount{},
+	)
+}
+
 /*
 GetDialect parses the dialect using the 'auth.database.engine' config key
 */
 func GetDialect() gorm.Dialector {
-	switch config.GetDBEngine() {
+	return LoadConfig().Dialect()
+}
+
+/*
+Dialect parses the dialect using the configured engine
+*/
+func (c Config) Dialect() gorm.Dialector {
+	switch c.Engine {
 	case "sqlite":
-		return sqlite.Open(config.GetDBURL())
+		return sqlite.Open(c.URL)
 	case "mysql", "mariadb":
-		return mysql.Open(config.GetDBURL())
+		return mysql.Open(c.URL)
 	default:
-		return sqlite.Open(config.GetDBURL())
+		return sqlite.Open(c.URL)
 	}
 }
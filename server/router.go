@@ -0,0 +1,111 @@
+/*
+Package server mounts the auth endpoints on an
+http.Handler and runs the standalone auth server
+*/
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/auth"
+)
+
+/*
+Route is an endpoint mounted by the router
+*/
+type Route struct {
+	Path    string
+	Methods []string
+	Handler http.Handler
+}
+
+/*
+Routes returns the auth endpoints (paths are relative to
+the prefix, OpenID Connect endpoints configured with
+absolute URLs are not mounted)
+*/
+func Routes(h *auth.Handler) []Route {
+	cfg := h.GetService().Config()
+	routes := []Route{
+		{"/login", []string{http.MethodPost}, h.HandleLogin()},
+		{"/logout", []string{http.MethodPost}, h.HandleLogout()},
+		{"/csrf", []string{http.MethodGet}, h.HandleCSRFToken()},
+		{"/user", []string{http.MethodPost, http.MethodPatch}, h.HandleUser()},
+		{"/me", []string{http.MethodGet}, h.HandleMe()},
+		{"/invitations", []string{http.MethodPost}, h.HandleInvitation()},
+		{"/email/verification", []string{http.MethodGet, http.MethodPost}, h.HandleEmailVerification()},
+		{"/magiclink", []string{http.MethodPost}, h.HandleMagicLinkRequest()},
+		{"/magiclink/consume", []string{http.MethodGet, http.MethodPost}, h.HandleMagicLinkConsume()},
+		{"/federation/login", []string{http.MethodGet}, h.HandleFederatedLogin()},
+		{"/federation/callback", []string{http.MethodGet}, h.HandleFederatedCallback()},
+		{"/apikeys", []string{http.MethodGet, http.MethodPost, http.MethodDelete}, h.HandleAPIKeys()},
+		{"/clients", []string{http.MethodGet, http.MethodPost}, h.HandleClients()},
+		{"/service/accounts", []string{http.MethodGet, http.MethodPost}, h.HandleServiceAccounts()},
+		{"/service/token", []string{http.MethodPost}, h.HandleServiceToken()},
+		{"/.well-known/openid-configuration", []string{http.MethodGet}, h.HandleOpenIDConfiguration()},
+	}
+	for _, e := range []struct {
+		name    string
+		methods []string
+		handler http.Handler
+	}{
+		{"authorize", []string{http.MethodGet, http.MethodPost}, h.HandleAuthorize()},
+		{"token", []string{http.MethodPost}, h.HandleToken()},
+		{"userinfo", []string{http.MethodGet, http.MethodPost}, h.HandleUserInfo()},
+		{"jwks", []string{http.MethodGet}, h.HandleJWKS()},
+		{"introspect", []string{http.MethodPost}, h.HandleIntrospect()},
+		{"revoke", []string{http.MethodPost}, h.HandleRevoke()},
+	} {
+		if path := cfg.OIDCEndpoint(e.name); strings.HasPrefix(path, "/") {
+			routes = append(routes, Route{path, e.methods, e.handler})
+		}
+	}
+	return routes
+}
+
+/*
+NewRouter mounts the routes under the prefix, requests with
+methods the route doesn't accept receive a 405 (with the
+`Allow` header) and request bodies are limited to maxBodySize
+bytes (zero means no limit)
+*/
+func NewRouter(prefix string, routes []Route, maxBodySize int64) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.Handle(prefix+route.Path, methods(route.Methods, route.Handler))
+	}
+	if maxBodySize <= 0 {
+		return mux
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(rw, r.Body, maxBodySize)
+		}
+		mux.ServeHTTP(rw, r)
+	})
+}
+
+/*
+NewHandler mounts the auth endpoints using the handler
+service configuration (ServerPrefix and ServerMaxBodySize)
+*/
+func NewHandler(h *auth.Handler) http.Handler {
+	cfg := h.GetService().Config()
+	return NewRouter(cfg.ServerPrefix, Routes(h), cfg.ServerMaxBodySize)
+}
+
+func methods(allowed []string, next http.Handler) http.Handler {
+	allow := strings.Join(allowed, ", ")
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		for _, m := range allowed {
+			if r.Method == m {
+				next.ServeHTTP(rw, r)
+				return
+			}
+		}
+		rw.Header().Set("Allow", allow)
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	})
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/eldius/jwt-auth-go/auth"
+	"github.com/eldius/jwt-auth-go/config"
+)
+
+/*
+Server is the standalone auth server
+*/
+type Server struct {
+	srv      *http.Server
+	certFile string
+	keyFile  string
+}
+
+/*
+New creates the auth server using the server
+configs (`auth.server.*`)
+*/
+func New(h *auth.Handler) *Server {
+	return &Server{
+		srv: &http.Server{
+			Addr:         config.GetServerAddress(),
+			Handler:      NewHandler(h),
+			ReadTimeout:  config.GetServerReadTimeout(),
+			WriteTimeout: config.GetServerWriteTimeout(),
+			IdleTimeout:  config.GetServerIdleTimeout(),
+		},
+		certFile: config.GetServerTLSCertFile(),
+		keyFile:  config.GetServerTLSKeyFile(),
+	}
+}
+
+/*
+Handler returns the server handler
+*/
+func (s *Server) Handler() http.Handler {
+	return s.srv.Handler
+}
+
+/*
+ListenAndServe serves the requests (using TLS if the certificate
+is configured) until the context is done, then it shuts the
+server down waiting the active requests to finish
+*/
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	errs := make(chan error, 1)
+	go func() {
+		log.Println("Listening on", s.srv.Addr)
+		if s.certFile != "" {
+			errs <- s.srv.ListenAndServeTLS(s.certFile, s.keyFile)
+		} else {
+			errs <- s.srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.GetServerShutdownTimeout())
+	defer cancel()
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+/*
+Run serves the requests until the process receives
+a SIGINT or SIGTERM (graceful shutdown)
+*/
+func (s *Server) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.ListenAndServe(ctx)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/auth"
+	"github.com/eldius/jwt-auth-go/repository"
+	"github.com/spf13/viper"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestHandler(t *testing.T) *auth.Handler {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Errorf("Failed to open test database: %s", err.Error())
+		t.FailNow()
+	}
+	cfg := auth.LoadConfig()
+	cfg.Secret = "server-test-secret"
+	svc, err := auth.NewServiceWithConfig(cfg, repository.NewRepositoryCustom(db))
+	if err != nil {
+		t.Errorf("Failed to create service: %s", err.Error())
+		t.FailNow()
+	}
+	if _, err := svc.CreateNewUser(&auth.NewUser{User: "server.user", Pass: "pass", Active: true}); err != nil {
+		t.Errorf("Failed to create test user: %s", err.Error())
+		t.FailNow()
+	}
+	return auth.NewHandlerCustom(svc)
+}
+
+func TestRouter(t *testing.T) {
+	viper.Set("auth.oidc.endpoints.jwks", "/.well-known/jwks.json")
+	defer viper.Set("auth.oidc.endpoints.jwks", "")
+
+	s := httptest.NewServer(NewRouter("/auth/", Routes(newTestHandler(t)), 0))
+	defer s.Close()
+
+	body, _ := json.Marshal(&auth.LoginRequest{User: "server.user", Pass: "pass"})
+	res, err := http.Post(s.URL+"/auth/login", "application/json", bytes.NewReader(body))
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("Should mount the login under the prefix, but was '%v' '%v'", res, err)
+	}
+
+	res, err = http.Get(s.URL + "/auth/login")
+	if err != nil || res.StatusCode != http.StatusMethodNotAllowed || res.Header.Get("Allow") != http.MethodPost {
+		t.Errorf("Should return 405 with the allowed methods, but was '%v' '%v'", res, err)
+	}
+
+	res, err = http.Get(s.URL + "/auth/.well-known/jwks.json")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("Should mount the OpenID Connect endpoints, but was '%v' '%v'", res, err)
+	}
+
+	res, err = http.Get(s.URL + "/login")
+	if err != nil || res.StatusCode != http.StatusNotFound {
+		t.Errorf("Should not mount routes outside the prefix, but was '%v' '%v'", res, err)
+	}
+}
+
+func TestNewHandlerUsesServiceConfig(t *testing.T) {
+	h := newTestHandler(t)
+	cfg := *h.GetService().Config()
+	cfg.ServerPrefix = "/custom"
+	cfg.OIDCEndpoints = map[string]string{"jwks": "/keys", "token": "https://idp.example.com/token"}
+	if err := h.GetService().SetConfig(&cfg); err != nil {
+		t.Errorf("Failed to set config: %s", err.Error())
+		t.FailNow()
+	}
+	s := httptest.NewServer(NewHandler(h))
+	defer s.Close()
+
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/custom/keys", http.StatusOK},
+		{http.MethodGet, "/custom/login", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/auth/login", http.StatusNotFound},
+		{http.MethodPost, "/custom/token", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest(test.method, s.URL+test.path, nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil || res.StatusCode != test.status {
+			t.Errorf("%s %s: should return '%d', but was '%v' '%v'", test.method, test.path, test.status, res, err)
+		}
+	}
+}
+
+func TestRouterBodyLimit(t *testing.T) {
+	echo := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	h := NewRouter("", []Route{{"/echo", []string{http.MethodPost}, echo}}, 8)
+	for _, test := range []struct {
+		body   string
+		status int
+	}{
+		{"small", http.StatusNoContent},
+		{"a body bigger than the limit", http.StatusRequestEntityTooLarge},
+	} {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(test.body)))
+		if rw.Code != test.status {
+			t.Errorf("Should return '%d' to '%s', but was '%d'", test.status, test.body, rw.Code)
+		}
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	viper.Set("auth.server.address", "127.0.0.1:0")
+	viper.Set("auth.server.timeout.shutdown", "1s")
+	defer viper.Set("auth.server.address", "")
+	defer viper.Set("auth.server.timeout.shutdown", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- New(newTestHandler(t)).ListenAndServe(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("Should shutdown gracefully, but was '%v'", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Should shutdown when the context is done")
+	}
+}
@@ -0,0 +1,40 @@
+package user
+
+import (
+	"strings"
+	"time"
+)
+
+/*
+APIKey is a long lived personal access token used by
+scripts and machine clients on behalf of the user (only
+the key hash is stored, scopes are space separated)
+*/
+type APIKey struct {
+	ID     int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY" json:"id"`
+	UserID int    `gorm:"index" json:"-"`
+	Name   string `json:"name"`
+	// Prefix is the beginning of the key, so users can
+	// recognize it without the plaintext
+	Prefix     string     `json:"prefix"`
+	Hash       string     `gorm:"unique;not null;UNIQUE_INDEX" json:"-"`
+	Scopes     string     `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP string     `json:"lastUsedIp,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+/*
+ScopeList returns the key scopes
+*/
+func (k *APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+/*
+IsValid returns true if the key is not expired
+*/
+func (k *APIKey) IsValid(now time.Time) bool {
+	return k.ExpiresAt == nil || k.ExpiresAt.After(now)
+}
@@ -0,0 +1,16 @@
+package user
+
+import "time"
+
+/*
+ExternalIdentity links an user to its account in an
+external identity provider (federated login)
+*/
+type ExternalIdentity struct {
+	ID        int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY" json:"id"`
+	Provider  string `gorm:"not null;uniqueIndex:idx_external_identity" json:"provider"`
+	Subject   string `gorm:"not null;uniqueIndex:idx_external_identity" json:"subject"`
+	UserID    int    `gorm:"index" json:"userId"`
+	Email     string `json:"email,omitempty"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,28 @@
+package user
+
+import "time"
+
+/*
+Invitation is an invitation code used to register
+new users when registration is invite only (only
+the code hash is stored)
+*/
+type Invitation struct {
+	ID        int        `gorm:"AUTO_INCREMENT;PRIMARY_KEY" json:"id"`
+	Code      string     `gorm:"unique;not null;UNIQUE_INDEX" json:"-"`
+	CreatedBy int        `json:"createdBy"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	UsedBy    int        `json:"usedBy,omitempty"`
+}
+
+/*
+IsValid returns true if the invitation was not used
+and is not expired
+*/
+func (i *Invitation) IsValid(now time.Time) bool {
+	if i.UsedAt != nil {
+		return false
+	}
+	return i.ExpiresAt == nil || i.ExpiresAt.After(now)
+}
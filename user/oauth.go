@@ -0,0 +1,94 @@
+package user
+
+import (
+	"strings"
+	"time"
+)
+
+// OAuth grant types
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+/*
+OAuthClient is an application allowed to request tokens
+(lists are stored as space separated values, like the
+OAuth `scope` parameter)
+*/
+type OAuthClient struct {
+	ID           int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY" json:"id"`
+	ClientID     string `gorm:"unique;not null;UNIQUE_INDEX" json:"clientId"`
+	SecretHash   []byte `json:"-"`
+	SecretSalt   []byte `json:"-"`
+	Name         string `json:"name"`
+	RedirectURIs string `json:"redirectUris"`
+	Scopes       string `json:"scopes"`
+	GrantTypes   string `json:"grantTypes"`
+	Audience     string `json:"audience,omitempty"`
+	// Public clients (SPA, mobile apps) can't keep a
+	// secret, so they must use PKCE
+	Public bool `json:"public"`
+	// FirstParty clients don't need the user consent
+	FirstParty bool      `json:"firstParty"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+/*
+OAuthConsent is the user consent for a client to
+access the scopes on its behalf
+*/
+type OAuthConsent struct {
+	ID        int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY"`
+	UserID    int    `gorm:"index"`
+	ClientID  string `gorm:"index"`
+	Scope     string
+	CreatedAt time.Time
+}
+
+/*
+AllowsRedirectURI returns true if the uri is one of the
+client redirect URIs (exact match)
+*/
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	return contains(strings.Fields(c.RedirectURIs), uri)
+}
+
+/*
+AllowsGrant returns true if the client is allowed
+to use the grant type
+*/
+func (c *OAuthClient) AllowsGrant(grant string) bool {
+	return contains(strings.Fields(c.GrantTypes), grant)
+}
+
+/*
+ScopeList returns the scopes the client is allowed to request
+*/
+func (c *OAuthClient) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+/*
+Covers returns true if the consent covers all the scopes
+*/
+func (c *OAuthConsent) Covers(scopes []string) bool {
+	granted := strings.Fields(c.Scope)
+	for _, s := range scopes {
+		if !contains(granted, s) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
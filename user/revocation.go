@@ -0,0 +1,15 @@
+package user
+
+import "time"
+
+/*
+RevokedToken is an access token revoked before its
+expiration (identified by the `jti` claim), it can be
+removed after ExpiresAt
+*/
+type RevokedToken struct {
+	ID        int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY"`
+	JTI       string `gorm:"unique;not null;UNIQUE_INDEX"`
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+}
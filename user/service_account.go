@@ -0,0 +1,35 @@
+package user
+
+import (
+	"strings"
+	"time"
+)
+
+/*
+ServiceAccount is the identity of a backend service, it's
+bound to a service account user (CredentialInfo) and
+authenticates using its client secret or a TLS client
+certificate (mapped by its SHA-256 thumbprint or subject)
+*/
+type ServiceAccount struct {
+	ID         int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY" json:"id"`
+	UserID     int    `gorm:"unique;not null" json:"userId"`
+	ClientID   string `gorm:"unique;not null;UNIQUE_INDEX" json:"clientId"`
+	SecretHash []byte `json:"-"`
+	SecretSalt []byte `json:"-"`
+	Name       string `json:"name"`
+	Scopes     string `json:"scopes"`
+	// CertThumbprint is the base64url encoded SHA-256
+	// hash of the DER certificate (`x5t#S256`)
+	CertThumbprint string    `gorm:"index" json:"certThumbprint,omitempty"`
+	CertSubject    string    `gorm:"index" json:"certSubject,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+/*
+ScopeList returns the scopes the service account
+is allowed to request
+*/
+func (a *ServiceAccount) ScopeList() []string {
+	return strings.Fields(a.Scopes)
+}
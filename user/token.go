@@ -0,0 +1,27 @@
+package user
+
+import "time"
+
+/*
+OneTimeToken is a single use token (e-mail verification
+links, magic links, etc) bound to an user (only the token
+hash is stored)
+*/
+type OneTimeToken struct {
+	ID        int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY"`
+	Purpose   string `gorm:"not null;index"`
+	Hash      string `gorm:"unique;not null;UNIQUE_INDEX"`
+	UserID    int
+	Data      string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+/*
+IsValid returns true if the token was not used
+and is not expired
+*/
+func (t *OneTimeToken) IsValid(now time.Time) bool {
+	return t.UsedAt == nil && t.ExpiresAt.After(now)
+}
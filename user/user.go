@@ -4,10 +4,13 @@ import (
 	"crypto/sha512"
 	"errors"
 	"log"
+	"net/mail"
 	"regexp"
+	"time"
 
 	"github.com/eldius/jwt-auth-go/config"
 	"github.com/eldius/jwt-auth-go/hashtools"
+	"gorm.io/gorm"
 )
 
 const (
@@ -15,29 +18,132 @@ const (
 	invalidUsername = "credentials.username.must.match.pattern"
 	emptyPassword   = "credentials.password.must.not.be.empty"
 	invalidPassword = "credentials.password.must.match.pattern"
+	invalidEmail    = "credentials.email.invalid"
 )
 
 /*
 CredentialInfo represents the user credentials
 */
 type CredentialInfo struct {
-	ID     int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY"`
-	User   string `gorm:"unique;not null;UNIQUE_INDEX"`
-	Hash   []byte `gorm:"not null"`
-	Salt   []byte `gorm:"not null"`
-	Name   string
-	Active bool
-	Admin  bool
+	ID       int       `gorm:"AUTO_INCREMENT;PRIMARY_KEY" json:"id"`
+	User     string    `gorm:"unique;not null;UNIQUE_INDEX" json:"user"`
+	Hash     []byte    `gorm:"not null" json:"-"`
+	Salt     []byte    `gorm:"not null" json:"-"`
+	Name     string    `json:"name"`
+	Active   bool      `json:"active"`
+	Admin    bool      `json:"admin"`
+	Profiles []Profile `gorm:"many2many:credential_profiles;" json:"profiles,omitempty"`
+	// Email is optional, so it's a pointer to
+	// allow multiple users without e-mail
+	Email           *string    `gorm:"unique" json:"email,omitempty"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+	// ServiceAccount users are backend services, they
+	// can't login with password (see ServiceAccount)
+	ServiceAccount bool `json:"serviceAccount"`
+	// DeletedAt is set when the user is deleted, the record
+	// is kept so its ID and username are never reused
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 /*
 Profile is the user profile
 */
 type Profile struct {
-	ID          int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY"`
-	Name        string `gorm:"unique;not null;UNIQUE_INDEX"`
-	Description string
-	Active      bool
+	ID          int    `gorm:"AUTO_INCREMENT;PRIMARY_KEY" json:"id"`
+	Name        string `gorm:"unique;not null;UNIQUE_INDEX" json:"name"`
+	Description string `json:"description"`
+	Active      bool   `json:"active"`
+	// Scopes the profile users are allowed
+	// to request (space separated)
+	Scopes string `json:"scopes"`
+}
+
+/*
+UserView is the public representation of an user,
+safe to be encoded and sent to clients (it never
+carries password material)
+*/
+type UserView struct {
+	ID             int      `json:"id"`
+	User           string   `json:"user"`
+	Name           string   `json:"name"`
+	Email          string   `json:"email,omitempty"`
+	EmailVerified  bool     `json:"emailVerified"`
+	Active         bool     `json:"active"`
+	Admin          bool     `json:"admin"`
+	ServiceAccount bool     `json:"serviceAccount"`
+	Roles          []string `json:"roles"`
+}
+
+/*
+View returns the public representation of the credentials
+*/
+func (c *CredentialInfo) View() UserView {
+	return UserView{
+		ID:             c.ID,
+		User:           c.User,
+		Name:           c.Name,
+		Email:          c.GetEmail(),
+		EmailVerified:  c.IsEmailVerified(),
+		Active:         c.Active,
+		Admin:          c.Admin,
+		ServiceAccount: c.ServiceAccount,
+		Roles:          c.Roles(),
+	}
+}
+
+/*
+GetEmail returns the user e-mail (or an empty
+string if it's not defined)
+*/
+func (c *CredentialInfo) GetEmail() string {
+	if c.Email == nil {
+		return ""
+	}
+	return *c.Email
+}
+
+/*
+SetEmail validates and sets the user e-mail (an
+empty string removes it)
+*/
+func (c *CredentialInfo) SetEmail(email string) error {
+	if email == "" {
+		c.Email = nil
+		c.EmailVerifiedAt = nil
+		return nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New(invalidEmail)
+	}
+	if c.GetEmail() != email {
+		c.EmailVerifiedAt = nil
+	}
+	c.Email = &email
+	return nil
+}
+
+/*
+IsEmailVerified returns true if the user has
+a verified e-mail
+*/
+func (c *CredentialInfo) IsEmailVerified() bool {
+	return c.Email != nil && c.EmailVerifiedAt != nil
+}
+
+/*
+Roles returns the names of the active profiles
+assigned to the user
+*/
+func (c *CredentialInfo) Roles() []string {
+	roles := make([]string, 0, len(c.Profiles))
+	for _, p := range c.Profiles {
+		if p.Active {
+			roles = append(roles, p.Name)
+		}
+	}
+	return roles
 }
 
 /*
@@ -73,6 +179,24 @@ func NewCredentials(user string, pass string) (cred CredentialInfo, err error) {
 	return
 }
 
+/*
+SetPassword validates the password and replaces
+the credentials hash (with a new salt)
+*/
+func (c *CredentialInfo) SetPassword(pass string) error {
+	if err := validatePassword(pass); err != nil {
+		return err
+	}
+	salt := hashtools.Salt()
+	hash, err := hashtools.Hash(pass, salt)
+	if err != nil {
+		return err
+	}
+	c.Salt = salt
+	c.Hash = hash
+	return nil
+}
+
 func validateUsername(username string) error {
 	if username == "" {
 		return errors.New(emptyUsername)
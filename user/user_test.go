@@ -1,6 +1,8 @@
 package user
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/eldius/jwt-auth-go/config"
@@ -60,3 +62,49 @@ func TestNewCredentialUsernameValidation(t *testing.T) {
 		t.Error("04 - Valid username validation failed")
 	}
 }
+
+func TestCredentialInfoJSONOmitsSecrets(t *testing.T) {
+	c, err := NewCredentials("user1", "AbC123")
+	if err != nil {
+		t.Error("Failed to create a credential\n", err.Error())
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Errorf("Failed to encode credential: %s", err.Error())
+	}
+	for _, k := range []string{"Hash", "Salt", "hash", "salt"} {
+		if strings.Contains(string(b), k) {
+			t.Errorf("Encoded credential must not carry '%s': %s", k, string(b))
+		}
+	}
+}
+
+func TestViewRoles(t *testing.T) {
+	c := CredentialInfo{
+		User: "user1",
+		Profiles: []Profile{
+			{Name: "reader", Active: true},
+			{Name: "writer", Active: false},
+		},
+	}
+
+	v := c.View()
+	if len(v.Roles) != 1 || v.Roles[0] != "reader" {
+		t.Errorf("Should return only active profiles as roles, but was '%v'", v.Roles)
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	c, err := NewCredentials("user1", "AbC123")
+	if err != nil {
+		t.Error("Failed to create a credential\n", err.Error())
+	}
+	hash := string(c.Hash)
+	if err := c.SetPassword(""); err == nil || err.Error() != "credentials.password.must.not.be.empty" {
+		t.Errorf("Should validate the password, but was '%v'", err)
+	}
+	if err := c.SetPassword("DeF456"); err != nil || string(c.Hash) == hash {
+		t.Errorf("Should replace the password hash, but was '%v'", err)
+	}
+}
@@ -0,0 +1,265 @@
+package verifier
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Claims are the typed claims of a verified token, claims
+without a typed field are kept in Extra
+*/
+type Claims struct {
+	// Subject is the username (`user` claim, or `sub`
+	// for tokens issued by other servers)
+	Subject     string
+	Name        string
+	SubjectType string
+	ClientID    string
+	Scopes      []string
+	Issuer      string
+	Audience    []string
+	ID          string
+	ExpiresAt   time.Time
+	IssuedAt    time.Time
+	NotBefore   time.Time
+	// CertThumbprint is the client certificate the token
+	// is bound to (`cnf.x5t#S256`, RFC 8705)
+	CertThumbprint string
+	Extra          map[string]interface{}
+}
+
+/*
+HasScopes returns true if the token was granted all the scopes
+*/
+func (c *Claims) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !contains(c.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Get returns the extra claim
+*/
+func (c *Claims) Get(name string) (interface{}, bool) {
+	v, ok := c.Extra[name]
+	return v, ok
+}
+
+/*
+Set sets the extra claim (the value must be JSON encodable)
+*/
+func (c *Claims) Set(name string, value interface{}) {
+	if c.Extra == nil {
+		c.Extra = make(map[string]interface{})
+	}
+	c.Extra[name] = value
+}
+
+/*
+GetString returns the extra claim as string (empty
+if it's not defined or it's not a string)
+*/
+func (c *Claims) GetString(name string) string {
+	return stringValue(c.Extra[name])
+}
+
+/*
+GetFloat64 returns the extra claim as float64 (JSON
+numbers and numeric strings are accepted)
+*/
+func (c *Claims) GetFloat64(name string) (float64, bool) {
+	switch v := c.Extra[name].(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+/*
+GetInt64 returns the extra claim as int64 (JSON
+numbers and numeric strings are accepted)
+*/
+func (c *Claims) GetInt64(name string) (int64, bool) {
+	f, ok := c.GetFloat64(name)
+	return int64(f), ok
+}
+
+/*
+GetBool returns the extra claim as bool
+*/
+func (c *Claims) GetBool(name string) (bool, bool) {
+	switch v := c.Extra[name].(type) {
+	case bool:
+		return v, true
+	case string:
+		b, err := strconv.ParseBool(v)
+		return b, err == nil
+	}
+	return false, false
+}
+
+/*
+GetStrings returns the extra claim as a list of strings
+(JSON arrays and space separated strings are accepted)
+*/
+func (c *Claims) GetStrings(name string) []string {
+	switch v := c.Extra[name].(type) {
+	case []string:
+		return v
+	default:
+		return stringsValue(v)
+	}
+}
+
+/*
+Map returns the token payload of the claims (times are
+encoded as RFC3339 strings, like the library tokens)
+*/
+func (c *Claims) Map() map[string]interface{} {
+	payload := make(map[string]interface{}, len(c.Extra)+12)
+	for k, v := range c.Extra {
+		payload[k] = v
+	}
+	setString := func(name string, value string) {
+		if value != "" {
+			payload[name] = value
+		}
+	}
+	setString("user", c.Subject)
+	setString("name", c.Name)
+	setString("sub_type", c.SubjectType)
+	setString("client_id", c.ClientID)
+	setString("scope", strings.Join(c.Scopes, " "))
+	setString("iss", c.Issuer)
+	setString("jti", c.ID)
+	switch len(c.Audience) {
+	case 0:
+	case 1:
+		payload["aud"] = c.Audience[0]
+	default:
+		payload["aud"] = c.Audience
+	}
+	if !c.ExpiresAt.IsZero() {
+		payload["expires"] = c.ExpiresAt.Format(time.RFC3339)
+	}
+	if !c.IssuedAt.IsZero() {
+		payload["issued"] = c.IssuedAt.Format(time.RFC3339)
+	}
+	if !c.NotBefore.IsZero() {
+		payload["nbf"] = c.NotBefore.Unix()
+	}
+	if c.CertThumbprint != "" {
+		payload["cnf"] = map[string]string{"x5t#S256": c.CertThumbprint}
+	}
+	return payload
+}
+
+/*
+ParseClaims builds the typed claims from the token payload, times
+are accepted as RFC3339 strings (used by the library tokens) or
+NumericDate values
+*/
+func ParseClaims(raw map[string]interface{}) (*Claims, error) {
+	c := &Claims{Extra: make(map[string]interface{})}
+	var err error
+	for k, v := range raw {
+		switch k {
+		case "user":
+			c.Subject = stringValue(v)
+		case "sub":
+			if c.Subject == "" {
+				c.Subject = stringValue(v)
+			}
+		case "name":
+			c.Name = stringValue(v)
+		case "sub_type":
+			c.SubjectType = stringValue(v)
+		case "client_id":
+			c.ClientID = stringValue(v)
+		case "scope":
+			c.Scopes = strings.Fields(stringValue(v))
+		case "iss":
+			c.Issuer = stringValue(v)
+		case "aud":
+			c.Audience = stringsValue(v)
+		case "jti":
+			c.ID = stringValue(v)
+		case "expires", "exp":
+			c.ExpiresAt, err = timeValue(v)
+		case "issued", "iat":
+			c.IssuedAt, err = timeValue(v)
+		case "nbf":
+			c.NotBefore, err = timeValue(v)
+		case "cnf":
+			if cnf, ok := v.(map[string]interface{}); ok {
+				c.CertThumbprint = stringValue(cnf["x5t#S256"])
+			}
+		default:
+			c.Extra[k] = v
+		}
+		if err != nil {
+			return nil, ErrInvalidTime
+		}
+	}
+	return c, nil
+}
+
+func stringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func stringsValue(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, i := range value {
+			if s, ok := i.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func timeValue(v interface{}) (time.Time, error) {
+	switch value := v.(type) {
+	case float64:
+		return time.Unix(int64(value), 0), nil
+	case string:
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		secs, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(int64(secs), 0), nil
+	}
+	return time.Time{}, errors.New("invalid time")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package verifier
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/jose"
+)
+
+/*
+KeySource returns the key used to verify a token
+*/
+type KeySource interface {
+	Key(ctx context.Context, header jose.Header) (*jose.Key, error)
+}
+
+/*
+KeySourceFunc is a function implementing KeySource
+*/
+type KeySourceFunc func(ctx context.Context, header jose.Header) (*jose.Key, error)
+
+/*
+Key calls the function
+*/
+func (f KeySourceFunc) Key(ctx context.Context, header jose.Header) (*jose.Key, error) {
+	return f(ctx, header)
+}
+
+/*
+Secret returns a source of the HS256 key (the
+auth server `auth.jwt.secret`)
+*/
+func Secret(secret []byte) KeySource {
+	return StaticKey(jose.NewHMACKey("", secret))
+}
+
+/*
+StaticKey returns a source of a single key (tokens
+signed with another algorithm are rejected)
+*/
+func StaticKey(key *jose.Key) KeySource {
+	return KeySourceFunc(func(_ context.Context, header jose.Header) (*jose.Key, error) {
+		if header.Alg != key.Algorithm || (key.ID != "" && header.Kid != "" && header.Kid != key.ID) {
+			return nil, jose.ErrKeyNotFound
+		}
+		return key, nil
+	})
+}
+
+/*
+RemoteKeySource is a JWKS fetched from an URL, keys are
+cached and refreshed in background
+*/
+type RemoteKeySource struct {
+	set  *jose.RemoteKeySet
+	stop chan struct{}
+	once sync.Once
+}
+
+/*
+RemoteJWKS returns a source of the keys published by the
+auth server (`/.well-known/jwks.json`), if refresh is greater
+than zero the keys are fetched again in background every
+refresh interval (Close stops it). Unknown key IDs trigger a
+refresh too (rate limited).
+*/
+func RemoteJWKS(url string, client *http.Client, refresh time.Duration) *RemoteKeySource {
+	r := &RemoteKeySource{
+		set:  jose.NewRemoteKeySet(url, client),
+		stop: make(chan struct{}),
+	}
+	if refresh > 0 {
+		go r.refresh(refresh)
+	}
+	return r
+}
+
+/*
+Key returns the key with the header key ID
+*/
+func (r *RemoteKeySource) Key(ctx context.Context, header jose.Header) (*jose.Key, error) {
+	if header.Alg != jose.RS256 {
+		return nil, jose.ErrUnsupportedAlgorithm
+	}
+	return r.set.Key(ctx, header.Kid)
+}
+
+/*
+Close stops the background refresh
+*/
+func (r *RemoteKeySource) Close() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+}
+
+func (r *RemoteKeySource) refresh(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			_ = r.set.Refresh(ctx)
+			cancel()
+		}
+	}
+}
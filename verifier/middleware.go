@@ -0,0 +1,71 @@
+package verifier
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eldius/jwt-auth-go/jose"
+)
+
+type claimsKey struct{}
+
+/*
+Middleware validates the request Bearer token and adds the
+claims into the request context (see ClaimsFromContext),
+requests without a valid token receive a 401
+*/
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		c, err := v.VerifyRequest(r)
+		if err != nil {
+			rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description="%s"`, err.Error()))
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, r.WithContext(WithClaims(r.Context(), c)))
+	})
+}
+
+/*
+VerifyRequest validates the request Bearer token (certificate
+bound tokens must be sent using the same TLS client certificate)
+*/
+func (v *Verifier) VerifyRequest(r *http.Request) (*Claims, error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, ErrMissingToken
+	}
+	c, err := v.Verify(r.Context(), strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil {
+		return nil, err
+	}
+	if c.CertThumbprint != "" {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return nil, ErrInvalidBinding
+		}
+		h := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		if subtle.ConstantTimeCompare([]byte(jose.Encode(h[:])), []byte(c.CertThumbprint)) != 1 {
+			return nil, ErrInvalidBinding
+		}
+	}
+	return c, nil
+}
+
+/*
+WithClaims returns a copy of the context with the claims
+*/
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+/*
+ClaimsFromContext returns the claims added by the Middleware
+*/
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok && c != nil
+}
@@ -0,0 +1,232 @@
+/*
+Package verifier validates the tokens issued by the auth
+server without any database dependency, so downstream
+services can verify tokens using just the signing secret,
+a static key or the auth server JWKS. Revoked tokens can't
+be detected here (use the introspection endpoint for it).
+*/
+package verifier
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/jose"
+)
+
+var (
+	// ErrMissingToken is returned when the request has no token
+	ErrMissingToken = errors.New("auth.jwt.validation.token.missing")
+	// ErrInvalidFormat is returned when the token can't be parsed
+	ErrInvalidFormat = errors.New("auth.jwt.validation.format.invalid")
+	// ErrInvalidSignature is returned when the token signature can't be verified
+	ErrInvalidSignature = errors.New("auth.jwt.validation.sign.invalid")
+	// ErrExpiredToken is returned when the token is expired
+	ErrExpiredToken = errors.New("auth.jwt.validation.token.expired")
+	// ErrNotValidYet is returned when the token `nbf` is in the future
+	ErrNotValidYet = errors.New("auth.jwt.validation.token.not.valid.yet")
+	// ErrIssuedInFuture is returned when the token issued time is in the future
+	ErrIssuedInFuture = errors.New("auth.jwt.validation.token.issued.in.future")
+	// ErrTooOld is returned when the token is older than the max age
+	ErrTooOld = errors.New("auth.jwt.validation.token.too.old")
+	// ErrInvalidTime is returned when a time claim can't be parsed
+	ErrInvalidTime = errors.New("auth.jwt.validation.time.invalid")
+	// ErrMissingClaim is returned when a required claim is missing
+	ErrMissingClaim = errors.New("auth.jwt.validation.claim.missing")
+	// ErrInvalidIssuer is returned when the token issuer is not accepted
+	ErrInvalidIssuer = errors.New("auth.jwt.validation.issuer.invalid")
+	// ErrInvalidAudience is returned when the token audience is not accepted
+	ErrInvalidAudience = errors.New("auth.jwt.validation.audience.invalid")
+	// ErrInvalidBinding is returned when the token certificate binding doesn't match
+	ErrInvalidBinding = errors.New("auth.jwt.validation.cnf.invalid")
+	// ErrInvalidTokenType is returned when the token is not an access token (eg: an ID token)
+	ErrInvalidTokenType = errors.New("auth.jwt.validation.token.type.invalid")
+)
+
+// subject types of the access tokens (`sub_type` claim)
+var subjectTypes = []string{"", "user", "client", "service"}
+
+/*
+Verifier validates tokens signature and claims
+*/
+type Verifier struct {
+	keys           KeySource
+	issuers        []string
+	audiences      []string
+	leeway         time.Duration
+	maxAge         time.Duration
+	requiredClaims []string
+	foreignTokens  bool
+	clock          func() time.Time
+}
+
+/*
+Option customizes the verifier
+*/
+type Option func(*Verifier)
+
+/*
+WithIssuers accepts only tokens issued by one of the issuers
+*/
+func WithIssuers(issuers ...string) Option {
+	return func(v *Verifier) {
+		v.issuers = append(v.issuers, issuers...)
+	}
+}
+
+/*
+WithAudiences accepts only tokens issued to one of the audiences
+*/
+func WithAudiences(audiences ...string) Option {
+	return func(v *Verifier) {
+		v.audiences = append(v.audiences, audiences...)
+	}
+}
+
+/*
+WithLeeway defines the clock skew tolerated when
+validating the token times
+*/
+func WithLeeway(leeway time.Duration) Option {
+	return func(v *Verifier) {
+		v.leeway = leeway
+	}
+}
+
+/*
+WithMaxAge rejects tokens issued more than maxAge ago
+*/
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(v *Verifier) {
+		v.maxAge = maxAge
+	}
+}
+
+/*
+WithRequiredClaims rejects tokens missing any of the
+claims (payload names, eg: expires)
+*/
+func WithRequiredClaims(claims ...string) Option {
+	return func(v *Verifier) {
+		v.requiredClaims = append(v.requiredClaims, claims...)
+	}
+}
+
+/*
+WithForeignTokens accepts access tokens issued by other servers
+(without the `user` claim, the subject is taken from `sub`). As
+OpenID Connect ID tokens are signed with the same keys, they are
+accepted only if an audience is required (WithAudiences).
+*/
+func WithForeignTokens() Option {
+	return func(v *Verifier) {
+		v.foreignTokens = true
+	}
+}
+
+/*
+WithClock replaces the clock used to validate the token times
+*/
+func WithClock(clock func() time.Time) Option {
+	return func(v *Verifier) {
+		v.clock = clock
+	}
+}
+
+/*
+New creates a verifier using the key source
+*/
+func New(keys KeySource, opts ...Option) *Verifier {
+	v := &Verifier{
+		keys:  keys,
+		clock: time.Now,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+/*
+Verify validates the token returning its claims, only access
+tokens are accepted (ID tokens are rejected, see WithForeignTokens)
+*/
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	t, err := jose.Parse(token)
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	key, err := v.keys.Key(ctx, t.Header)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if err := t.Verify(key); err != nil {
+		return nil, ErrInvalidSignature
+	}
+	var raw map[string]interface{}
+	if err := t.Claims(&raw); err != nil {
+		return nil, ErrInvalidFormat
+	}
+	for _, claim := range v.requiredClaims {
+		if _, ok := raw[claim]; !ok {
+			return nil, ErrMissingClaim
+		}
+	}
+	c, err := ParseClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.validate(c); err != nil {
+		return nil, err
+	}
+	if err := v.validateType(raw, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+/*
+validateType checks the token is an access token, the library
+access tokens have the `user` claim (ID tokens don't)
+*/
+func (v *Verifier) validateType(raw map[string]interface{}, c *Claims) error {
+	if !contains(subjectTypes, c.SubjectType) {
+		return ErrInvalidTokenType
+	}
+	if _, ok := raw["user"]; ok {
+		return nil
+	}
+	if v.foreignTokens && len(v.audiences) > 0 {
+		return nil
+	}
+	return ErrInvalidTokenType
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := v.clock()
+	if !c.ExpiresAt.IsZero() && now.After(c.ExpiresAt.Add(v.leeway)) {
+		return ErrExpiredToken
+	}
+	if !c.NotBefore.IsZero() && now.Add(v.leeway).Before(c.NotBefore) {
+		return ErrNotValidYet
+	}
+	if !c.IssuedAt.IsZero() && now.Add(v.leeway).Before(c.IssuedAt) {
+		return ErrIssuedInFuture
+	}
+	if v.maxAge > 0 && (c.IssuedAt.IsZero() || now.Sub(c.IssuedAt) > v.maxAge+v.leeway) {
+		return ErrTooOld
+	}
+	if len(v.issuers) > 0 && !contains(v.issuers, c.Issuer) {
+		return ErrInvalidIssuer
+	}
+	if len(v.audiences) > 0 {
+		for _, aud := range c.Audience {
+			if contains(v.audiences, aud) {
+				return nil
+			}
+		}
+		return ErrInvalidAudience
+	}
+	return nil
+}
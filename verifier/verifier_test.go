@@ -0,0 +1,159 @@
+package verifier
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eldius/jwt-auth-go/jose"
+)
+
+var secret = []byte("my-secret")
+
+func sign(t *testing.T, claims map[string]interface{}, key *jose.Key) string {
+	token, err := jose.Sign(claims, key)
+	if err != nil {
+		t.Errorf("Failed to sign token: %s", err.Error())
+		t.FailNow()
+	}
+	return token
+}
+
+func TestVerifySecret(t *testing.T) {
+	now := time.Now()
+	v := New(Secret(secret), WithIssuers("https://auth.example.com"), WithAudiences("billing"))
+
+	token := sign(t, map[string]interface{}{
+		"user":    "someone",
+		"name":    "Someone",
+		"scope":   "invoices:read",
+		"iss":     "https://auth.example.com",
+		"aud":     "billing",
+		"expires": now.Add(time.Hour).Format(time.RFC3339),
+		"issued":  now.Format(time.RFC3339),
+		"tenant":  "acme",
+	}, jose.NewHMACKey("", secret))
+	c, err := v.Verify(context.Background(), token)
+	if err != nil {
+		t.Errorf("Must not return error: '%s'", err.Error())
+		t.FailNow()
+	}
+	if c.Subject != "someone" || !c.HasScopes("invoices:read") || c.Extra["tenant"] != "acme" || c.ExpiresAt.IsZero() {
+		t.Errorf("Invalid claims '%v'", c)
+	}
+
+	for _, test := range []struct {
+		name   string
+		claims map[string]interface{}
+		key    *jose.Key
+		err    error
+	}{
+		{"wrong secret", map[string]interface{}{"user": "someone"}, jose.NewHMACKey("", []byte("other")), ErrInvalidSignature},
+		{"expired", map[string]interface{}{"iss": "https://auth.example.com", "aud": "billing", "exp": now.Add(-time.Minute).Unix()}, jose.NewHMACKey("", secret), ErrExpiredToken},
+		{"other audience", map[string]interface{}{"iss": "https://auth.example.com", "aud": []string{"admin"}}, jose.NewHMACKey("", secret), ErrInvalidAudience},
+		{"other issuer", map[string]interface{}{"iss": "https://other.example.com", "aud": "billing"}, jose.NewHMACKey("", secret), ErrInvalidIssuer},
+	} {
+		if _, err := v.Verify(context.Background(), sign(t, test.claims, test.key)); err != test.err {
+			t.Errorf("%s: must return '%v', but was '%v'", test.name, test.err, err)
+		}
+	}
+}
+
+func TestVerifyTokenType(t *testing.T) {
+	key := jose.NewHMACKey("", secret)
+	idToken := sign(t, map[string]interface{}{"sub": "someone", "aud": "web-app", "nonce": "n"}, key)
+
+	for _, test := range []struct {
+		name  string
+		v     *Verifier
+		token string
+		err   error
+	}{
+		{"ID token", New(Secret(secret)), idToken, ErrInvalidTokenType},
+		{"foreign token without audience", New(Secret(secret), WithForeignTokens()), idToken, ErrInvalidTokenType},
+		{"foreign token to another audience", New(Secret(secret), WithForeignTokens(), WithAudiences("billing")), idToken, ErrInvalidAudience},
+		{"foreign token", New(Secret(secret), WithForeignTokens(), WithAudiences("web-app")), idToken, nil},
+		{"unknown subject type", New(Secret(secret)), sign(t, map[string]interface{}{"user": "someone", "sub_type": "id"}, key), ErrInvalidTokenType},
+		{"client token", New(Secret(secret)), sign(t, map[string]interface{}{"user": "backend", "sub_type": "client"}, key), nil},
+	} {
+		if _, err := test.v.Verify(context.Background(), test.token); err != test.err {
+			t.Errorf("%s: must return '%v', but was '%v'", test.name, test.err, err)
+		}
+	}
+}
+
+func TestVerifyRemoteJWKS(t *testing.T) {
+	pk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key := jose.NewRSAKey("key-1", pk, &pk.PublicKey)
+	var fetches int32
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		jwk, _ := key.PublicJWK()
+		_ = json.NewEncoder(rw).Encode(jose.JWKS{Keys: []jose.JWK{jwk}})
+	}))
+	defer s.Close()
+
+	keys := RemoteJWKS(s.URL, s.Client(), 50*time.Millisecond)
+	defer keys.Close()
+	v := New(keys)
+
+	token := sign(t, map[string]interface{}{"user": "service"}, key)
+	c, err := v.Verify(context.Background(), token)
+	if err != nil || c.Subject != "service" {
+		t.Errorf("Must verify the token using the JWKS: '%v' '%v'", err, c)
+	}
+	// HS256 tokens must not be accepted from a JWKS source
+	if _, err := v.Verify(context.Background(), sign(t, map[string]interface{}{"user": "service"}, jose.NewHMACKey("key-1", secret))); err != ErrInvalidSignature {
+		t.Errorf("Must reject other algorithms, but was '%v'", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if atomic.LoadInt32(&fetches) < 2 {
+		t.Errorf("Should refresh the keys in background, but fetched '%d' times", fetches)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	v := New(Secret(secret))
+	s := httptest.NewServer(v.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		c, ok := ClaimsFromContext(r.Context())
+		if !ok || c.Subject != "someone" {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})))
+	defer s.Close()
+
+	for _, test := range []struct {
+		token  string
+		status int
+	}{
+		{sign(t, map[string]interface{}{"user": "someone"}, jose.NewHMACKey("", secret)), http.StatusNoContent},
+		{"invalid", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+		if test.token != "" {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", test.token))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Failed to execute request: %v", err)
+			t.FailNow()
+		}
+		if res.StatusCode != test.status {
+			t.Errorf("Should return '%d', but was '%s'", test.status, res.Status)
+		}
+		if test.status == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "" {
+			t.Error("Should return the Bearer challenge")
+		}
+	}
+}